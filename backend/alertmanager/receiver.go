@@ -0,0 +1,254 @@
+// Package alertmanager turns alertmanager webhook notifications into incidents
+package alertmanager
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"maps"
+	"pluralkit/status/db"
+	"pluralkit/status/util"
+	"slices"
+	"strings"
+	"sync"
+)
+
+type Receiver struct {
+	logger     *slog.Logger
+	database   *db.DB
+	rules      util.AlertRules
+	severities util.AlertSeverities
+
+	mutex sync.Mutex // held while handling a notification, so notifications for a group can't race each other
+}
+
+func NewReceiver(config util.Config, logger *slog.Logger, database *db.DB) *Receiver {
+	moduleLogger := logger.With(slog.String("module", "alertmanager"))
+	return &Receiver{
+		logger:     moduleLogger,
+		database:   database,
+		rules:      config.AlertRules,
+		severities: config.AlertSeverities,
+	}
+}
+
+// opens, updates or resolves the incident for the notification's alert group
+func (r *Receiver) Receive(ctx context.Context, payload util.AlertmanagerPayload) (util.AlertmanagerResult, error) {
+	ignored := util.AlertmanagerResult{Action: "ignored"}
+	err := util.Validate.Struct(payload)
+	if err != nil {
+		return ignored, util.ErrInvalid
+	}
+
+	labels := payload.CommonLabels
+	if len(labels) == 0 {
+		labels = payload.GroupLabels
+	}
+	rule, ok := r.rules.Match(labels)
+	if !ok {
+		r.logger.Debug("ignoring alert group", slog.String("group", payload.GroupKey))
+		return ignored, nil
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	firing := make(map[string]string)
+	if payload.Status == "firing" {
+		for _, alert := range payload.Alerts {
+			if alert.Status == "firing" {
+				firing[alert.Key()] = alert.Name()
+			}
+		}
+	}
+
+	group, err := r.database.GetAlertGroup(ctx, payload.GroupKey)
+	exists := err == nil
+	if err != nil && !errors.Is(err, util.ErrNotFound) {
+		return ignored, err
+	}
+	open := exists && r.incidentOpen(ctx, group.IncidentID)
+
+	if len(firing) == 0 {
+		if !exists {
+			return ignored, nil
+		}
+		result := ignored
+		if open {
+			result, err = r.resolve(ctx, group)
+			if err != nil {
+				return result, err
+			}
+		}
+		// the next time the group fires is a new incident
+		return result, r.database.DeleteAlertGroup(ctx, payload.GroupKey)
+	}
+
+	impact := r.impact(rule, payload.Alerts)
+	if !open {
+		// if the incident was resolved or deleted by hand while alerts kept firing, only open a new one once another alert starts
+		if exists && !hasNew(group.Alerts, firing) {
+			group.Alerts = firing
+			return ignored, r.database.SaveAlertGroup(ctx, group)
+		}
+		return r.create(ctx, payload, rule, impact, firing)
+	}
+	return r.update(ctx, group, impact, firing)
+}
+
+func (r *Receiver) create(ctx context.Context, payload util.AlertmanagerPayload, rule util.AlertRule, impact util.Impact, firing map[string]string) (util.AlertmanagerResult, error) {
+	name := payload.CommonAnnotations["summary"]
+	if name == "" {
+		name = payload.CommonLabels["alertname"]
+	}
+	if name == "" {
+		name = "Alerts firing"
+	}
+	description := payload.CommonAnnotations["description"]
+	if description == "" {
+		description = "Firing: " + names(firing) + "."
+	}
+
+	incident := util.Incident{
+		Name:        truncate(name, 100),
+		Description: truncate(description, 1800),
+		Status:      util.StatusInvestigating,
+		Impact:      impact,
+		Components:  rule.Components,
+	}
+	id, err := r.database.CreateIncident(ctx, incident)
+	if err != nil {
+		return util.AlertmanagerResult{Action: "ignored"}, err
+	}
+	r.logger.Info("opened incident for alert group", slog.String("group", payload.GroupKey), slog.String("incident", id))
+
+	err = r.database.SaveAlertGroup(ctx, util.AlertGroup{
+		GroupKey:   payload.GroupKey,
+		IncidentID: id,
+		Alerts:     firing,
+		Impact:     impact,
+	})
+	return util.AlertmanagerResult{Action: "created", IncidentID: id}, err
+}
+
+// posts an update if alerts started or stopped firing, and changes the impact if their severity did
+func (r *Receiver) update(ctx context.Context, group util.AlertGroup, impact util.Impact, firing map[string]string) (util.AlertmanagerResult, error) {
+	result := util.AlertmanagerResult{Action: "ignored", IncidentID: group.IncidentID}
+
+	started := make(map[string]string)
+	for key, name := range firing {
+		if _, ok := group.Alerts[key]; !ok {
+			started[key] = name
+		}
+	}
+	stopped := make(map[string]string)
+	for key, name := range group.Alerts {
+		if _, ok := firing[key]; !ok {
+			stopped[key] = name
+		}
+	}
+
+	if len(started) > 0 || len(stopped) > 0 {
+		text := make([]string, 0, 2)
+		if len(started) > 0 {
+			text = append(text, "Now firing: "+names(started)+".")
+		}
+		if len(stopped) > 0 {
+			text = append(text, "No longer firing: "+names(stopped)+".")
+		}
+		_, err := r.database.CreateUpdate(ctx, util.IncidentUpdate{
+			IncidentID: group.IncidentID,
+			Text:       truncate(strings.Join(text, "\n"), 1800),
+		})
+		if err != nil {
+			return result, err
+		}
+		result.Action = "updated"
+	}
+	if impact != group.Impact {
+		err := r.database.EditIncident(ctx, group.IncidentID, util.IncidentPatch{Impact: &impact})
+		if err != nil {
+			return result, err
+		}
+		result.Action = "updated"
+	}
+	if result.Action == "ignored" {
+		// alertmanager repeats notifications for groups that haven't changed
+		return result, nil
+	}
+
+	group.Alerts = firing
+	group.Impact = impact
+	return result, r.database.SaveAlertGroup(ctx, group)
+}
+
+func (r *Receiver) resolve(ctx context.Context, group util.AlertGroup) (util.AlertmanagerResult, error) {
+	resolved := util.StatusResolved
+	_, err := r.database.CreateUpdate(ctx, util.IncidentUpdate{
+		IncidentID: group.IncidentID,
+		Text:       "All alerts have resolved.",
+		Status:     &resolved,
+	})
+	if err != nil {
+		return util.AlertmanagerResult{Action: "ignored"}, err
+	}
+	r.logger.Info("resolved incident for alert group", slog.String("group", group.GroupKey), slog.String("incident", group.IncidentID))
+	return util.AlertmanagerResult{Action: "resolved", IncidentID: group.IncidentID}, nil
+}
+
+// the rule's impact, or the highest impact of the firing alerts' severities. unknown severities are minor
+func (r *Receiver) impact(rule util.AlertRule, alerts []util.AlertmanagerAlert) util.Impact {
+	if rule.Impact != "" {
+		return rule.Impact
+	}
+	impact := util.ImpactNone
+	for _, alert := range alerts {
+		if alert.Status != "firing" {
+			continue
+		}
+		severity, ok := r.severities[alert.Labels["severity"]]
+		if !ok {
+			severity = util.ImpactMinor
+		}
+		if severity.IsGreater(impact) {
+			impact = severity
+		}
+	}
+	return impact
+}
+
+// returns true if the incident exists and isn't resolved yet
+func (r *Receiver) incidentOpen(ctx context.Context, id string) bool {
+	incident, err := r.database.GetIncident(ctx, id)
+	if err != nil {
+		if !errors.Is(err, util.ErrNotFound) {
+			r.logger.Error("error while getting alert group incident", slog.String("incident", id), slog.Any("error", err))
+		}
+		return false
+	}
+	return incident.Status != util.StatusResolved
+}
+
+// returns true if any alert in current isn't in previous
+func hasNew(previous map[string]string, current map[string]string) bool {
+	for key := range current {
+		if _, ok := previous[key]; !ok {
+			return true
+		}
+	}
+	return false
+}
+
+// alert names in alphabetical order
+func names(alerts map[string]string) string {
+	list := slices.Sorted(maps.Values(alerts))
+	return strings.Join(list, ", ")
+}
+
+func truncate(text string, length int) string {
+	runes := []rune(text)
+	if len(runes) <= length {
+		return text
+	}
+	return string(runes[:length-1]) + "…"
+}
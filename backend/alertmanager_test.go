@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"pluralkit/status/api"
+	"pluralkit/status/util"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAlertmanager(t *testing.T) {
+	database, _ := setupTestFileDB(t)
+	ctx := context.Background()
+
+	cfg := util.Config{AuthToken: testAuthToken}
+	require.NoError(t, cfg.AlertRules.UnmarshalText([]byte(`[
+		{"matchers": {"alertname": "Watchdog"}, "drop": true},
+		{"matchers": {"team": "pluralkit|pk"}, "components": ["api"]}
+	]`)))
+	require.NoError(t, cfg.AlertSeverities.UnmarshalText([]byte("critical:major,warning:minor,info:none")))
+	apiInstance := api.NewAPI(cfg, slog.Default(), database)
+	router := chi.NewRouter()
+	router.Use(render.SetContentType(render.ContentTypeJSON))
+	apiInstance.SetupRoutes(router)
+
+	type alert struct {
+		name     string
+		severity string
+		firing   bool
+	}
+	notify := func(groupKey string, team string, alerts ...alert) (int, util.AlertmanagerResult) {
+		payload := util.AlertmanagerPayload{
+			Version:           "4",
+			GroupKey:          groupKey,
+			Status:            "resolved",
+			GroupLabels:       map[string]string{"team": team},
+			CommonLabels:      map[string]string{"team": team},
+			CommonAnnotations: map[string]string{"summary": "API errors"},
+		}
+		for _, a := range alerts {
+			status := "resolved"
+			if a.firing {
+				status = "firing"
+				payload.Status = "firing"
+			}
+			payload.Alerts = append(payload.Alerts, util.AlertmanagerAlert{
+				Status:      status,
+				Labels:      map[string]string{"alertname": a.name, "severity": a.severity, "team": team},
+				Fingerprint: a.name,
+			})
+		}
+		// like alertmanager, common labels are the ones every alert shares
+		for label, value := range payload.Alerts[0].Labels {
+			if !slices.ContainsFunc(payload.Alerts, func(a util.AlertmanagerAlert) bool { return a.Labels[label] != value }) {
+				payload.CommonLabels[label] = value
+			}
+		}
+		body, err := json.Marshal(payload)
+		require.NoError(t, err)
+
+		req, _ := http.NewRequest(http.MethodPost, "/api/v1/admin/integrations/alertmanager", strings.NewReader(string(body)))
+		req.Header.Set("Authorization", "Bearer "+testAuthToken)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		var result util.AlertmanagerResult
+		if rr.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &result))
+		}
+		return rr.Code, result
+	}
+	getIncident := func(id string) util.Incident {
+		incident, err := database.GetIncident(ctx, id)
+		require.NoError(t, err)
+		return incident
+	}
+	activeIncidents := func() int {
+		list, err := database.GetActiveIncidents(ctx)
+		require.NoError(t, err)
+		return len(list.Incidents)
+	}
+
+	t.Run("auth and validation", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodPost, "/api/v1/admin/integrations/alertmanager", strings.NewReader(`{}`))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+
+		code, _ := notify("", "pluralkit", alert{"HighErrorRate", "warning", true})
+		assert.Equal(t, http.StatusBadRequest, code)
+	})
+
+	t.Run("unmatched and dropped groups", func(t *testing.T) {
+		code, result := notify("{}:{team=\"other\"}", "other", alert{"HighErrorRate", "critical", true})
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, "ignored", result.Action)
+
+		code, result = notify("{}:{alertname=\"Watchdog\"}", "pk", alert{"Watchdog", "none", true})
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, "ignored", result.Action)
+		assert.Equal(t, 0, activeIncidents())
+	})
+
+	// a group starting to fire opens an incident on the rule's components
+	group := "{}:{team=\"pluralkit\"}"
+	_, result := notify(group, "pluralkit", alert{"HighErrorRate", "warning", true})
+	require.Equal(t, "created", result.Action)
+	incident := getIncident(result.IncidentID)
+	assert.Equal(t, "API errors", incident.Name) // from the summary annotation
+	assert.Equal(t, "Firing: HighErrorRate.", incident.Description)
+	assert.Equal(t, util.ImpactMinor, incident.Impact)
+	assert.Equal(t, util.StatusInvestigating, incident.Status)
+	assert.Equal(t, []string{"api"}, incident.Components)
+	incidentID := result.IncidentID
+
+	// repeated notifications are deduplicated by group key
+	_, result = notify(group, "pluralkit", alert{"HighErrorRate", "warning", true})
+	assert.Equal(t, "ignored", result.Action)
+	assert.Empty(t, getIncident(incidentID).Updates)
+	assert.Equal(t, 1, activeIncidents())
+
+	// new alerts in the group post an update and raise the impact
+	_, result = notify(group, "pluralkit", alert{"HighErrorRate", "warning", true}, alert{"HighLatency", "critical", true})
+	assert.Equal(t, "updated", result.Action)
+	assert.Equal(t, incidentID, result.IncidentID)
+	incident = getIncident(incidentID)
+	assert.Equal(t, util.ImpactMajor, incident.Impact)
+	require.Len(t, incident.Updates, 1)
+	assert.Equal(t, "Now firing: HighLatency.", incident.Updates[0].Text)
+
+	_, result = notify(group, "pluralkit", alert{"HighErrorRate", "warning", false}, alert{"HighLatency", "critical", true})
+	assert.Equal(t, "updated", result.Action)
+	incident = getIncident(incidentID)
+	assert.Equal(t, util.ImpactMajor, incident.Impact)
+	require.Len(t, incident.Updates, 2)
+	assert.Contains(t, []string{incident.Updates[0].Text, incident.Updates[1].Text}, "No longer firing: HighErrorRate.")
+
+	// resolving the group resolves the incident
+	_, result = notify(group, "pluralkit", alert{"HighErrorRate", "warning", false}, alert{"HighLatency", "critical", false})
+	assert.Equal(t, "resolved", result.Action)
+	incident = getIncident(incidentID)
+	assert.Equal(t, util.StatusResolved, incident.Status)
+	assert.Equal(t, 0, activeIncidents())
+	_, err := database.GetAlertGroup(ctx, group)
+	assert.ErrorIs(t, err, util.ErrNotFound)
+
+	_, result = notify(group, "pluralkit", alert{"HighLatency", "critical", false})
+	assert.Equal(t, "ignored", result.Action)
+
+	t.Run("firing again", func(t *testing.T) {
+		_, result := notify(group, "pk", alert{"HighLatency", "info", true})
+		require.Equal(t, "created", result.Action)
+		assert.NotEqual(t, incidentID, result.IncidentID)
+		assert.Equal(t, util.ImpactNone, getIncident(result.IncidentID).Impact)
+
+		// once it's resolved by hand, it stays that way until another alert fires
+		resolved := util.StatusResolved
+		require.NoError(t, database.EditIncident(ctx, result.IncidentID, util.IncidentPatch{Status: &resolved}))
+		_, repeated := notify(group, "pk", alert{"HighLatency", "info", true})
+		assert.Equal(t, "ignored", repeated.Action)
+		assert.Equal(t, 0, activeIncidents())
+
+		_, reopened := notify(group, "pk", alert{"HighLatency", "info", true}, alert{"HighErrorRate", "warning", true})
+		require.Equal(t, "created", reopened.Action)
+		assert.NotEqual(t, result.IncidentID, reopened.IncidentID)
+		assert.Equal(t, util.ImpactMinor, getIncident(reopened.IncidentID).Impact)
+	})
+
+	t.Run("invalid config", func(t *testing.T) {
+		var rules util.AlertRules
+		assert.Error(t, rules.UnmarshalText([]byte(`[{"matchers": {"team": "("}}]`)))
+		assert.Error(t, rules.UnmarshalText([]byte(`[{"impact": "huge"}]`)))
+		var severities util.AlertSeverities
+		assert.Error(t, severities.UnmarshalText([]byte("critical:huge")))
+		assert.Error(t, severities.UnmarshalText([]byte("critical")))
+	})
+}
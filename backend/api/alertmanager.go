@@ -0,0 +1,34 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"pluralkit/status/util"
+
+	"github.com/go-chi/render"
+)
+
+// alertmanager's webhook receiver, configured with the auth token as a bearer token
+func (a *API) ReceiveAlertmanager(w http.ResponseWriter, r *http.Request) {
+	var payload util.AlertmanagerPayload
+	err := json.NewDecoder(r.Body).Decode(&payload)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		a.Logger.Error("error while parsing alertmanager notification", slog.Any("error", err))
+		return
+	}
+
+	result, err := a.Alerts.Receive(r.Context(), payload)
+	if err != nil {
+		if errors.Is(err, util.ErrInvalid) {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		a.Logger.Error("error while handling alertmanager notification", slog.Any("error", err))
+		return
+	}
+	render.JSON(w, r, result)
+}
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"pluralkit/status/util"
+	"time"
+
+	"github.com/go-chi/render"
+)
+
+func (a *API) ExportArchive(w http.ResponseWriter, r *http.Request) {
+	archive, err := a.Database.ExportArchive(r.Context())
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		a.Logger.Error("error while exporting archive", slog.Any("error", err))
+		return
+	}
+
+	filename := fmt.Sprintf("status-%s.json", archive.ExportedAt.UTC().Format("20060102-150405"))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	if err := render.Render(w, r, &archive); err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		a.Logger.Error("error while rendering json for export request", slog.Any("error", err))
+		return
+	}
+}
+
+func (a *API) ImportArchive(w http.ResponseWriter, r *http.Request) {
+	var archive util.Archive
+	err := json.NewDecoder(r.Body).Decode(&archive)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		a.Logger.Error("error while parsing archive data", slog.Any("error", err))
+		return
+	}
+
+	opts := util.ImportOptions{
+		DryRun:        r.URL.Query().Get("dry_run") == "true",
+		SkipConflicts: r.URL.Query().Get("on_conflict") == "skip",
+	}
+
+	start := time.Now()
+	result, err := a.Database.ImportArchive(r.Context(), archive, opts)
+	if err != nil {
+		if errors.Is(err, util.ErrInvalid) {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		} else if errors.Is(err, util.ErrConflict) {
+			render.Status(r, http.StatusConflict)
+			if err := render.Render(w, r, &result); err != nil {
+				a.Logger.Error("error while rendering json for import request", slog.Any("error", err))
+			}
+			return
+		}
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		a.Logger.Error("error while importing archive", slog.Any("error", err))
+		return
+	}
+
+	a.Logger.Info("imported archive",
+		slog.Bool("dry_run", result.DryRun),
+		slog.Int("incidents", result.Incidents),
+		slog.Int("updates", result.Updates),
+		slog.Int("conflicts", len(result.Conflicts)),
+		slog.Duration("took", time.Since(start)),
+	)
+	if err := render.Render(w, r, &result); err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		a.Logger.Error("error while rendering json for import request", slog.Any("error", err))
+		return
+	}
+}
@@ -0,0 +1,132 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"pluralkit/status/util"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+)
+
+func endpointID(r *http.Request) (int64, error) {
+	return strconv.ParseInt(chi.URLParam(r, "endpointID"), 10, 64)
+}
+
+func (a *API) GetWebhookEndpoints(w http.ResponseWriter, r *http.Request) {
+	endpoints, err := a.Database.GetEndpoints(r.Context())
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		a.Logger.Error("error while getting webhook endpoints", slog.Any("error", err))
+		return
+	}
+	// secrets are only shown once, when the endpoint is created
+	for i := range endpoints {
+		endpoints[i].Secret = ""
+	}
+	render.JSON(w, r, endpoints)
+}
+
+func (a *API) CreateWebhookEndpoint(w http.ResponseWriter, r *http.Request) {
+	var endpoint util.WebhookEndpoint
+	err := json.NewDecoder(r.Body).Decode(&endpoint)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		a.Logger.Error("error while parsing webhook endpoint data", slog.Any("error", err))
+		return
+	}
+
+	created, err := a.Database.CreateEndpoint(r.Context(), endpoint)
+	if err != nil {
+		if errors.Is(err, util.ErrInvalid) {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		a.Logger.Error("error while creating webhook endpoint", slog.Any("error", err))
+		return
+	}
+	render.Status(r, http.StatusCreated)
+	render.JSON(w, r, created)
+}
+
+func (a *API) EditWebhookEndpoint(w http.ResponseWriter, r *http.Request) {
+	id, err := endpointID(r)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	var patch util.WebhookEndpointPatch
+	err = json.NewDecoder(r.Body).Decode(&patch)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		a.Logger.Error("error while parsing webhook endpoint data", slog.Any("error", err))
+		return
+	}
+
+	endpoint, err := a.Database.EditEndpoint(r.Context(), id, patch)
+	if err != nil {
+		if errors.Is(err, util.ErrNotFound) {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		} else if errors.Is(err, util.ErrInvalid) {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		a.Logger.Error("error while editing webhook endpoint", slog.Any("error", err))
+		return
+	}
+	endpoint.Secret = ""
+	render.JSON(w, r, endpoint)
+}
+
+func (a *API) DeleteWebhookEndpoint(w http.ResponseWriter, r *http.Request) {
+	id, err := endpointID(r)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	err = a.Database.DeleteEndpoint(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, util.ErrNotFound) {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		a.Logger.Error("error while deleting webhook endpoint", slog.Any("error", err))
+		return
+	}
+}
+
+func (a *API) GetWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	id, err := endpointID(r)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	_, err = a.Database.GetEndpoint(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, util.ErrNotFound) {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		a.Logger.Error("error while getting webhook endpoint", slog.Any("error", err))
+		return
+	}
+
+	deliveries, err := a.Database.GetDeliveries(r.Context(), id)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		a.Logger.Error("error while getting webhook deliveries", slog.Any("error", err))
+		return
+	}
+	render.JSON(w, r, deliveries)
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"log/slog"
+	"net/http"
+	"pluralkit/status/backup"
+	"pluralkit/status/shards"
+
+	"github.com/go-chi/render"
+)
+
+type readiness struct {
+	Ready    bool           `json:"ready"`
+	Database string         `json:"database"`
+	Backups  *backup.Status `json:"backups,omitempty"`
+	Shards   shards.Status  `json:"shards"`
+}
+
+// readiness check for load balancers/orchestrators, only fails if the database can't be reached.
+// failing backups and shard polling are reported but don't make us unready, since we can still serve requests fine
+func (a *API) GetReady(w http.ResponseWriter, r *http.Request) {
+	data := readiness{
+		Ready:    true,
+		Database: "ok",
+	}
+
+	err := a.Database.Ping(r.Context())
+	if err != nil {
+		data.Ready = false
+		data.Database = err.Error()
+		a.Logger.Error("database ping failed during readiness check", slog.Any("error", err))
+	}
+
+	if a.Backups != nil {
+		status, err := a.Backups.Status(false)
+		if err == nil {
+			data.Backups = &status
+		}
+	}
+
+	data.Shards = a.Shards.Status()
+
+	if !data.Ready {
+		render.Status(r, http.StatusServiceUnavailable)
+	}
+	render.JSON(w, r, data)
+}
+
+func (a *API) GetBackups(w http.ResponseWriter, r *http.Request) {
+	if a.Backups == nil {
+		render.JSON(w, r, backup.Status{Enabled: false})
+		return
+	}
+
+	status, err := a.Backups.Status(true)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		a.Logger.Error("error while listing backups", slog.Any("error", err))
+		return
+	}
+	if err := render.Render(w, r, &status); err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		a.Logger.Error("error while rendering json for backups request", slog.Any("error", err))
+		return
+	}
+}
+
+func (a *API) CreateBackup(w http.ResponseWriter, r *http.Request) {
+	if a.Backups == nil {
+		http.Error(w, "backups are not enabled", http.StatusNotFound)
+		return
+	}
+
+	created, err := a.Backups.BackupNow(r.Context())
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		a.Logger.Error("error while creating backup", slog.Any("error", err))
+		return
+	}
+	render.JSON(w, r, created)
+}
@@ -0,0 +1,283 @@
+package api
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"pluralkit/status/util"
+	"pluralkit/status/webhook"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-chi/render"
+)
+
+const defaultResolveText = "This incident has been resolved."
+
+// checks the signature discord sends with every interaction, see https://discord.com/developers/docs/interactions/overview#setting-up-an-endpoint-validating-security-request-headers
+func verifyInteraction(key ed25519.PublicKey, r *http.Request, body []byte) bool {
+	signature, err := hex.DecodeString(r.Header.Get("X-Signature-Ed25519"))
+	if err != nil || len(signature) != ed25519.SignatureSize {
+		return false
+	}
+	timestamp := r.Header.Get("X-Signature-Timestamp")
+	if timestamp == "" {
+		return false
+	}
+	return ed25519.Verify(key, append([]byte(timestamp), body...), signature)
+}
+
+// true if the member has one of the configured staff roles
+func (a *API) isStaff(member *webhook.InteractionMember) bool {
+	if member == nil {
+		return false
+	}
+	for _, role := range member.Roles {
+		if slices.Contains(a.Config.DiscordStaffRoles, role) {
+			return true
+		}
+	}
+	return false
+}
+
+func ephemeral(content string) webhook.InteractionResponse {
+	return webhook.InteractionResponse{
+		Type: webhook.CallbackMessage,
+		Data: &webhook.InteractionResponseData{
+			Content:         content,
+			Flags:           int(webhook.Ephemeral),
+			AllowedMentions: &webhook.AllowedMentions{},
+		},
+	}
+}
+
+func (a *API) DiscordInteraction(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		a.Logger.Error("error while getting body data", slog.Any("error", err))
+		return
+	}
+	if !verifyInteraction(a.discordKey, r, body) {
+		http.Error(w, "invalid request signature", http.StatusUnauthorized)
+		return
+	}
+
+	var interaction webhook.Interaction
+	err = json.Unmarshal(body, &interaction)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	var resp webhook.InteractionResponse
+	switch {
+	case interaction.Type == webhook.InteractionPing:
+		resp = webhook.InteractionResponse{Type: webhook.CallbackPong}
+	case !a.isStaff(interaction.Member):
+		resp = ephemeral("you don't have permission to manage incidents")
+	case interaction.Type == webhook.InteractionCommand && interaction.Data.Name == "incident":
+		resp = a.incidentCommand(interaction)
+	case interaction.Type == webhook.InteractionComponent:
+		resp = incidentModal(interaction.Data.CustomID)
+	case interaction.Type == webhook.InteractionModalSubmit:
+		resp = a.incidentModalSubmit(interaction)
+	default:
+		resp = ephemeral("unknown interaction")
+	}
+
+	render.JSON(w, r, resp)
+}
+
+// runs an action after telling discord we're working on it, then edits the response with the result.
+// creating incidents and updates waits for notifiers, which can take longer than discord's 3 second response window
+func (a *API) deferInteraction(interaction webhook.Interaction, action func(ctx context.Context) string) webhook.InteractionResponse {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		content := action(ctx)
+
+		url := fmt.Sprintf("%s/webhooks/%s/%s/messages/@original", strings.TrimSuffix(a.Config.DiscordAPIURL, "/"), interaction.ApplicationID, interaction.Token)
+		body := webhook.InteractionResponseData{Content: content, AllowedMentions: &webhook.AllowedMentions{}}
+		err := a.Discord.Do(ctx, http.MethodPatch, url, nil, body, nil)
+		if err != nil {
+			a.Logger.Error("error while responding to discord interaction", slog.Any("error", err))
+		}
+	}()
+
+	return webhook.InteractionResponse{
+		Type: webhook.CallbackDeferredMessage,
+		Data: &webhook.InteractionResponseData{Flags: int(webhook.Ephemeral)},
+	}
+}
+
+func (a *API) incidentCommand(interaction webhook.Interaction) webhook.InteractionResponse {
+	if len(interaction.Data.Options) != 1 {
+		return ephemeral("unknown command")
+	}
+	sub := interaction.Data.Options[0]
+	user := interaction.Member.User
+
+	switch sub.Name {
+	case "create":
+		incident := util.Incident{
+			Name:        webhook.OptionValue(sub.Options, "name"),
+			Description: webhook.OptionValue(sub.Options, "description"),
+			Impact:      util.Impact(webhook.OptionValue(sub.Options, "impact")),
+			Status:      util.IncidentStatus(webhook.OptionValue(sub.Options, "status")),
+		}
+		if incident.Status == "" {
+			incident.Status = util.StatusInvestigating
+		}
+		if !incident.Impact.IsValid() || !incident.Status.IsValid() {
+			return ephemeral("invalid impact or status")
+		}
+		return a.deferInteraction(interaction, func(ctx context.Context) string {
+			return a.createIncident(ctx, user, incident)
+		})
+	case "update", "resolve":
+		update := util.IncidentUpdate{
+			IncidentID: webhook.OptionValue(sub.Options, "id"),
+			Text:       webhook.OptionValue(sub.Options, "text"),
+		}
+		if sub.Name == "resolve" {
+			resolved := util.StatusResolved
+			update.Status = &resolved
+			if update.Text == "" {
+				update.Text = defaultResolveText
+			}
+		} else if status := util.IncidentStatus(webhook.OptionValue(sub.Options, "status")); status != "" {
+			if !status.IsValid() {
+				return ephemeral("invalid status")
+			}
+			update.Status = &status
+		}
+		return a.deferInteraction(interaction, func(ctx context.Context) string {
+			return a.createUpdate(ctx, user, update)
+		})
+	}
+	return ephemeral("unknown command")
+}
+
+// the buttons on incident messages open a modal to write the update in
+func incidentModal(customID string) webhook.InteractionResponse {
+	action, incidentID, ok := webhook.ParseIncidentButtonID(customID)
+	if !ok {
+		return ephemeral("unknown button")
+	}
+
+	text := webhook.ComponentBase{
+		Type:      int(webhook.TextInput),
+		CustomID:  "text",
+		Style:     int(webhook.TextInputParagraph),
+		Label:     "Update",
+		MaxLength: 1800,
+		Required:  boolPtr(true),
+	}
+	data := webhook.InteractionResponseData{CustomID: customID}
+	switch action {
+	case "update":
+		data.Title = "Post update"
+		data.Components = []webhook.ComponentBase{
+			{Type: int(webhook.ActionRow), Components: []webhook.ComponentBase{text}},
+			{Type: int(webhook.ActionRow), Components: []webhook.ComponentBase{{
+				Type:        int(webhook.TextInput),
+				CustomID:    "status",
+				Style:       int(webhook.TextInputShort),
+				Label:       "New status (optional)",
+				Placeholder: "investigating, identified, monitoring or resolved",
+				Required:    boolPtr(false),
+			}}},
+		}
+	case "resolve":
+		text.Label = "Resolution message"
+		text.Value = defaultResolveText
+		data.Title = "Resolve incident"
+		data.Components = []webhook.ComponentBase{
+			{Type: int(webhook.ActionRow), Components: []webhook.ComponentBase{text}},
+		}
+	default:
+		return ephemeral("unknown button")
+	}
+	data.Title = fmt.Sprintf("%s (%s)", data.Title, incidentID)
+	return webhook.InteractionResponse{Type: webhook.CallbackModal, Data: &data}
+}
+
+func (a *API) incidentModalSubmit(interaction webhook.Interaction) webhook.InteractionResponse {
+	action, incidentID, ok := webhook.ParseIncidentButtonID(interaction.Data.CustomID)
+	if !ok {
+		return ephemeral("unknown form")
+	}
+
+	update := util.IncidentUpdate{
+		IncidentID: incidentID,
+		Text:       strings.TrimSpace(interaction.Data.InputValue("text")),
+	}
+	switch action {
+	case "update":
+		if input := strings.ToLower(strings.TrimSpace(interaction.Data.InputValue("status"))); input != "" {
+			status := util.IncidentStatus(input)
+			if !status.IsValid() {
+				return ephemeral(fmt.Sprintf("`%s` isn't a valid status", input))
+			}
+			update.Status = &status
+		}
+	case "resolve":
+		resolved := util.StatusResolved
+		update.Status = &resolved
+		if update.Text == "" {
+			update.Text = defaultResolveText
+		}
+	default:
+		return ephemeral("unknown form")
+	}
+
+	user := interaction.Member.User
+	return a.deferInteraction(interaction, func(ctx context.Context) string {
+		return a.createUpdate(ctx, user, update)
+	})
+}
+
+func (a *API) createIncident(ctx context.Context, user webhook.DiscordUser, incident util.Incident) string {
+	id, err := a.Database.CreateIncident(ctx, incident)
+	if err != nil {
+		if errors.Is(err, util.ErrInvalid) {
+			return "invalid incident, names can be up to 100 characters and descriptions up to 1800"
+		}
+		a.Logger.Error("error while creating incident from discord", slog.Any("error", err))
+		return "something went wrong while creating the incident"
+	}
+
+	a.Logger.Info("incident created from discord", slog.String("incident", id), slog.String("user", user.ID), slog.String("username", user.Username))
+	return fmt.Sprintf("created incident `%s`: %s/i/%s", id, strings.TrimSuffix(a.Config.PublicURL, "/"), id)
+}
+
+func (a *API) createUpdate(ctx context.Context, user webhook.DiscordUser, update util.IncidentUpdate) string {
+	id, err := a.Database.CreateUpdate(ctx, update)
+	if err != nil {
+		if errors.Is(err, util.ErrNotFound) {
+			return fmt.Sprintf("incident `%s` doesn't exist", update.IncidentID)
+		} else if errors.Is(err, util.ErrInvalid) {
+			return "invalid update, the text can be up to 1800 characters"
+		}
+		a.Logger.Error("error while creating update from discord", slog.Any("error", err))
+		return "something went wrong while posting the update"
+	}
+
+	a.Logger.Info("update created from discord", slog.String("incident", update.IncidentID), slog.String("update", id), slog.String("user", user.ID), slog.String("username", user.Username))
+	if update.Status != nil && *update.Status == util.StatusResolved {
+		return fmt.Sprintf("resolved incident `%s`", update.IncidentID)
+	}
+	return fmt.Sprintf("posted update `%s` to incident `%s`", id, update.IncidentID)
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"pluralkit/status/util"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+)
+
+func monitorID(r *http.Request) (int64, error) {
+	return strconv.ParseInt(chi.URLParam(r, "monitorID"), 10, 64)
+}
+
+// services ping this to show they're up, the token in the url is the only authentication
+func (a *API) PingMonitor(w http.ResponseWriter, r *http.Request) {
+	_, err := a.Monitors.Ping(r.Context(), chi.URLParam(r, "token"))
+	if err != nil {
+		if errors.Is(err, util.ErrNotFound) {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		a.Logger.Error("error while recording monitor ping", slog.Any("error", err))
+		return
+	}
+}
+
+func (a *API) GetMonitors(w http.ResponseWriter, r *http.Request) {
+	monitors, err := a.Database.GetMonitors(r.Context())
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		a.Logger.Error("error while getting monitors", slog.Any("error", err))
+		return
+	}
+	// tokens are only shown once, when the monitor is created
+	for i := range monitors {
+		monitors[i].Token = ""
+	}
+	render.JSON(w, r, monitors)
+}
+
+func (a *API) CreateMonitor(w http.ResponseWriter, r *http.Request) {
+	var monitor util.Monitor
+	err := json.NewDecoder(r.Body).Decode(&monitor)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		a.Logger.Error("error while parsing monitor data", slog.Any("error", err))
+		return
+	}
+
+	created, err := a.Database.CreateMonitor(r.Context(), monitor)
+	if err != nil {
+		if errors.Is(err, util.ErrInvalid) {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		a.Logger.Error("error while creating monitor", slog.Any("error", err))
+		return
+	}
+	created.PingURL = strings.TrimSuffix(a.Config.PublicURL, "/") + "/api/v1/ping/" + created.Token
+	render.Status(r, http.StatusCreated)
+	render.JSON(w, r, created)
+}
+
+func (a *API) EditMonitor(w http.ResponseWriter, r *http.Request) {
+	id, err := monitorID(r)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	var patch util.MonitorPatch
+	err = json.NewDecoder(r.Body).Decode(&patch)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		a.Logger.Error("error while parsing monitor data", slog.Any("error", err))
+		return
+	}
+
+	monitor, err := a.Database.EditMonitor(r.Context(), id, patch)
+	if err != nil {
+		if errors.Is(err, util.ErrNotFound) {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		} else if errors.Is(err, util.ErrInvalid) {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		a.Logger.Error("error while editing monitor", slog.Any("error", err))
+		return
+	}
+	monitor.Token = ""
+	render.JSON(w, r, monitor)
+}
+
+func (a *API) DeleteMonitor(w http.ResponseWriter, r *http.Request) {
+	id, err := monitorID(r)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	err = a.Database.DeleteMonitor(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, util.ErrNotFound) {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		a.Logger.Error("error while deleting monitor", slog.Any("error", err))
+		return
+	}
+}
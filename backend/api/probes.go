@@ -0,0 +1,214 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"pluralkit/status/util"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+)
+
+func probeID(r *http.Request) (int64, error) {
+	return strconv.ParseInt(chi.URLParam(r, "probeID"), 10, 64)
+}
+
+func (a *API) GetProbes(w http.ResponseWriter, r *http.Request) {
+	probes, err := a.Database.GetProbes(r.Context())
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		a.Logger.Error("error while getting probes", slog.Any("error", err))
+		return
+	}
+	render.JSON(w, r, probes)
+}
+
+func (a *API) GetProbeResults(w http.ResponseWriter, r *http.Request) {
+	id, err := probeID(r)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	_, err = a.Database.GetProbe(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, util.ErrNotFound) {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		a.Logger.Error("error while getting probe", slog.Any("error", err))
+		return
+	}
+
+	results, err := a.Database.GetProbeResults(r.Context(), id)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		a.Logger.Error("error while getting probe results", slog.Any("error", err))
+		return
+	}
+	render.JSON(w, r, results)
+}
+
+// how bad each state is when working out a component's state
+var stateSeverity = map[util.MonitorState]int{
+	util.MonitorUp:      0,
+	util.MonitorPending: 1,
+	util.MonitorDown:    2,
+}
+
+// monitors and probes grouped by the component they're linked to, ones without a component aren't included
+func (a *API) GetComponentChecks(w http.ResponseWriter, r *http.Request) {
+	monitors, err := a.Database.GetMonitors(r.Context())
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		a.Logger.Error("error while getting monitors", slog.Any("error", err))
+		return
+	}
+	probes, err := a.Database.GetProbes(r.Context())
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		a.Logger.Error("error while getting probes", slog.Any("error", err))
+		return
+	}
+
+	byComponent := make(map[string]*util.ComponentChecks)
+	component := func(name string, state util.MonitorState) *util.ComponentChecks {
+		checks, ok := byComponent[name]
+		if !ok {
+			checks = &util.ComponentChecks{
+				Component: name,
+				State:     state,
+				Monitors:  make([]util.Monitor, 0),
+				Probes:    make([]util.PublicProbe, 0),
+			}
+			byComponent[name] = checks
+		} else if stateSeverity[state] > stateSeverity[checks.State] {
+			checks.State = state
+		}
+		return checks
+	}
+	for _, monitor := range monitors {
+		if monitor.Component == "" {
+			continue
+		}
+		monitor.Token = ""
+		checks := component(monitor.Component, monitor.State)
+		checks.Monitors = append(checks.Monitors, monitor)
+	}
+	for _, probe := range probes {
+		if probe.Component == "" {
+			continue
+		}
+		checks := component(probe.Component, probe.State)
+		checks.Probes = append(checks.Probes, probe.Public())
+	}
+
+	components := make([]util.ComponentChecks, 0, len(byComponent))
+	for _, checks := range byComponent {
+		components = append(components, *checks)
+	}
+	slices.SortFunc(components, func(a, b util.ComponentChecks) int {
+		return strings.Compare(a.Component, b.Component)
+	})
+	render.JSON(w, r, components)
+}
+
+func (a *API) CreateProbe(w http.ResponseWriter, r *http.Request) {
+	var probe util.Probe
+	err := json.NewDecoder(r.Body).Decode(&probe)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		a.Logger.Error("error while parsing probe data", slog.Any("error", err))
+		return
+	}
+
+	created, err := a.Database.CreateProbe(r.Context(), probe)
+	if err != nil {
+		if errors.Is(err, util.ErrInvalid) {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		a.Logger.Error("error while creating probe", slog.Any("error", err))
+		return
+	}
+	render.Status(r, http.StatusCreated)
+	render.JSON(w, r, created)
+}
+
+func (a *API) EditProbe(w http.ResponseWriter, r *http.Request) {
+	id, err := probeID(r)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	var patch util.ProbePatch
+	err = json.NewDecoder(r.Body).Decode(&patch)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		a.Logger.Error("error while parsing probe data", slog.Any("error", err))
+		return
+	}
+
+	probe, err := a.Database.EditProbe(r.Context(), id, patch)
+	if err != nil {
+		if errors.Is(err, util.ErrNotFound) {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		} else if errors.Is(err, util.ErrInvalid) {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		a.Logger.Error("error while editing probe", slog.Any("error", err))
+		return
+	}
+	render.JSON(w, r, probe)
+}
+
+func (a *API) DeleteProbe(w http.ResponseWriter, r *http.Request) {
+	id, err := probeID(r)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	err = a.Database.DeleteProbe(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, util.ErrNotFound) {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		a.Logger.Error("error while deleting probe", slog.Any("error", err))
+		return
+	}
+}
+
+// runs a probe right away instead of waiting for its interval, returning the result
+func (a *API) CheckProbe(w http.ResponseWriter, r *http.Request) {
+	id, err := probeID(r)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	result, err := a.Monitors.CheckProbe(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, util.ErrNotFound) {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		a.Logger.Error("error while checking probe", slog.Any("error", err))
+		return
+	}
+	render.JSON(w, r, result)
+}
@@ -1,88 +1,56 @@
 package api
 
 import (
-	"encoding/json"
-	"fmt"
+	"crypto/ed25519"
+	"encoding/hex"
 	"log/slog"
 	"net/http"
+	"pluralkit/status/alertmanager"
+	"pluralkit/status/backup"
 	"pluralkit/status/db"
+	"pluralkit/status/email"
+	"pluralkit/status/monitors"
+	"pluralkit/status/shards"
 	"pluralkit/status/util"
+	"pluralkit/status/webhook"
 	"strings"
-	"sync"
-	"time"
 
 	"github.com/go-chi/chi/v5"
 )
 
-type UnixTime struct {
-	time.Time
-}
-
-func (u *UnixTime) UnmarshalJSON(b []byte) error {
-	var timestamp int64
-	err := json.Unmarshal(b, &timestamp)
-	if err != nil {
-		return err
-	}
-	u.Time = time.Unix(timestamp, 0)
-	return nil
-}
-func (u UnixTime) MarshalJSON() ([]byte, error) {
-	if u.Time.IsZero() { //nolint:all
-		return []byte("0"), nil
-	}
-	return []byte(fmt.Sprintf("%d", u.Time.Unix())), nil //nolint:all
-}
-
-type Shard struct {
-	ShardID            int      `json:"shard_id"`
-	ClusterID          int      `json:"cluster_id"`
-	Up                 bool     `json:"up"`
-	DisconnectionCount int      `json:"disconnection_count"`
-	Latency            int      `json:"latency"`
-	LastHeartbeat      UnixTime `json:"last_heartbeat"`
-	LastConnection     UnixTime `json:"last_connection"`
-	LastReconnect      UnixTime `json:"last_reconnect"`
-}
-
-type Cluster struct {
-	AvgLatency int     `json:"avg_latency"`
-	ShardsUp   int     `json:"shards_up"`
-	Shards     []Shard `json:"-"`
-	Up         bool    `json:"up"`
-}
-
-type ClustersInfo struct {
-	AvgLatency     int        `json:"avg_latency"`
-	MaxConcurrency int        `json:"max_concurrency"`
-	NumShards      int        `json:"num_shards"`
-	ShardsUp       int        `json:"shards_up"`
-	Clusters       []*Cluster `json:"clusters"`
-}
-
 type API struct {
-	Config     util.Config
-	Logger     *slog.Logger
-	Database   *db.DB
-	httpClient http.Client
-
-	clustersCache  ClustersInfo
-	cacheTimestamp time.Time
-	cacheMutex     sync.RWMutex
+	Config   util.Config
+	Logger   *slog.Logger
+	Database *db.DB
+	Backups  *backup.Scheduler // nil if backups are disabled
+	Mailer   *email.Mailer     // nil if email subscriptions are disabled
+	Shards   *shards.Poller
+	Monitors *monitors.Checker
+	Alerts   *alertmanager.Receiver
+	Discord  *webhook.DiscordClient // shared with the discord notifiers, needed for the interactions endpoint
+
+	discordKey ed25519.PublicKey // nil if the interactions endpoint is disabled
 }
 
 func NewAPI(config util.Config, logger *slog.Logger, database *db.DB) *API {
 	moduleLogger := logger.With(slog.String("module", "API"))
-	return &API{
-		Config:     config,
-		Logger:     moduleLogger,
-		Database:   database,
-		httpClient: http.Client{Timeout: 10 * time.Second},
-		clustersCache: ClustersInfo{
-			Clusters:       make([]*Cluster, 0),
-			MaxConcurrency: config.MaxConcurrency,
-		},
+	api := &API{
+		Config:   config,
+		Logger:   moduleLogger,
+		Database: database,
+		Shards:   shards.NewPoller(config, logger, database),
+		Monitors: monitors.NewChecker(config, logger, database),
+		Alerts:   alertmanager.NewReceiver(config, logger, database),
 	}
+	if config.DiscordPublicKey != "" {
+		key, err := hex.DecodeString(config.DiscordPublicKey)
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			moduleLogger.Error("invalid discord public key, the interactions endpoint is disabled")
+		} else {
+			api.discordKey = key
+		}
+	}
+	return api
 }
 
 // this isn't that secure, and it's not supposed to be.
@@ -114,11 +82,24 @@ func (a *API) SetupRoutes(router *chi.Mux) {
 	router.Route("/api/v1", func(r chi.Router) {
 
 		r.Get("/status", a.GetStatus)
+		r.Get("/ready", a.GetReady)
 
 		r.Route("/clusters", func(r chi.Router) {
 			r.Get("/", a.GetClusters)
+			r.Get("/topology", a.GetTopologyChanges)
+			r.Get("/history", a.GetClusterStats)
+			r.Get("/anomalies", a.GetAnomalies)
 			r.Get("/{clusterID}", a.GetShards)
 		})
+		r.Get("/shards/lookup", a.LookupShard)
+
+		r.Get("/monitors", a.GetMonitors)
+		r.Route("/ping/{token}", func(r chi.Router) {
+			r.Get("/", a.PingMonitor)
+			r.Head("/", a.PingMonitor)
+			r.Post("/", a.PingMonitor)
+		})
+		r.Get("/components", a.GetComponentChecks)
 
 		r.Route("/incidents", func(r chi.Router) {
 			r.Get("/", a.GetIncidents)
@@ -131,6 +112,18 @@ func (a *API) SetupRoutes(router *chi.Mux) {
 			r.Get("/", a.GetUpdate)
 		})
 
+		if a.discordKey != nil {
+			r.Post("/discord/interactions", a.DiscordInteraction)
+		}
+
+		r.Route("/subscriptions", func(r chi.Router) {
+			r.Post("/", a.Subscribe)
+			r.Get("/confirm", a.ConfirmSubscriptionPage)
+			r.Post("/confirm", a.ConfirmSubscription)
+			r.Get("/unsubscribe", a.UnsubscribePage)
+			r.Post("/unsubscribe", a.Unsubscribe)
+		})
+
 		r.Route("/admin", func(r chi.Router) {
 			if a.Config.AuthToken != "" {
 				r.Use(BasicTokenAuth(a.Config.AuthToken))
@@ -150,6 +143,47 @@ func (a *API) SetupRoutes(router *chi.Mux) {
 				r.Patch("/", a.EditUpdate)
 				r.Delete("/", a.DeleteUpdate)
 			})
+
+			r.Get("/export", a.ExportArchive)
+			r.Post("/import", a.ImportArchive)
+
+			r.Route("/backups", func(r chi.Router) {
+				r.Get("/", a.GetBackups)
+				r.Post("/", a.CreateBackup)
+			})
+
+			r.Route("/webhooks", func(r chi.Router) {
+				r.Get("/", a.GetWebhookEndpoints)
+				r.Post("/", a.CreateWebhookEndpoint)
+				r.Route("/{endpointID}", func(r chi.Router) {
+					r.Patch("/", a.EditWebhookEndpoint)
+					r.Delete("/", a.DeleteWebhookEndpoint)
+					r.Get("/deliveries", a.GetWebhookDeliveries)
+				})
+			})
+
+			r.Route("/monitors", func(r chi.Router) {
+				r.Post("/", a.CreateMonitor)
+				r.Route("/{monitorID}", func(r chi.Router) {
+					r.Patch("/", a.EditMonitor)
+					r.Delete("/", a.DeleteMonitor)
+				})
+			})
+
+			r.Route("/probes", func(r chi.Router) {
+				r.Get("/", a.GetProbes)
+				r.Post("/", a.CreateProbe)
+				r.Route("/{probeID}", func(r chi.Router) {
+					r.Get("/results", a.GetProbeResults)
+					r.Patch("/", a.EditProbe)
+					r.Delete("/", a.DeleteProbe)
+					r.Post("/check", a.CheckProbe)
+				})
+			})
+
+			r.Route("/integrations", func(r chi.Router) {
+				r.Post("/alertmanager", a.ReceiveAlertmanager)
+			})
 		})
 
 	})
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"pluralkit/status/shards"
+	"pluralkit/status/util"
+	"slices"
+	"sort"
+	"strconv"
+
+	"github.com/go-chi/render"
+)
+
+type ShardLookup struct {
+	GuildID   string          `json:"guild_id"`
+	Source    string          `json:"source"`
+	ShardID   int             `json:"shard_id"`
+	ClusterID int             `json:"cluster_id"`
+	Shard     *shards.Shard   `json:"shard"` // nil if upstream didn't report this shard
+	History   []shards.Sample `json:"history"`
+	Incidents []util.Incident `json:"incidents"`
+}
+
+// the shard discord sends a guild's events to, see https://discord.com/developers/docs/events/gateway#sharding
+func guildShard(guildID uint64, numShards int) int {
+	return int((guildID >> 22) % uint64(numShards))
+}
+
+// incidents without components affect everything, otherwise they need to list the cluster or shard
+func affectsShard(incident util.Incident, clusterID int, shardID int) bool {
+	if len(incident.Components) == 0 {
+		return true
+	}
+	return slices.Contains(incident.Components, fmt.Sprintf("cluster-%d", clusterID)) ||
+		slices.Contains(incident.Components, fmt.Sprintf("shard-%d", shardID))
+}
+
+func (a *API) LookupShard(w http.ResponseWriter, r *http.Request) {
+	guildID, err := strconv.ParseUint(r.URL.Query().Get("guild_id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid guild_id", http.StatusBadRequest)
+		return
+	}
+
+	snapshot, ok := a.Shards.Snapshot()
+	if !ok {
+		http.Error(w, "shard information isn't available yet", http.StatusServiceUnavailable)
+		return
+	}
+	// every source shards separately, so guilds are looked up in one of them (the first one by default)
+	source := snapshot.Sources[0]
+	if name := r.URL.Query().Get("source"); name != "" {
+		source, ok = snapshot.Source(name)
+		if !ok {
+			http.Error(w, "unknown source", http.StatusNotFound)
+			return
+		}
+	}
+	if source.NumShards == 0 || source.MaxConcurrency <= 0 {
+		http.Error(w, "shard information isn't available yet", http.StatusServiceUnavailable)
+		return
+	}
+	lookup := ShardLookup{
+		GuildID:   strconv.FormatUint(guildID, 10),
+		Source:    source.Name,
+		ShardID:   guildShard(guildID, source.NumShards),
+		Incidents: make([]util.Incident, 0),
+	}
+	// cluster IDs index the combined cluster list (like /clusters/{id} does), where every source's clusters
+	// come after the ones of the sources before it
+	offset := 0
+	for _, other := range snapshot.Sources {
+		if other.Name == source.Name {
+			break
+		}
+		offset += len(other.Clusters)
+	}
+	// clusters can be different sizes, so the shard's cluster comes from upstream if we have it
+	lookup.ClusterID = offset + lookup.ShardID/source.MaxConcurrency
+	for i, cluster := range source.Clusters {
+		for _, shard := range cluster.Shards {
+			if shard.ShardID == lookup.ShardID {
+				lookup.Shard = &shard
+				lookup.ClusterID = offset + i
+			}
+		}
+	}
+	lookup.History = a.Shards.History(source.Name, lookup.ShardID)
+
+	active, err := a.Database.GetActiveIncidents(r.Context())
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		a.Logger.Error("error while getting active incidents", slog.Any("error", err))
+		return
+	}
+	for _, incident := range active.Incidents {
+		if affectsShard(incident, lookup.ClusterID, lookup.ShardID) {
+			lookup.Incidents = append(lookup.Incidents, incident)
+		}
+	}
+	sort.Slice(lookup.Incidents, func(i, j int) bool {
+		return lookup.Incidents[i].Timestamp.After(lookup.Incidents[j].Timestamp)
+	})
+
+	render.JSON(w, r, lookup)
+}
@@ -1,12 +1,9 @@
 package api
 
 import (
-	"encoding/json"
-	"io"
 	"log/slog"
 	"net/http"
 	"pluralkit/status/util"
-	"sort"
 	"strconv"
 	"time"
 
@@ -19,10 +16,6 @@ type wrapper struct {
 	Timestamp time.Time `json:"timestamp"`
 }
 
-type ShardsWrapper struct {
-	Shards []Shard `json:"shards"`
-}
-
 func (a *API) GetStatus(w http.ResponseWriter, r *http.Request) {
 	status, err := a.Database.GetStatus(r.Context())
 	if err != nil {
@@ -38,110 +31,51 @@ func (a *API) GetStatus(w http.ResponseWriter, r *http.Request) {
 	render.JSON(w, r, data)
 }
 
-const cacheTTL = 10 * time.Second
-
-func (a *API) getClustersCached() (*ClustersInfo, error) {
-	a.cacheMutex.RLock()
-	validCache := time.Since(a.cacheTimestamp) < cacheTTL
-	if validCache {
-		a.cacheMutex.RUnlock()
-		return &a.clustersCache, nil
-	}
-	a.cacheMutex.RUnlock()
-
-	a.cacheMutex.Lock()
-	defer a.cacheMutex.Unlock()
-	validCache = time.Since(a.cacheTimestamp) < cacheTTL
-	if validCache {
-		return &a.clustersCache, nil
+// served from the poller's last good snapshot, which says how old it is and whether upstream is failing
+func (a *API) GetClusters(w http.ResponseWriter, r *http.Request) {
+	snapshot, ok := a.Shards.Snapshot()
+	if !ok {
+		http.Error(w, "shard information isn't available yet", http.StatusServiceUnavailable)
+		return
 	}
+	render.JSON(w, r, snapshot)
+}
 
-	req, err := http.NewRequest(http.MethodGet, a.Config.ShardsEndpoint, nil)
-	if err != nil {
-		return nil, err
-	}
-	resp, err := a.httpClient.Do(req)
-	if err != nil {
-		return nil, err
-	} else if resp.StatusCode != 200 {
-		return nil, err
-	}
-	bodyBytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
+func (a *API) GetShards(w http.ResponseWriter, r *http.Request) {
+	snapshot, ok := a.Shards.Snapshot()
+	if !ok {
+		http.Error(w, "shard information isn't available yet", http.StatusServiceUnavailable)
+		return
 	}
-
-	a.clustersCache.ShardsUp = 0
-	a.clustersCache.AvgLatency = 0
-	shards := ShardsWrapper{}
-	err = json.Unmarshal(bodyBytes, &shards)
+	index, err := strconv.Atoi(chi.URLParam(r, "clusterID"))
 	if err != nil {
-		return nil, err
-	}
-
-	sort.Slice(shards.Shards, func(i, j int) bool {
-		return shards.Shards[i].ShardID < shards.Shards[j].ShardID
-	})
-
-	a.clustersCache.NumShards = len(shards.Shards)
-	if len(a.clustersCache.Clusters) == 0 {
-		a.clustersCache.Clusters = make([]*Cluster, a.clustersCache.NumShards/a.clustersCache.MaxConcurrency)
-	}
-	for key, shard := range shards.Shards {
-		cluster := a.clustersCache.Clusters[shard.ClusterID]
-		if key%a.Config.MaxConcurrency == 0 {
-			if cluster == nil {
-				cluster = &Cluster{
-					Shards: make([]Shard, a.Config.MaxConcurrency),
-				}
-				a.clustersCache.Clusters[shard.ClusterID] = cluster
-			} else {
-				cluster.AvgLatency = 0
-				cluster.ShardsUp = 0
-				cluster.Shards = make([]Shard, a.Config.MaxConcurrency)
-			}
-		}
-		cluster.AvgLatency += shard.Latency
-		a.clustersCache.AvgLatency += shard.Latency
-		if time.Since(shard.LastHeartbeat.Time) >= 10*time.Minute {
-			shard.Up = false
-		} else if shard.Up || time.Since(shard.LastReconnect.Time) <= 10*time.Second {
-			a.clustersCache.ShardsUp++
-			cluster.ShardsUp++
-			shard.Up = true
-		}
-		cluster.Shards[shard.ShardID%a.Config.MaxConcurrency] = shard
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
 	}
-
-	for _, cluster := range a.clustersCache.Clusters {
-		cluster.AvgLatency /= a.Config.MaxConcurrency
-		if cluster.ShardsUp > (a.Config.MaxConcurrency / 2) {
-			cluster.Up = true
-		}
+	if index < 0 || index >= len(snapshot.Clusters) {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
 	}
-	a.clustersCache.AvgLatency /= a.clustersCache.NumShards
-	a.cacheTimestamp = time.Now()
-	return &a.clustersCache, nil
+	render.JSON(w, r, snapshot.Clusters[index].Shards)
 }
 
-func (a *API) GetClusters(w http.ResponseWriter, r *http.Request) {
-	clusters, err := a.getClustersCached()
+// changes to the number of shards or clusters, newest first
+func (a *API) GetTopologyChanges(w http.ResponseWriter, r *http.Request) {
+	changes, err := a.Database.GetTopologyChanges(r.Context())
 	if err != nil {
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-		a.Logger.Error("error while getting clusters", slog.Any("error", err))
+		a.Logger.Error("error while getting shard topology changes", slog.Any("error", err))
+		return
 	}
-	render.JSON(w, r, clusters)
+	render.JSON(w, r, changes)
 }
 
-func (a *API) GetShards(w http.ResponseWriter, r *http.Request) {
-	clusters, err := a.getClustersCached()
-	if err != nil {
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-		a.Logger.Error("error while getting clusters", slog.Any("error", err))
-	}
-	index, err := strconv.Atoi(chi.URLParam(r, "clusterID"))
-	if err != nil {
-		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
-	}
-	render.JSON(w, r, clusters.Clusters[index].Shards)
+// latency, heartbeat and disconnection stats from recent polls, oldest first
+func (a *API) GetClusterStats(w http.ResponseWriter, r *http.Request) {
+	render.JSON(w, r, a.Shards.StatsHistory())
+}
+
+// flapping shards and clusters, and whether there's a reconnect storm, as of the last poll
+func (a *API) GetAnomalies(w http.ResponseWriter, r *http.Request) {
+	render.JSON(w, r, a.Shards.Anomalies())
 }
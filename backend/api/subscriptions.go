@@ -0,0 +1,159 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"pluralkit/status/util"
+)
+
+func (a *API) Subscribe(w http.ResponseWriter, r *http.Request) {
+	if a.Mailer == nil {
+		http.Error(w, "email subscriptions are not enabled", http.StatusNotFound)
+		return
+	}
+
+	var req util.SubscribeRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	sub, sendConfirmation, err := a.Database.Subscribe(r.Context(), req)
+	if err != nil {
+		if errors.Is(err, util.ErrInvalid) {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		a.Logger.Error("error while creating subscription", slog.Any("error", err))
+		return
+	}
+
+	if sendConfirmation {
+		err = a.Mailer.QueueConfirmation(sub)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			a.Logger.Error("error while queueing confirmation email", slog.Any("error", err))
+			return
+		}
+	}
+
+	// same response whether or not the address was already subscribed, so this can't be used to look up subscribers
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// the form posts back to the same url, token included
+var confirmPage = []byte(`<!DOCTYPE html>
+<html lang="en">
+<head><meta charset="utf-8"><meta name="viewport" content="width=device-width"><title>Confirm subscription - PluralKit Status</title></head>
+<body>
+<p>Do you want to get PluralKit status updates by email?</p>
+<form method="post"><button type="submit">Confirm subscription</button></form>
+</body>
+</html>
+`)
+
+// the link in confirmation emails only shows a form, since mail scanners and prefetchers follow links on their own
+func (a *API) ConfirmSubscriptionPage(w http.ResponseWriter, r *http.Request) {
+	_, err := a.Database.GetSubscriberByConfirmToken(r.Context(), r.URL.Query().Get("token"))
+	if err != nil {
+		if errors.Is(err, util.ErrInvalid) {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		} else if errors.Is(err, util.ErrNotFound) {
+			http.Error(w, "confirmation link is invalid or was already used", http.StatusNotFound)
+			return
+		}
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		a.Logger.Error("error while getting subscriber", slog.Any("error", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, err = w.Write(confirmPage)
+	if err != nil {
+		a.Logger.Error("error while sending response", slog.Any("error", err))
+	}
+}
+
+func (a *API) ConfirmSubscription(w http.ResponseWriter, r *http.Request) {
+	_, err := a.Database.ConfirmSubscriber(r.Context(), r.URL.Query().Get("token"))
+	if err != nil {
+		if errors.Is(err, util.ErrInvalid) {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		} else if errors.Is(err, util.ErrNotFound) {
+			http.Error(w, "confirmation link is invalid or was already used", http.StatusNotFound)
+			return
+		}
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		a.Logger.Error("error while confirming subscription", slog.Any("error", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, err = w.Write([]byte("subscription confirmed, you'll now get PluralKit status updates by email"))
+	if err != nil {
+		a.Logger.Error("error while sending response", slog.Any("error", err))
+	}
+}
+
+// the form posts back to the same url, token included
+var unsubscribePage = []byte(`<!DOCTYPE html>
+<html lang="en">
+<head><meta charset="utf-8"><meta name="viewport" content="width=device-width"><title>Unsubscribe - PluralKit Status</title></head>
+<body>
+<p>Do you want to stop getting PluralKit status updates by email?</p>
+<form method="post"><button type="submit">Unsubscribe</button></form>
+</body>
+</html>
+`)
+
+// the link in emails only shows a confirmation form, since mail scanners and prefetchers follow links on their own
+func (a *API) UnsubscribePage(w http.ResponseWriter, r *http.Request) {
+	_, err := a.Database.GetSubscriberByUnsubscribeToken(r.Context(), r.URL.Query().Get("token"))
+	if err != nil {
+		if errors.Is(err, util.ErrInvalid) {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		} else if errors.Is(err, util.ErrNotFound) {
+			http.Error(w, "unsubscribe link is invalid or you're already unsubscribed", http.StatusNotFound)
+			return
+		}
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		a.Logger.Error("error while getting subscriber", slog.Any("error", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, err = w.Write(unsubscribePage)
+	if err != nil {
+		a.Logger.Error("error while sending response", slog.Any("error", err))
+	}
+}
+
+// handles both the confirmation form and one-click unsubscribes from mail clients (RFC 8058)
+func (a *API) Unsubscribe(w http.ResponseWriter, r *http.Request) {
+	err := a.Database.Unsubscribe(r.Context(), r.URL.Query().Get("token"))
+	if err != nil {
+		if errors.Is(err, util.ErrInvalid) {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		} else if errors.Is(err, util.ErrNotFound) {
+			http.Error(w, "unsubscribe link is invalid or you're already unsubscribed", http.StatusNotFound)
+			return
+		}
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		a.Logger.Error("error while unsubscribing", slog.Any("error", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, err = w.Write([]byte("you've been unsubscribed from PluralKit status updates"))
+	if err != nil {
+		a.Logger.Error("error while sending response", slog.Any("error", err))
+	}
+}
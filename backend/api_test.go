@@ -231,6 +231,90 @@ func TestEditIncident(t *testing.T) {
 	})
 }
 
+func TestIncidentComponents(t *testing.T) {
+	router, dbInstance, teardown := setupTestAPI(t)
+	defer teardown()
+
+	ctx := context.Background()
+	id, err := dbInstance.CreateIncident(ctx, util.Incident{Name: "bot down", Status: util.StatusInvestigating, Impact: util.ImpactMinor, Components: []string{"API"}})
+	require.NoError(t, err)
+	incident, err := dbInstance.GetIncident(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"API"}, incident.Components)
+
+	// sends a full patch, with components added if they're given
+	patch := func(components string) int {
+		body := `{"name": "bot down", "description": "", "status": "investigating", "impact": "minor"` + components + `}`
+		req, _ := http.NewRequest("PATCH", fmt.Sprintf("/api/v1/admin/incidents/%s", id), bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+testAuthToken)
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	assert.Equal(t, http.StatusOK, patch(`, "components": ["Discord Bot", "Dashboard"]`))
+	incident, err = dbInstance.GetIncident(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Discord Bot", "Dashboard"}, incident.Components)
+	assert.Equal(t, "bot down", incident.Name)
+
+	// leaving components out keeps them, an empty list clears them
+	assert.Equal(t, http.StatusOK, patch(""))
+	incident, err = dbInstance.GetIncident(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Discord Bot", "Dashboard"}, incident.Components)
+
+	assert.Equal(t, http.StatusOK, patch(`, "components": []`))
+	incident, err = dbInstance.GetIncident(ctx, id)
+	require.NoError(t, err)
+	assert.Empty(t, incident.Components)
+
+	assert.Equal(t, http.StatusBadRequest, patch(`, "components": [""]`))
+	assert.Equal(t, http.StatusBadRequest, patch(`, "components": ["`+strings.Repeat("a", 101)+`"]`))
+}
+
+// fields left out of a patch keep their values, only the ones that are sent are validated
+func TestEditIncidentPartial(t *testing.T) {
+	router, dbInstance, teardown := setupTestAPI(t)
+	defer teardown()
+
+	ctx := context.Background()
+	id, err := dbInstance.CreateIncident(ctx, util.Incident{Name: "not edited", Description: "not edited", Status: util.StatusInvestigating, Impact: util.ImpactMinor})
+	require.NoError(t, err)
+
+	patch := func(body string) int {
+		req, _ := http.NewRequest("PATCH", fmt.Sprintf("/api/v1/admin/incidents/%s", id), bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+testAuthToken)
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	assert.Equal(t, http.StatusOK, patch(`{"status": "identified"}`))
+	updatedInc, err := dbInstance.GetIncident(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, util.StatusIdentified, updatedInc.Status)
+	assert.Equal(t, "not edited", updatedInc.Name)
+	assert.Equal(t, "not edited", updatedInc.Description)
+	assert.Equal(t, util.ImpactMinor, updatedInc.Impact)
+
+	assert.Equal(t, http.StatusOK, patch(`{"impact": "major"}`))
+	updatedInc, err = dbInstance.GetIncident(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, util.ImpactMajor, updatedInc.Impact)
+	assert.Equal(t, util.StatusIdentified, updatedInc.Status)
+
+	assert.Equal(t, http.StatusBadRequest, patch(`{"impact": "huge"}`))
+	assert.Equal(t, http.StatusBadRequest, patch(`{"status": "exploded"}`))
+	assert.Equal(t, http.StatusBadRequest, patch(`{"name": "`+strings.Repeat("a", 101)+`"}`))
+	updatedInc, err = dbInstance.GetIncident(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, "not edited", updatedInc.Name)
+	assert.Equal(t, util.ImpactMajor, updatedInc.Impact)
+}
+
 func TestDeleteIncident(t *testing.T) {
 	router, dbInstance, teardown := setupTestAPI(t)
 	defer teardown()
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"pluralkit/status/util"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportImportArchive(t *testing.T) {
+	router, dbInstance, teardown := setupTestAPI(t)
+	defer teardown()
+
+	ctx := context.Background()
+	timestamp := time.Now().Add(-48 * time.Hour).UTC().Truncate(time.Second)
+	incidentID, err := dbInstance.CreateIncident(ctx, util.Incident{Name: "incident", Status: util.StatusInvestigating, Impact: util.ImpactMinor, Timestamp: timestamp})
+	require.NoError(t, err)
+	updateID, err := dbInstance.CreateUpdate(ctx, util.IncidentUpdate{IncidentID: incidentID, Text: "update"})
+	require.NoError(t, err)
+	err = dbInstance.SaveMessageID(ctx, util.WebhookMessage{ID: incidentID, Platform: "discord", Type: "incident", MessageID: 1234})
+	require.NoError(t, err)
+
+	req, _ := http.NewRequest("GET", "/api/v1/admin/export", nil)
+	req.Header.Set("Authorization", "Bearer "+testAuthToken)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var archive util.Archive
+	err = json.NewDecoder(rr.Body).Decode(&archive)
+	require.NoError(t, err)
+	assert.Equal(t, util.ArchiveVersion, archive.Version)
+	require.Len(t, archive.Incidents, 1)
+	require.Len(t, archive.Incidents[0].Updates, 1)
+	require.Len(t, archive.WebhookMessages, 1)
+	body, _ := json.Marshal(archive)
+
+	importArchive := func(query string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/api/v1/admin/import"+query, bytes.NewBuffer(body))
+		req.Header.Set("Authorization", "Bearer "+testAuthToken)
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("conflicts", func(t *testing.T) {
+		rr := importArchive("")
+		assert.Equal(t, http.StatusConflict, rr.Code)
+		var result util.ImportResult
+		err := json.NewDecoder(rr.Body).Decode(&result)
+		require.NoError(t, err)
+		assert.Len(t, result.Conflicts, 3)
+	})
+
+	t.Run("skip conflicts", func(t *testing.T) {
+		rr := importArchive("?on_conflict=skip")
+		assert.Equal(t, http.StatusOK, rr.Code)
+		var result util.ImportResult
+		err := json.NewDecoder(rr.Body).Decode(&result)
+		require.NoError(t, err)
+		assert.Equal(t, 0, result.Incidents)
+		assert.Len(t, result.Conflicts, 3)
+	})
+
+	err = dbInstance.DeleteUpdate(ctx, util.IncidentUpdate{ID: updateID})
+	require.NoError(t, err)
+	err = dbInstance.DeleteIncident(ctx, util.Incident{ID: incidentID})
+	require.NoError(t, err)
+
+	t.Run("dry run", func(t *testing.T) {
+		rr := importArchive("?dry_run=true&on_conflict=skip")
+		assert.Equal(t, http.StatusOK, rr.Code)
+		var result util.ImportResult
+		err := json.NewDecoder(rr.Body).Decode(&result)
+		require.NoError(t, err)
+		assert.True(t, result.DryRun)
+		assert.Equal(t, 1, result.Incidents)
+		assert.Equal(t, 1, result.Updates)
+		assert.Len(t, result.Conflicts, 1) // webhook message is kept around
+
+		_, err = dbInstance.GetIncident(ctx, incidentID)
+		assert.ErrorIs(t, err, util.ErrNotFound)
+	})
+
+	t.Run("import", func(t *testing.T) {
+		rr := importArchive("?on_conflict=skip")
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		incident, err := dbInstance.GetIncident(ctx, incidentID)
+		require.NoError(t, err)
+		assert.Equal(t, "incident", incident.Name)
+		assert.True(t, timestamp.Equal(incident.Timestamp))
+		require.Len(t, incident.Updates, 1)
+		assert.Equal(t, updateID, incident.Updates[0].ID)
+	})
+
+	t.Run("status from merged incidents", func(t *testing.T) {
+		majorID, err := dbInstance.CreateIncident(ctx, util.Incident{Name: "major", Status: util.StatusInvestigating, Impact: util.ImpactMajor})
+		require.NoError(t, err)
+		// the archive's status doesn't know about incidents that were only in this database
+		archive.Status = util.Status{OverallStatus: util.StatusOperational, ActiveIncidents: []string{}}
+		body, _ = json.Marshal(archive)
+		rr := importArchive("?on_conflict=skip")
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		status, err := dbInstance.GetStatus(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, util.StatusMajorOutage, status.OverallStatus)
+		expected := []string{incidentID, majorID}
+		slices.Sort(expected)
+		assert.Equal(t, expected, status.ActiveIncidents)
+	})
+
+	t.Run("invalid version", func(t *testing.T) {
+		archive.Version = util.ArchiveVersion + 1
+		body, _ = json.Marshal(archive)
+		rr := importArchive("")
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	source, _ := setupTestFileDB(t)
+	ctx := context.Background()
+
+	incidentID, err := source.CreateIncident(ctx, util.Incident{Name: "incident", Status: util.StatusInvestigating, Impact: util.ImpactMinor})
+	require.NoError(t, err)
+	_, _, err = source.Subscribe(ctx, util.SubscribeRequest{Email: "pending@example.com", Impacts: []util.Impact{util.ImpactMajor}})
+	require.NoError(t, err)
+	confirmed, _, err := source.Subscribe(ctx, util.SubscribeRequest{Email: "confirmed@example.com", Components: []string{"bot"}})
+	require.NoError(t, err)
+	_, err = source.ConfirmSubscriber(ctx, confirmed.ConfirmToken)
+	require.NoError(t, err)
+	endpoint, err := source.CreateEndpoint(ctx, util.WebhookEndpoint{URL: "https://example.com/hook", Events: []util.EventType{util.EventCreateIncident}})
+	require.NoError(t, err)
+	disabled := false
+	_, err = source.EditEndpoint(ctx, endpoint.ID, util.WebhookEndpointPatch{Enabled: &disabled})
+	require.NoError(t, err)
+	_, err = source.CreateMonitor(ctx, util.Monitor{Name: "cron", Component: "bot", Interval: 60, AutoIncident: true})
+	require.NoError(t, err)
+	_, err = source.CreateProbe(ctx, util.Probe{Name: "api", Type: util.ProbeHTTP, Target: "https://example.com", Interval: 30})
+	require.NoError(t, err)
+	err = source.SaveAlertGroup(ctx, util.AlertGroup{GroupKey: "{}:{alertname=\"down\"}", IncidentID: incidentID, Alerts: map[string]string{"a": "down"}, Impact: util.ImpactMinor})
+	require.NoError(t, err)
+	_, _, err = source.ImportIncident(ctx, "statuspage", "p31zjtct2jer", util.Incident{Name: "imported", Status: util.StatusResolved, Impact: util.ImpactNone, Timestamp: time.Now().Add(-time.Hour)}, false)
+	require.NoError(t, err)
+
+	archive, err := source.ExportArchive(ctx)
+	require.NoError(t, err)
+	assert.Len(t, archive.Subscribers, 2)
+	assert.Len(t, archive.WebhookEndpoints, 1)
+	assert.Len(t, archive.Monitors, 1)
+	assert.Len(t, archive.Probes, 1)
+	assert.Len(t, archive.AlertGroups, 1)
+	assert.Len(t, archive.ImportedIncidents, 1)
+	body, err := json.Marshal(archive)
+	require.NoError(t, err)
+	var decoded util.Archive
+	require.NoError(t, json.Unmarshal(body, &decoded))
+
+	target, _ := setupTestFileDB(t)
+	result, err := target.ImportArchive(ctx, decoded, util.ImportOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 2, result.Subscribers)
+	assert.Equal(t, 1, result.WebhookEndpoints)
+	assert.Equal(t, 1, result.Monitors)
+	assert.Equal(t, 1, result.Probes)
+	assert.Equal(t, 1, result.AlertGroups)
+	assert.Equal(t, 1, result.ImportedIncidents)
+
+	imported, err := target.ExportArchive(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, archive.Subscribers, imported.Subscribers)
+	assert.Equal(t, archive.WebhookEndpoints, imported.WebhookEndpoints)
+	assert.Equal(t, archive.Monitors, imported.Monitors)
+	assert.Equal(t, archive.Probes, imported.Probes)
+	assert.Equal(t, archive.AlertGroups, imported.AlertGroups)
+	assert.Equal(t, archive.ImportedIncidents, imported.ImportedIncidents)
+
+	// links that were already sent out keep working
+	unsubscribe, err := target.GetSubscriberByUnsubscribeToken(ctx, confirmed.UnsubscribeToken)
+	require.NoError(t, err)
+	assert.Equal(t, "confirmed@example.com", unsubscribe.Email)
+	assert.True(t, unsubscribe.Confirmed)
+	importedEndpoint, err := target.GetEndpoint(ctx, endpoint.ID)
+	require.NoError(t, err)
+	assert.False(t, importedEndpoint.Enabled)
+
+	t.Run("conflicts", func(t *testing.T) {
+		result, err := target.ImportArchive(ctx, decoded, util.ImportOptions{})
+		assert.ErrorIs(t, err, util.ErrConflict)
+		assert.Len(t, result.Conflicts, 2+2+5) // both incidents, both subscribers and one of everything else
+		assert.Equal(t, 0, result.Subscribers)
+	})
+
+	t.Run("new records", func(t *testing.T) {
+		monitor, err := target.CreateMonitor(ctx, util.Monitor{Name: "another", Interval: 60})
+		require.NoError(t, err)
+		assert.Greater(t, monitor.ID, archive.Monitors[0].ID)
+	})
+}
@@ -0,0 +1,235 @@
+// Package backup periodically snapshots the database to a directory and prunes old snapshots
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"pluralkit/status/db"
+	"pluralkit/status/util"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const filePrefix = "status-"
+const fileSuffix = ".db"
+const timeFormat = "20060102-150405.000"
+
+type Backup struct {
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+type Status struct {
+	Enabled     bool      `json:"enabled"`
+	Healthy     bool      `json:"healthy"`
+	Interval    string    `json:"interval,omitempty"`
+	Retention   int       `json:"retention,omitempty"`
+	LastAttempt time.Time `json:"last_attempt"`
+	LastSuccess time.Time `json:"last_success"`
+	LastError   string    `json:"last_error,omitempty"`
+	LastBackup  *Backup   `json:"last_backup,omitempty"`
+	Backups     []Backup  `json:"backups,omitempty"`
+}
+
+// render helper function for Status
+func (s *Status) Render(w http.ResponseWriter, r *http.Request) error { return nil }
+
+type Scheduler struct {
+	logger    *slog.Logger
+	database  *db.DB
+	dir       string
+	interval  time.Duration
+	retention int
+
+	running sync.Mutex // held while a backup is running, so only one runs at a time
+	mutex   sync.RWMutex
+	status  Status
+}
+
+func NewScheduler(config util.Config, logger *slog.Logger, database *db.DB) *Scheduler {
+	moduleLogger := logger.With(slog.String("module", "backup"))
+	interval := config.BackupInterval
+	if interval <= 0 {
+		interval = 6 * time.Hour
+	}
+	retention := config.BackupRetention
+	if retention < 1 {
+		retention = 1
+	}
+	return &Scheduler{
+		logger:    moduleLogger,
+		database:  database,
+		dir:       config.BackupDir,
+		interval:  interval,
+		retention: retention,
+		status: Status{
+			Enabled:   true,
+			Interval:  interval.String(),
+			Retention: retention,
+		},
+	}
+}
+
+// takes a backup immediately and then every interval until ctx is cancelled
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		_, err := s.BackupNow(ctx)
+		if err != nil {
+			s.logger.Error("error while backing up database", slog.Any("error", err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// snapshots the database, verifies the snapshot and prunes old ones
+func (s *Scheduler) BackupNow(ctx context.Context) (Backup, error) {
+	s.running.Lock()
+	defer s.running.Unlock()
+
+	now := time.Now().UTC()
+	backup, err := s.backup(ctx, now)
+
+	s.mutex.Lock()
+	s.status.LastAttempt = now
+	if err != nil {
+		s.status.LastError = err.Error()
+		s.mutex.Unlock()
+		return backup, err
+	}
+	s.status.LastError = ""
+	s.status.LastSuccess = now
+	s.status.LastBackup = &backup
+	s.mutex.Unlock()
+
+	s.logger.Info("backed up database", slog.String("name", backup.Name), slog.Int64("size", backup.Size))
+
+	err = s.prune()
+	if err != nil {
+		s.logger.Warn("error while pruning old backups", slog.Any("error", err))
+	}
+	return backup, nil
+}
+
+func (s *Scheduler) backup(ctx context.Context, now time.Time) (Backup, error) {
+	err := os.MkdirAll(s.dir, 0o750)
+	if err != nil {
+		return Backup{}, err
+	}
+
+	name := fmt.Sprintf("%s%s%s", filePrefix, now.Format(timeFormat), fileSuffix)
+	path := filepath.Join(s.dir, name)
+	err = s.database.Backup(ctx, path)
+	if err != nil {
+		return Backup{}, err
+	}
+
+	err = db.VerifyBackup(ctx, path)
+	if err != nil {
+		// don't keep broken backups around, they'd push working ones out of retention
+		_ = os.Remove(path)
+		return Backup{}, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return Backup{}, err
+	}
+	return Backup{
+		Name:      name,
+		Size:      info.Size(),
+		Timestamp: now,
+	}, nil
+}
+
+// deletes all but the newest s.retention backups
+func (s *Scheduler) prune() error {
+	backups, err := s.list()
+	if err != nil {
+		return err
+	}
+	if len(backups) <= s.retention {
+		return nil
+	}
+
+	var errs []error
+	for _, backup := range backups[s.retention:] {
+		err := os.Remove(filepath.Join(s.dir, backup.Name))
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		s.logger.Debug("removed old backup", slog.String("name", backup.Name))
+	}
+	return errors.Join(errs...)
+}
+
+// lists backups in the backup directory, newest first
+func (s *Scheduler) list() ([]Backup, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return []Backup{}, nil
+		}
+		return nil, err
+	}
+
+	backups := make([]Backup, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, filePrefix) || !strings.HasSuffix(name, fileSuffix) {
+			continue
+		}
+		timestamp, err := time.Parse(timeFormat, strings.TrimSuffix(strings.TrimPrefix(name, filePrefix), fileSuffix))
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		backups = append(backups, Backup{
+			Name:      name,
+			Size:      info.Size(),
+			Timestamp: timestamp,
+		})
+	}
+
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].Timestamp.After(backups[j].Timestamp)
+	})
+	return backups, nil
+}
+
+// returns the current backup status, includeList also lists all stored backups
+func (s *Scheduler) Status(includeList bool) (Status, error) {
+	s.mutex.RLock()
+	status := s.status
+	s.mutex.RUnlock()
+
+	// unhealthy if the last backup failed, or if backups have stopped running for whatever reason
+	status.Healthy = status.LastAttempt.IsZero() || (status.LastError == "" && time.Since(status.LastSuccess) <= 2*s.interval)
+
+	if includeList {
+		backups, err := s.list()
+		if err != nil {
+			return status, err
+		}
+		status.Backups = backups
+	}
+	return status, nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"pluralkit/status/backup"
+	"pluralkit/status/db"
+	"pluralkit/status/util"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackups(t *testing.T) {
+	_, dbInstance, teardown := setupTestAPI(t)
+	defer teardown()
+
+	ctx := context.Background()
+	_, err := dbInstance.CreateIncident(ctx, util.Incident{Name: "incident", Status: util.StatusInvestigating, Impact: util.ImpactMinor})
+	require.NoError(t, err)
+
+	cfg := util.Config{
+		BackupDir:       t.TempDir(),
+		BackupInterval:  time.Hour,
+		BackupRetention: 2,
+	}
+	scheduler := backup.NewScheduler(cfg, slog.Default(), dbInstance)
+	var last backup.Backup
+	for range 3 {
+		last, err = scheduler.BackupNow(ctx)
+		require.NoError(t, err)
+	}
+
+	status, err := scheduler.Status(true)
+	require.NoError(t, err)
+	assert.True(t, status.Healthy)
+	require.Len(t, status.Backups, 2)
+	assert.Equal(t, last.Name, status.Backups[0].Name)
+
+	entries, err := os.ReadDir(cfg.BackupDir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 2)
+
+	t.Run("backup contents", func(t *testing.T) {
+		path := filepath.Join(cfg.BackupDir, last.Name)
+		require.NoError(t, db.VerifyBackup(ctx, path))
+
+		restored := db.NewDB(util.Config{DBLoc: "file:" + path, LogLevel: cfg.LogLevel}, slog.Default(), make(chan util.Event, 1))
+		require.NotNil(t, restored)
+		defer restored.CloseDB() //nolint:all
+		incidents, err := restored.GetActiveIncidents(ctx)
+		require.NoError(t, err)
+		assert.Len(t, incidents.Incidents, 1)
+	})
+
+	t.Run("corrupt backup", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "corrupt.db")
+		require.NoError(t, os.WriteFile(path, []byte("definitely not a database"), 0o600))
+		assert.Error(t, db.VerifyBackup(ctx, path))
+	})
+
+	t.Run("default interval", func(t *testing.T) {
+		scheduler := backup.NewScheduler(util.Config{BackupDir: t.TempDir()}, slog.Default(), dbInstance)
+		status, err := scheduler.Status(false)
+		require.NoError(t, err)
+		assert.Equal(t, "6h0m0s", status.Interval)
+
+		// without an interval Run used to panic when creating its ticker
+		runCtx, cancel := context.WithCancel(ctx)
+		done := make(chan struct{})
+		go func() {
+			scheduler.Run(runCtx)
+			close(done)
+		}()
+		require.Eventually(t, func() bool {
+			status, err := scheduler.Status(true)
+			return err == nil && len(status.Backups) == 1
+		}, 5*time.Second, 10*time.Millisecond)
+		cancel()
+		<-done
+	})
+}
+
+func TestReady(t *testing.T) {
+	router, _, teardown := setupTestAPI(t)
+	defer teardown()
+
+	req, _ := http.NewRequest("GET", "/api/v1/ready", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var data map[string]any
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&data))
+	assert.Equal(t, true, data["ready"])
+	assert.Equal(t, "ok", data["database"])
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"pluralkit/status/db"
+	"pluralkit/status/statuspage"
+	"pluralkit/status/util"
+	"pluralkit/status/webhook"
+)
+
+// runs a command-line subcommand (e.g. `status export`) instead of the server, returning the exit code
+func runCommand(cfg util.Config, logger *slog.Logger, args []string) int {
+	var err error
+	switch args[0] {
+	case "export":
+		err = exportCommand(cfg, logger, args[1:])
+	case "import":
+		err = importCommand(cfg, logger, args[1:])
+	case "import-statuspage":
+		err = importStatuspageCommand(cfg, logger, args[1:])
+	case "register-commands":
+		err = webhook.RegisterCommands(context.Background(), cfg)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q, valid commands are: export, import, import-statuspage, register-commands\n", args[0])
+		return 2
+	}
+
+	if err != nil {
+		logger.Error("error while running command", slog.String("command", args[0]), slog.Any("error", err))
+		return 1
+	}
+	return 0
+}
+
+// opens the database without anything listening for events, commands should never emit any
+func openCommandDB(cfg util.Config, logger *slog.Logger) (*db.DB, error) {
+	database := db.NewDB(cfg, logger, make(chan util.Event, 1))
+	if database == nil {
+		return nil, errors.New("error while setting up database")
+	}
+	return database, nil
+}
+
+func exportCommand(cfg util.Config, logger *slog.Logger, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	output := flags.String("o", "-", "file to write the archive to, or - for stdout")
+	_ = flags.Parse(args)
+
+	database, err := openCommandDB(cfg, logger)
+	if err != nil {
+		return err
+	}
+	defer database.CloseDB() //nolint:all
+
+	archive, err := database.ExportArchive(context.Background())
+	if err != nil {
+		return err
+	}
+
+	var out io.Writer = os.Stdout
+	if *output != "-" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close() //nolint:all
+		out = file
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(archive)
+}
+
+func importCommand(cfg util.Config, logger *slog.Logger, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "check the archive for conflicts without writing anything")
+	skipConflicts := flags.Bool("skip-conflicts", false, "skip records that already exist instead of aborting")
+	_ = flags.Parse(args)
+	if flags.NArg() != 1 {
+		return errors.New("usage: import [-dry-run] [-skip-conflicts] <archive.json>")
+	}
+
+	data, err := os.ReadFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	var archive util.Archive
+	err = json.Unmarshal(data, &archive)
+	if err != nil {
+		return err
+	}
+
+	database, err := openCommandDB(cfg, logger)
+	if err != nil {
+		return err
+	}
+	defer database.CloseDB() //nolint:all
+
+	result, err := database.ImportArchive(context.Background(), archive, util.ImportOptions{
+		DryRun:        *dryRun,
+		SkipConflicts: *skipConflicts,
+	})
+	for _, conflict := range result.Conflicts {
+		logger.Warn("conflicting record", slog.String("type", conflict.Type), slog.String("id", conflict.ID))
+	}
+	if err != nil {
+		return err
+	}
+
+	logger.Info("imported archive",
+		slog.Bool("dry_run", result.DryRun),
+		slog.Int("incidents", result.Incidents),
+		slog.Int("updates", result.Updates),
+		slog.Int("webhook_messages", result.WebhookMessages),
+		slog.Int("subscribers", result.Subscribers),
+		slog.Int("webhook_endpoints", result.WebhookEndpoints),
+		slog.Int("monitors", result.Monitors),
+		slog.Int("probes", result.Probes),
+		slog.Int("alert_groups", result.AlertGroups),
+		slog.Int("imported_incidents", result.ImportedIncidents),
+		slog.Int("skipped", len(result.Conflicts)),
+	)
+	return nil
+}
+
+func importStatuspageCommand(cfg util.Config, logger *slog.Logger, args []string) error {
+	flags := flag.NewFlagSet("import-statuspage", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "convert the export without writing anything")
+	_ = flags.Parse(args)
+	if flags.NArg() != 1 {
+		return errors.New("usage: import-statuspage [-dry-run] <export.json>")
+	}
+
+	data, err := os.ReadFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	export, err := statuspage.Parse(data)
+	if err != nil {
+		return err
+	}
+
+	database, err := openCommandDB(cfg, logger)
+	if err != nil {
+		return err
+	}
+	defer database.CloseDB() //nolint:all
+
+	ctx := context.Background()
+	imported, skipped := 0, 0
+	for _, incident := range export.Incidents {
+		converted, err := statuspage.Convert(incident)
+		if err != nil {
+			logger.Warn("skipping incident", slog.String("source_id", incident.ID), slog.Any("error", err))
+			skipped++
+			continue
+		}
+
+		id, created, err := database.ImportIncident(ctx, statuspage.Source, incident.ID, converted, *dryRun)
+		if err != nil {
+			return fmt.Errorf("error while importing incident %s: %w", incident.ID, err)
+		}
+		if !created {
+			logger.Debug("incident already imported", slog.String("source_id", incident.ID), slog.String("id", id))
+			skipped++
+			continue
+		}
+		logger.Debug("imported incident", slog.String("source_id", incident.ID), slog.String("id", id))
+		imported++
+	}
+
+	logger.Info("imported statuspage incidents",
+		slog.Bool("dry_run", *dryRun),
+		slog.Int("imported", imported),
+		slog.Int("skipped", skipped),
+	)
+	return nil
+}
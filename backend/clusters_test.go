@@ -0,0 +1,671 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"pluralkit/status/api"
+	"pluralkit/status/shards"
+	"pluralkit/status/util"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/caarlos0/env/v11"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serves shard state the way the PluralKit API does, shards can be changed between requests
+type fakeShardsUpstream struct {
+	server *httptest.Server
+	mutex  sync.Mutex
+	shards []map[string]any
+	fail   bool
+}
+
+func newFakeShardsUpstream(t *testing.T, numShards int, clusterSize int) *fakeShardsUpstream {
+	upstream := &fakeShardsUpstream{}
+	upstream.resize(numShards, clusterSize)
+	upstream.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstream.mutex.Lock()
+		defer upstream.mutex.Unlock()
+		if upstream.fail {
+			http.Error(w, "oh no", http.StatusBadGateway)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"shards": upstream.shards})
+	}))
+	t.Cleanup(upstream.server.Close)
+	return upstream
+}
+
+// replaces all shards with healthy ones, as if PluralKit resharded
+func (u *fakeShardsUpstream) resize(numShards int, clusterSize int) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	now := time.Now().Unix()
+	u.shards = nil
+	for i := range numShards {
+		u.shards = append(u.shards, map[string]any{
+			"shard_id":            i,
+			"cluster_id":          i / clusterSize,
+			"up":                  true,
+			"disconnection_count": 0,
+			"latency":             100 + i,
+			"last_heartbeat":      now,
+			"last_connection":     now - 3600,
+			"last_reconnect":      now - 3600,
+		})
+	}
+}
+
+func (u *fakeShardsUpstream) set(shardID int, key string, value any) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.shards[shardID][key] = value
+}
+
+func (u *fakeShardsUpstream) setFailing(fail bool) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.fail = fail
+}
+
+// the poller isn't started, tests call Poll themselves. the first poll has to succeed
+func setupClustersAPI(t *testing.T, cfg util.Config) (*chi.Mux, *api.API) {
+	database, _ := setupTestFileDB(t)
+	if cfg.MaxConcurrency == 0 {
+		cfg.MaxConcurrency = 16
+	}
+	apiInstance := api.NewAPI(cfg, slog.Default(), database)
+	router := chi.NewRouter()
+	router.Use(render.SetContentType(render.ContentTypeJSON))
+	apiInstance.SetupRoutes(router)
+	require.NoError(t, apiInstance.Shards.Poll(context.Background()))
+	return router, apiInstance
+}
+
+func getJSON(t *testing.T, router *chi.Mux, path string, out any) int {
+	req, _ := http.NewRequest(http.MethodGet, path, nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code == http.StatusOK && out != nil {
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), out))
+	}
+	return rr.Code
+}
+
+func TestShardsPoller(t *testing.T) {
+	upstream := newFakeShardsUpstream(t, 32, 16)
+	upstream.set(3, "up", false)
+	router, apiInstance := setupClustersAPI(t, util.Config{ShardsEndpoint: upstream.server.URL, ShardsPollInterval: time.Minute})
+	ctx := context.Background()
+
+	var snapshot shards.Snapshot
+	require.Equal(t, http.StatusOK, getJSON(t, router, "/api/v1/clusters", &snapshot))
+	assert.Equal(t, 32, snapshot.NumShards)
+	assert.Equal(t, 31, snapshot.ShardsUp)
+	assert.Len(t, snapshot.Clusters, 2)
+	assert.False(t, snapshot.Stale)
+	assert.Zero(t, snapshot.ConsecutiveFailures)
+	assert.Less(t, snapshot.Age, 5.0)
+
+	var clusterShards []shards.Shard
+	require.Equal(t, http.StatusOK, getJSON(t, router, "/api/v1/clusters/0", &clusterShards))
+	require.Len(t, clusterShards, 16)
+	assert.False(t, clusterShards[3].Up)
+
+	// failures keep serving the last good snapshot, marked as stale
+	upstream.setFailing(true)
+	upstream.set(3, "up", true)
+	assert.Error(t, apiInstance.Shards.Poll(ctx))
+	assert.Error(t, apiInstance.Shards.Poll(ctx))
+	require.Equal(t, http.StatusOK, getJSON(t, router, "/api/v1/clusters", &snapshot))
+	assert.Equal(t, 31, snapshot.ShardsUp)
+	assert.True(t, snapshot.Stale)
+	assert.Equal(t, 2, snapshot.ConsecutiveFailures)
+
+	status := apiInstance.Shards.Status()
+	assert.False(t, status.Healthy)
+	assert.Equal(t, 2, status.ConsecutiveFailures)
+	assert.Contains(t, status.LastError, "502")
+	assert.Len(t, apiInstance.Shards.History("default", 3), 1)
+
+	upstream.setFailing(false)
+	require.NoError(t, apiInstance.Shards.Poll(ctx))
+	require.Equal(t, http.StatusOK, getJSON(t, router, "/api/v1/clusters", &snapshot))
+	assert.Equal(t, 32, snapshot.ShardsUp)
+	assert.False(t, snapshot.Stale)
+	assert.Zero(t, snapshot.ConsecutiveFailures)
+	assert.True(t, apiInstance.Shards.Status().Healthy)
+
+	history := apiInstance.Shards.History("default", 3)
+	require.Len(t, history, 2)
+	assert.False(t, history[0].Up)
+	assert.True(t, history[1].Up)
+
+	t.Run("nothing to serve before the first poll", func(t *testing.T) {
+		upstream.setFailing(true)
+		database, _ := setupTestFileDB(t)
+		apiInstance := api.NewAPI(util.Config{ShardsEndpoint: upstream.server.URL, MaxConcurrency: 16}, slog.Default(), database)
+		router := chi.NewRouter()
+		apiInstance.SetupRoutes(router)
+		assert.Error(t, apiInstance.Shards.Poll(ctx))
+		assert.Equal(t, http.StatusServiceUnavailable, getJSON(t, router, "/api/v1/clusters", nil))
+		assert.Equal(t, http.StatusServiceUnavailable, getJSON(t, router, "/api/v1/shards/lookup?guild_id=1", nil))
+	})
+}
+
+func TestShardLookup(t *testing.T) {
+	upstream := newFakeShardsUpstream(t, 32, 16)
+	upstream.set(20, "up", false)
+	upstream.set(20, "latency", 900)
+	router, apiInstance := setupClustersAPI(t, util.Config{ShardsEndpoint: upstream.server.URL})
+	ctx := context.Background()
+
+	createIncident := func(name string, components ...string) string {
+		id, err := apiInstance.Database.CreateIncident(ctx, util.Incident{Name: name, Status: util.StatusInvestigating, Impact: util.ImpactMinor, Components: components})
+		require.NoError(t, err)
+		return id
+	}
+	everything := createIncident("everything")
+	cluster := createIncident("cluster 1", "cluster-1")
+	shard := createIncident("shard 20", "shard-20")
+	createIncident("cluster 0", "cluster-0")
+	resolvedID := createIncident("resolved", "cluster-1")
+	resolved := util.StatusResolved
+	_, err := apiInstance.Database.CreateUpdate(ctx, util.IncidentUpdate{IncidentID: resolvedID, Text: "fixed", Status: &resolved})
+	require.NoError(t, err)
+
+	lookup := func(guildID string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodGet, "/api/v1/shards/lookup?guild_id="+guildID, nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// (guild_id >> 22) % 32 == 20
+	guildID := fmt.Sprint(uint64(20+32*1234)<<22 | 12345)
+	rr := lookup(guildID)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var resp api.ShardLookup
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	assert.Equal(t, guildID, resp.GuildID)
+	assert.Equal(t, 20, resp.ShardID)
+	assert.Equal(t, 1, resp.ClusterID)
+	require.NotNil(t, resp.Shard)
+	assert.Equal(t, 20, resp.Shard.ShardID)
+	assert.False(t, resp.Shard.Up)
+	require.Len(t, resp.History, 1)
+	assert.False(t, resp.History[0].Up)
+	assert.Equal(t, 900, resp.History[0].Latency)
+
+	ids := make([]string, 0)
+	for _, incident := range resp.Incidents {
+		ids = append(ids, incident.ID)
+	}
+	assert.ElementsMatch(t, []string{everything, cluster, shard}, ids)
+
+	assert.Equal(t, http.StatusBadRequest, lookup("").Code)
+	assert.Equal(t, http.StatusBadRequest, lookup("not-a-guild").Code)
+}
+
+func TestShardTopology(t *testing.T) {
+	upstream := newFakeShardsUpstream(t, 32, 16)
+	router, apiInstance := setupClustersAPI(t, util.Config{ShardsEndpoint: upstream.server.URL})
+	ctx := context.Background()
+
+	var changes []util.TopologyChange
+	require.Equal(t, http.StatusOK, getJSON(t, router, "/api/v1/clusters/topology", &changes))
+	require.Len(t, changes, 1)
+	assert.Equal(t, 32, changes[0].NumShards)
+	assert.Equal(t, []int{16, 16}, changes[0].ClusterSizes)
+	assert.Zero(t, changes[0].PreviousShards)
+
+	require.NoError(t, apiInstance.Shards.Poll(ctx))
+	require.Equal(t, http.StatusOK, getJSON(t, router, "/api/v1/clusters/topology", &changes))
+	assert.Len(t, changes, 1)
+
+	// the last cluster is smaller than the others now
+	upstream.resize(40, 16)
+	require.NoError(t, apiInstance.Shards.Poll(ctx))
+	require.Equal(t, http.StatusOK, getJSON(t, router, "/api/v1/clusters/topology", &changes))
+	require.Len(t, changes, 2)
+	assert.Equal(t, 40, changes[0].NumShards)
+	assert.Equal(t, []int{16, 16, 8}, changes[0].ClusterSizes)
+	assert.Equal(t, 32, changes[0].PreviousShards)
+	assert.Equal(t, []int{16, 16}, changes[0].PreviousClusterSizes)
+
+	var snapshot shards.Snapshot
+	require.Equal(t, http.StatusOK, getJSON(t, router, "/api/v1/clusters", &snapshot))
+	assert.Equal(t, 40, snapshot.NumShards)
+	require.Len(t, snapshot.Clusters, 3)
+	assert.Equal(t, 2, snapshot.Clusters[2].ID)
+	assert.Equal(t, 8, snapshot.Clusters[2].NumShards)
+	assert.Equal(t, 8, snapshot.Clusters[2].ShardsUp)
+	assert.True(t, snapshot.Clusters[2].Up)
+
+	var clusterShards []shards.Shard
+	require.Equal(t, http.StatusOK, getJSON(t, router, "/api/v1/clusters/2", &clusterShards))
+	require.Len(t, clusterShards, 8)
+	assert.Equal(t, 32, clusterShards[0].ShardID)
+	assert.Equal(t, http.StatusNotFound, getJSON(t, router, "/api/v1/clusters/3", nil))
+	assert.Equal(t, http.StatusNotFound, getJSON(t, router, "/api/v1/clusters/-1", nil))
+	assert.Equal(t, http.StatusBadRequest, getJSON(t, router, "/api/v1/clusters/abc", nil))
+
+	var lookup api.ShardLookup
+	guildID := uint64(35) << 22
+	require.Equal(t, http.StatusOK, getJSON(t, router, fmt.Sprintf("/api/v1/shards/lookup?guild_id=%d", guildID), &lookup))
+	assert.Equal(t, 35, lookup.ShardID)
+	assert.Equal(t, 2, lookup.ClusterID)
+
+	// a restart with the same topology doesn't count as a change, a different one does
+	poller := shards.NewPoller(util.Config{ShardsEndpoint: upstream.server.URL, MaxConcurrency: 16}, slog.Default(), apiInstance.Database)
+	require.NoError(t, poller.Poll(ctx))
+	changes, err := apiInstance.Database.GetTopologyChanges(ctx)
+	require.NoError(t, err)
+	assert.Len(t, changes, 2)
+
+	upstream.resize(40, 10)
+	poller = shards.NewPoller(util.Config{ShardsEndpoint: upstream.server.URL, MaxConcurrency: 16}, slog.Default(), apiInstance.Database)
+	require.NoError(t, poller.Poll(ctx))
+	changes, err = apiInstance.Database.GetTopologyChanges(ctx)
+	require.NoError(t, err)
+	require.Len(t, changes, 3)
+	assert.Equal(t, []int{10, 10, 10, 10}, changes[0].ClusterSizes)
+	assert.Equal(t, []int{16, 16, 8}, changes[0].PreviousClusterSizes)
+
+	// broken upstream data fails the poll instead of taking down the process
+	upstream.set(0, "cluster_id", 5000)
+	assert.Error(t, poller.Poll(ctx))
+}
+
+func TestShardHealth(t *testing.T) {
+	upstream := newFakeShardsUpstream(t, 12, 4)
+	now := time.Now().Unix()
+	for i := range 12 {
+		upstream.set(i, "latency", 50)
+	}
+	upstream.set(3, "latency", 150)
+	// cluster 1: one shard lost its heartbeat, one just reconnected
+	upstream.set(4, "last_heartbeat", now-120)
+	upstream.set(5, "up", false)
+	upstream.set(5, "last_reconnect", now)
+	// cluster 2: half the shards are down
+	upstream.set(8, "up", false)
+	upstream.set(9, "up", false)
+	upstream.set(10, "latency", 500)
+
+	router, _ := setupClustersAPI(t, util.Config{
+		ShardsEndpoint: upstream.server.URL,
+		MaxConcurrency: 4,
+		Health: util.HealthThresholds{
+			HeartbeatTimeout: time.Minute,
+			DegradedLatency:  100,
+			SevereLatency:    300,
+		},
+	})
+
+	var snapshot shards.Snapshot
+	require.Equal(t, http.StatusOK, getJSON(t, router, "/api/v1/clusters", &snapshot))
+	require.Len(t, snapshot.Clusters, 3)
+	assert.Equal(t, shards.HealthHealthy, snapshot.Clusters[0].Status)
+	assert.Equal(t, shards.HealthDegraded, snapshot.Clusters[1].Status)
+	assert.True(t, snapshot.Clusters[1].Up)
+	assert.Equal(t, shards.HealthDown, snapshot.Clusters[2].Status)
+	assert.False(t, snapshot.Clusters[2].Up)
+	assert.Equal(t, 9, snapshot.ShardsUp)
+
+	statuses := func(clusterID int) []shards.Health {
+		var clusterShards []shards.Shard
+		require.Equal(t, http.StatusOK, getJSON(t, router, fmt.Sprintf("/api/v1/clusters/%d", clusterID), &clusterShards))
+		result := make([]shards.Health, 0)
+		for _, shard := range clusterShards {
+			result = append(result, shard.Status)
+		}
+		return result
+	}
+	assert.Equal(t, []shards.Health{shards.HealthHealthy, shards.HealthHealthy, shards.HealthHealthy, shards.HealthDegraded}, statuses(0))
+	assert.Equal(t, []shards.Health{shards.HealthDown, shards.HealthHealthy, shards.HealthHealthy, shards.HealthHealthy}, statuses(1))
+	assert.Equal(t, []shards.Health{shards.HealthDown, shards.HealthDown, shards.HealthSevere, shards.HealthHealthy}, statuses(2))
+
+	t.Run("defaults", func(t *testing.T) {
+		var cfg util.Config
+		require.NoError(t, env.ParseWithOptions(&cfg, env.Options{Environment: map[string]string{
+			"pluralkit__status__health_severe_latency": "1000",
+		}}))
+		assert.Equal(t, 10*time.Minute, cfg.Health.HeartbeatTimeout)
+		assert.Equal(t, 10*time.Second, cfg.Health.ReconnectGrace)
+		assert.Equal(t, 200, cfg.Health.DegradedLatency)
+		assert.Equal(t, 1000, cfg.Health.SevereLatency)
+		assert.Equal(t, 0.5, cfg.Health.ClusterUpRatio)
+	})
+}
+
+func TestShardSources(t *testing.T) {
+	us := newFakeShardsUpstream(t, 32, 16)
+	eu := newFakeShardsUpstream(t, 8, 4)
+	eu.set(1, "up", false)
+	eu.setFailing(true)
+	router, apiInstance := setupClustersAPI(t, util.Config{ShardsSources: util.ShardSources{
+		{Name: "us", Endpoint: us.server.URL},
+		{Name: "eu", Endpoint: eu.server.URL},
+	}})
+	ctx := context.Background()
+
+	// a source that never succeeded has nothing to show, and doesn't count towards the topology yet
+	var snapshot shards.Snapshot
+	require.Equal(t, http.StatusOK, getJSON(t, router, "/api/v1/clusters", &snapshot))
+	assert.False(t, snapshot.Stale)
+	assert.Equal(t, 32, snapshot.NumShards)
+	assert.Len(t, snapshot.Clusters, 2)
+	require.Len(t, snapshot.Sources, 2)
+	assert.True(t, snapshot.Sources[1].Unknown)
+	assert.Contains(t, snapshot.Sources[1].Error, "502")
+	changes, err := apiInstance.Database.GetTopologyChanges(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, changes)
+
+	eu.setFailing(false)
+	require.NoError(t, apiInstance.Shards.Poll(ctx))
+	require.Equal(t, http.StatusOK, getJSON(t, router, "/api/v1/clusters", &snapshot))
+	assert.Equal(t, 40, snapshot.NumShards)
+	assert.Equal(t, 39, snapshot.ShardsUp)
+	assert.Equal(t, 112, snapshot.AvgLatency)
+	require.Len(t, snapshot.Clusters, 4)
+	for i, cluster := range snapshot.Clusters {
+		assert.Equal(t, i, cluster.ID)
+	}
+	assert.Equal(t, "us", snapshot.Clusters[1].Source)
+	assert.Equal(t, "eu", snapshot.Clusters[2].Source)
+	assert.Equal(t, 3, snapshot.Clusters[2].ShardsUp)
+
+	euSnapshot, ok := snapshot.Source("eu")
+	require.True(t, ok)
+	assert.False(t, euSnapshot.Unknown)
+	assert.Equal(t, 8, euSnapshot.NumShards)
+	assert.Equal(t, 0, euSnapshot.Clusters[0].ID)
+
+	changes, err = apiInstance.Database.GetTopologyChanges(ctx)
+	require.NoError(t, err)
+	require.Len(t, changes, 1)
+	assert.Equal(t, []int{16, 16, 4, 4}, changes[0].ClusterSizes)
+
+	var clusterShards []shards.Shard
+	require.Equal(t, http.StatusOK, getJSON(t, router, "/api/v1/clusters/2", &clusterShards))
+	require.Len(t, clusterShards, 4)
+	assert.False(t, clusterShards[1].Up)
+
+	// guilds are looked up in the first source unless another one is given
+	guildID := uint64(5) << 22
+	var lookup api.ShardLookup
+	require.Equal(t, http.StatusOK, getJSON(t, router, fmt.Sprintf("/api/v1/shards/lookup?guild_id=%d", guildID), &lookup))
+	assert.Equal(t, "us", lookup.Source)
+	assert.Equal(t, 5, lookup.ShardID)
+	require.Equal(t, http.StatusOK, getJSON(t, router, fmt.Sprintf("/api/v1/shards/lookup?guild_id=%d&source=eu", guildID), &lookup))
+	assert.Equal(t, "eu", lookup.Source)
+	assert.Equal(t, 5, lookup.ShardID)
+	// eu's second cluster comes after both of us' in the combined list
+	assert.Equal(t, 3, lookup.ClusterID)
+	assert.Equal(t, "eu", snapshot.Clusters[lookup.ClusterID].Source)
+	assert.Len(t, lookup.History, 1)
+
+	// incidents are matched against the combined cluster ID too
+	for _, component := range []string{"cluster-1", "cluster-3"} {
+		_, err = apiInstance.Database.CreateIncident(ctx, util.Incident{Name: component + " down", Status: util.StatusInvestigating, Impact: util.ImpactMinor, Components: []string{component}})
+		require.NoError(t, err)
+	}
+	require.Equal(t, http.StatusOK, getJSON(t, router, fmt.Sprintf("/api/v1/shards/lookup?guild_id=%d&source=eu", guildID), &lookup))
+	require.Len(t, lookup.Incidents, 1)
+	assert.Equal(t, "cluster-3 down", lookup.Incidents[0].Name)
+	assert.Equal(t, http.StatusNotFound, getJSON(t, router, fmt.Sprintf("/api/v1/shards/lookup?guild_id=%d&source=asia", guildID), nil))
+
+	// one failing source only makes that source unknown
+	eu.setFailing(true)
+	require.NoError(t, apiInstance.Shards.Poll(ctx))
+	require.Equal(t, http.StatusOK, getJSON(t, router, "/api/v1/clusters", &snapshot))
+	assert.False(t, snapshot.Stale)
+	assert.Equal(t, 40, snapshot.NumShards)
+	assert.Equal(t, 32, snapshot.ShardsUp)
+	assert.Equal(t, shards.HealthHealthy, snapshot.Clusters[0].Status)
+	assert.Equal(t, shards.HealthUnknown, snapshot.Clusters[2].Status)
+	assert.Equal(t, shards.HealthUnknown, snapshot.Clusters[3].Status)
+	assert.True(t, snapshot.Sources[1].Unknown)
+	assert.Equal(t, 1, snapshot.Sources[1].ConsecutiveFailures)
+	require.Equal(t, http.StatusOK, getJSON(t, router, "/api/v1/clusters/3", &clusterShards))
+	assert.Equal(t, shards.HealthUnknown, clusterShards[0].Status)
+
+	status := apiInstance.Shards.Status()
+	assert.False(t, status.Healthy)
+	assert.Zero(t, status.ConsecutiveFailures)
+	require.Len(t, status.Sources, 2)
+	assert.True(t, status.Sources[0].Healthy)
+	assert.False(t, status.Sources[1].Healthy)
+
+	// if every source fails, the last good snapshot is served as stale
+	us.setFailing(true)
+	assert.Error(t, apiInstance.Shards.Poll(ctx))
+	require.Equal(t, http.StatusOK, getJSON(t, router, "/api/v1/clusters", &snapshot))
+	assert.True(t, snapshot.Stale)
+	assert.Equal(t, 32, snapshot.ShardsUp)
+	assert.Equal(t, shards.HealthHealthy, snapshot.Clusters[0].Status)
+	assert.Equal(t, shards.HealthUnknown, snapshot.Clusters[2].Status)
+
+	t.Run("config", func(t *testing.T) {
+		var cfg util.Config
+		require.NoError(t, env.ParseWithOptions(&cfg, env.Options{Environment: map[string]string{
+			"pluralkit__status__shards_sources": `[{"name": "us", "endpoint": "https://us.example.com"}, {"name": "eu", "endpoint": "https://eu.example.com"}]`,
+		}}))
+		assert.Equal(t, util.ShardSources{{Name: "us", Endpoint: "https://us.example.com"}, {Name: "eu", Endpoint: "https://eu.example.com"}}, cfg.ShardsSources)
+
+		for _, sources := range []string{
+			`[{"name": "us", "endpoint": "https://us.example.com"}, {"name": "us", "endpoint": "https://eu.example.com"}]`,
+			`[{"name": "", "endpoint": "https://us.example.com"}]`,
+			`[{"name": "us", "endpoint": "not a url"}]`,
+		} {
+			assert.Error(t, env.ParseWithOptions(&cfg, env.Options{Environment: map[string]string{"pluralkit__status__shards_sources": sources}}), sources)
+		}
+	})
+}
+
+func TestShardStats(t *testing.T) {
+	upstream := newFakeShardsUpstream(t, 20, 10)
+	upstream.set(9, "latency", 1000)
+	upstream.set(3, "last_heartbeat", time.Now().Unix()-30)
+	router, apiInstance := setupClustersAPI(t, util.Config{ShardsEndpoint: upstream.server.URL, MaxConcurrency: 10, ShardsRateWindow: time.Hour})
+	ctx := context.Background()
+
+	var snapshot shards.Snapshot
+	require.Equal(t, http.StatusOK, getJSON(t, router, "/api/v1/clusters", &snapshot))
+	require.Len(t, snapshot.Clusters, 2)
+	assert.Equal(t, shards.Distribution{P50: 104, P90: 108, P99: 1000, Max: 1000}, snapshot.Clusters[0].Stats.Latency)
+	assert.Equal(t, shards.Distribution{P50: 114, P90: 118, P99: 119, Max: 119}, snapshot.Clusters[1].Stats.Latency)
+	assert.Equal(t, shards.Distribution{P50: 110, P90: 118, P99: 1000, Max: 1000}, snapshot.Stats.Latency)
+	assert.InDelta(t, 30, snapshot.Clusters[0].Stats.HeartbeatAge.Max, 2)
+	assert.InDelta(t, 0, snapshot.Clusters[1].Stats.HeartbeatAge.Max, 2)
+	assert.Zero(t, snapshot.Stats.Disconnections)
+
+	// disconnections are counted from the differences between polls, including after upstream restarts
+	upstream.set(2, "disconnection_count", 3)
+	require.NoError(t, apiInstance.Shards.Poll(ctx))
+	upstream.set(12, "disconnection_count", 5)
+	require.NoError(t, apiInstance.Shards.Poll(ctx))
+	upstream.set(12, "disconnection_count", 1)
+	require.NoError(t, apiInstance.Shards.Poll(ctx))
+
+	require.Equal(t, http.StatusOK, getJSON(t, router, "/api/v1/clusters", &snapshot))
+	assert.Equal(t, 3, snapshot.Clusters[0].Stats.Disconnections)
+	assert.Equal(t, 6, snapshot.Clusters[1].Stats.Disconnections)
+	assert.Equal(t, 9, snapshot.Stats.Disconnections)
+	assert.InDelta(t, 9.0, snapshot.Stats.DisconnectionRate, 0.001)
+
+	var clusterShards []shards.Shard
+	require.Equal(t, http.StatusOK, getJSON(t, router, "/api/v1/clusters/1", &clusterShards))
+	assert.Equal(t, 6, clusterShards[2].Disconnections)
+
+	var history []shards.StatsSample
+	require.Equal(t, http.StatusOK, getJSON(t, router, "/api/v1/clusters/history", &history))
+	require.Len(t, history, 4)
+	assert.Zero(t, history[0].Disconnections)
+	assert.Equal(t, 9, history[3].Disconnections)
+	require.Len(t, history[3].Clusters, 2)
+	assert.Equal(t, 6, history[3].Clusters[1].Disconnections)
+	assert.Equal(t, 1000, history[3].Latency.Max)
+
+	t.Run("disconnections leave the window", func(t *testing.T) {
+		poller := shards.NewPoller(util.Config{ShardsEndpoint: upstream.server.URL, MaxConcurrency: 10, ShardsRateWindow: 100 * time.Millisecond}, slog.Default(), apiInstance.Database)
+		require.NoError(t, poller.Poll(ctx))
+		upstream.set(2, "disconnection_count", 4)
+		require.NoError(t, poller.Poll(ctx))
+		snapshot, ok := poller.Snapshot()
+		require.True(t, ok)
+		assert.Equal(t, 1, snapshot.Stats.Disconnections)
+
+		time.Sleep(150 * time.Millisecond)
+		require.NoError(t, poller.Poll(ctx))
+		snapshot, _ = poller.Snapshot()
+		assert.Zero(t, snapshot.Stats.Disconnections)
+	})
+}
+
+func TestShardAnomalies(t *testing.T) {
+	upstream := newFakeShardsUpstream(t, 20, 10)
+	router, apiInstance := setupClustersAPI(t, util.Config{
+		ShardsEndpoint: upstream.server.URL,
+		MaxConcurrency: 10,
+		Anomalies: util.AnomalyThresholds{
+			FlapReconnects:   3,
+			FlapStateChanges: 2,
+			ClusterFlapRatio: 0.2,
+			StormRatio:       0.5,
+			StormIncident:    true,
+		},
+	})
+	ctx := context.Background()
+
+	var anomalies shards.Anomalies
+	require.Equal(t, http.StatusOK, getJSON(t, router, "/api/v1/clusters/anomalies", &anomalies))
+	assert.Empty(t, anomalies.Shards)
+	assert.Empty(t, anomalies.Clusters)
+	assert.Equal(t, 20, anomalies.TotalShards)
+	assert.False(t, anomalies.Storm)
+
+	// shard 1 keeps disconnecting, shard 2 reconnects without upstream counting it, shard 12 goes up and down
+	for i := range 3 {
+		upstream.set(1, "disconnection_count", i+1)
+		upstream.set(2, "last_reconnect", time.Now().Unix()-3000+int64(i))
+		upstream.set(12, "up", i%2 == 1)
+		require.NoError(t, apiInstance.Shards.Poll(ctx))
+	}
+
+	require.Equal(t, http.StatusOK, getJSON(t, router, "/api/v1/clusters/anomalies", &anomalies))
+	assert.False(t, anomalies.Storm)
+	assert.Equal(t, 2, anomalies.ReconnectingShards)
+	require.Len(t, anomalies.Shards, 3)
+	assert.Equal(t, 1, anomalies.Shards[0].ShardID)
+	assert.Equal(t, 3, anomalies.Shards[0].Reconnects)
+	assert.InDelta(t, 18.0, anomalies.Shards[0].ReconnectRate, 0.001)
+	assert.Equal(t, 2, anomalies.Shards[1].ShardID)
+	assert.Equal(t, 3, anomalies.Shards[1].Reconnects)
+	assert.Equal(t, 12, anomalies.Shards[2].ShardID)
+	assert.Equal(t, 1, anomalies.Shards[2].ClusterID)
+	assert.Equal(t, 3, anomalies.Shards[2].StateChanges)
+	assert.Equal(t, "default", anomalies.Shards[2].Source)
+	// only one of cluster 1's shards is flapping, which isn't enough
+	require.Len(t, anomalies.Clusters, 1)
+	assert.Equal(t, shards.ClusterAnomaly{ID: 0, Source: "default", FlappingShards: 2, NumShards: 10}, anomalies.Clusters[0])
+
+	active, err := apiInstance.Database.GetActiveIncidents(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, active.Incidents)
+
+	// half the shards reconnecting is a storm, which opens one incident however long it lasts
+	for round := range 2 {
+		for shard := range 10 {
+			upstream.set(shard, "disconnection_count", 10+round)
+		}
+		require.NoError(t, apiInstance.Shards.Poll(ctx))
+		require.Equal(t, http.StatusOK, getJSON(t, router, "/api/v1/clusters/anomalies", &anomalies))
+		assert.True(t, anomalies.Storm)
+		assert.Equal(t, 10, anomalies.ReconnectingShards)
+	}
+
+	active, err = apiInstance.Database.GetActiveIncidents(ctx)
+	require.NoError(t, err)
+	require.Len(t, active.Incidents, 1)
+	previous := slices.Collect(maps.Keys(active.Incidents))
+	for _, incident := range active.Incidents {
+		assert.Equal(t, "Shards reconnecting", incident.Name)
+		assert.Equal(t, util.ImpactMinor, incident.Impact)
+		assert.Contains(t, incident.Description, "10 of 20 shards")
+	}
+
+	// a new poller with a short window, as if the process restarted
+	restart := func() *shards.Poller {
+		return shards.NewPoller(util.Config{
+			ShardsEndpoint: upstream.server.URL,
+			MaxConcurrency: 10,
+			Anomalies: util.AnomalyThresholds{
+				FlapReconnects:   3,
+				FlapStateChanges: 2,
+				ClusterFlapRatio: 0.2,
+				StormRatio:       0.5,
+				StormIncident:    true,
+				Window:           200 * time.Millisecond,
+			},
+		}, slog.Default(), apiInstance.Database)
+	}
+
+	t.Run("resolved when the storm ends", func(t *testing.T) {
+		// the open incident is picked up after a restart, and a storm going on then keeps using it
+		poller := restart()
+		require.NoError(t, poller.Poll(ctx))
+		for shard := range 10 {
+			upstream.set(shard, "disconnection_count", 20)
+		}
+		require.NoError(t, poller.Poll(ctx))
+		require.True(t, poller.Anomalies().Storm)
+		active, err := apiInstance.Database.GetActiveIncidents(ctx)
+		require.NoError(t, err)
+		require.Len(t, active.Incidents, 1)
+		require.Contains(t, active.Incidents, previous[0])
+
+		time.Sleep(250 * time.Millisecond)
+		require.NoError(t, poller.Poll(ctx))
+		assert.False(t, poller.Anomalies().Storm)
+
+		incident, err := apiInstance.Database.GetIncident(ctx, previous[0])
+		require.NoError(t, err)
+		assert.Equal(t, util.StatusResolved, incident.Status)
+		require.Len(t, incident.Updates, 1)
+		assert.Equal(t, "Shards have stopped reconnecting.", incident.Updates[0].Text)
+	})
+
+	t.Run("resolved after a restart once a window passes without a storm", func(t *testing.T) {
+		id, err := apiInstance.Database.CreateIncident(ctx, util.Incident{Name: "Shards reconnecting", Status: util.StatusInvestigating, Impact: util.ImpactMinor})
+		require.NoError(t, err)
+
+		// reconnects from before the restart can't be seen, so the first polls don't say anything about the storm
+		poller := restart()
+		require.NoError(t, poller.Poll(ctx))
+		require.NoError(t, poller.Poll(ctx))
+		incident, err := apiInstance.Database.GetIncident(ctx, id)
+		require.NoError(t, err)
+		assert.Equal(t, util.StatusInvestigating, incident.Status)
+
+		time.Sleep(250 * time.Millisecond)
+		require.NoError(t, poller.Poll(ctx))
+		incident, err = apiInstance.Database.GetIncident(ctx, id)
+		require.NoError(t, err)
+		assert.Equal(t, util.StatusResolved, incident.Status)
+	})
+}
@@ -0,0 +1,45 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"pluralkit/status/util"
+	"time"
+)
+
+func (d *DB) GetAlertGroup(ctx context.Context, groupKey string) (util.AlertGroup, error) {
+	group := util.AlertGroup{}
+	err := d.database.NewSelect().
+		Model(&group).
+		Where("group_key = ?", groupKey).
+		Scan(ctx)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return group, util.ErrNotFound
+		}
+		return group, err
+	}
+	return group, nil
+}
+
+func (d *DB) SaveAlertGroup(ctx context.Context, group util.AlertGroup) error {
+	group.UpdatedAt = time.Now()
+	_, err := d.database.NewInsert().
+		Model(&group).
+		On("CONFLICT (group_key) DO UPDATE").
+		Set("incident_id = EXCLUDED.incident_id").
+		Set("alerts = EXCLUDED.alerts").
+		Set("impact = EXCLUDED.impact").
+		Set("updated_at = EXCLUDED.updated_at").
+		Exec(ctx)
+	return err
+}
+
+func (d *DB) DeleteAlertGroup(ctx context.Context, groupKey string) error {
+	_, err := d.database.NewDelete().
+		Model((*util.AlertGroup)(nil)).
+		Where("group_key = ?", groupKey).
+		Exec(ctx)
+	return err
+}
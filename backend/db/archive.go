@@ -0,0 +1,455 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"pluralkit/status/util"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// returned from inside the import transaction to roll back a dry run
+var errDryRun = errors.New("dry run")
+
+func (d *DB) ExportArchive(ctx context.Context) (util.Archive, error) {
+	archive := util.Archive{
+		Version:           util.ArchiveVersion,
+		ExportedAt:        time.Now(),
+		Incidents:         make([]util.Incident, 0),
+		WebhookMessages:   make([]util.WebhookMessage, 0),
+		Subscribers:       make([]util.ArchivedSubscriber, 0),
+		WebhookEndpoints:  make([]util.WebhookEndpoint, 0),
+		Monitors:          make([]util.Monitor, 0),
+		Probes:            make([]util.Probe, 0),
+		AlertGroups:       make([]util.AlertGroup, 0),
+		ImportedIncidents: make([]util.ImportedIncident, 0),
+	}
+
+	status, err := d.GetStatus(ctx)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return archive, err
+	}
+	archive.Status = status
+
+	err = d.database.NewSelect().
+		Model(&archive.Incidents).
+		Relation("Updates", func(q *bun.SelectQuery) *bun.SelectQuery {
+			return q.Order("timestamp ASC")
+		}).
+		Order("timestamp ASC").
+		Scan(ctx)
+	if err != nil {
+		return archive, err
+	}
+
+	err = d.database.NewSelect().
+		Model(&archive.WebhookMessages).
+		Order("id ASC", "platform ASC").
+		Scan(ctx)
+	if err != nil {
+		return archive, err
+	}
+
+	subscribers := make([]util.Subscriber, 0)
+	err = d.database.NewSelect().
+		Model(&subscribers).
+		Order("id ASC").
+		Scan(ctx)
+	if err != nil {
+		return archive, err
+	}
+	for _, sub := range subscribers {
+		archive.Subscribers = append(archive.Subscribers, util.ArchiveSubscriber(sub))
+	}
+
+	err = d.database.NewSelect().
+		Model(&archive.WebhookEndpoints).
+		Order("id ASC").
+		Scan(ctx)
+	if err != nil {
+		return archive, err
+	}
+
+	err = d.database.NewSelect().
+		Model(&archive.Monitors).
+		Order("id ASC").
+		Scan(ctx)
+	if err != nil {
+		return archive, err
+	}
+
+	err = d.database.NewSelect().
+		Model(&archive.Probes).
+		Order("id ASC").
+		Scan(ctx)
+	if err != nil {
+		return archive, err
+	}
+
+	err = d.database.NewSelect().
+		Model(&archive.AlertGroups).
+		Order("group_key ASC").
+		Scan(ctx)
+	if err != nil {
+		return archive, err
+	}
+
+	err = d.database.NewSelect().
+		Model(&archive.ImportedIncidents).
+		Order("source ASC", "source_id ASC").
+		Scan(ctx)
+	if err != nil {
+		return archive, err
+	}
+
+	return archive, nil
+}
+
+// imports an archive created by ExportArchive, keeping all IDs and timestamps intact.
+// no events are emitted, so importing never sends out notifications.
+// if any records conflict and opts.SkipConflicts isn't set, nothing is written and util.ErrConflict is returned
+func (d *DB) ImportArchive(ctx context.Context, archive util.Archive, opts util.ImportOptions) (util.ImportResult, error) {
+	result := util.ImportResult{
+		DryRun:    opts.DryRun,
+		Conflicts: make([]util.ImportConflict, 0),
+	}
+
+	if archive.Version != util.ArchiveVersion {
+		return result, util.ErrInvalid
+	}
+	for _, incident := range archive.Incidents {
+		err := util.Validate.Struct(incident)
+		if err != nil {
+			return result, util.ErrInvalid
+		}
+	}
+	for i, msg := range archive.WebhookMessages {
+		if msg.ID == "" || msg.Type == "" {
+			return result, util.ErrInvalid
+		}
+		// archives from before multiple notifiers existed only had discord messages
+		if msg.Platform == "" {
+			archive.WebhookMessages[i].Platform = "discord"
+		}
+	}
+	for _, sub := range archive.Subscribers {
+		if sub.ID == 0 || sub.Email == "" || sub.UnsubscribeToken == "" {
+			return result, util.ErrInvalid
+		}
+	}
+	for _, endpoint := range archive.WebhookEndpoints {
+		if endpoint.ID == 0 || endpoint.Secret == "" || util.Validate.Struct(endpoint) != nil {
+			return result, util.ErrInvalid
+		}
+	}
+	for _, monitor := range archive.Monitors {
+		if monitor.ID == 0 || monitor.Token == "" || util.Validate.Struct(monitor) != nil {
+			return result, util.ErrInvalid
+		}
+	}
+	for _, probe := range archive.Probes {
+		if probe.ID == 0 || util.Validate.Struct(probe) != nil || !probe.ValidTarget() {
+			return result, util.ErrInvalid
+		}
+	}
+	for _, group := range archive.AlertGroups {
+		if group.GroupKey == "" || group.IncidentID == "" {
+			return result, util.ErrInvalid
+		}
+	}
+	for _, imported := range archive.ImportedIncidents {
+		if imported.Source == "" || imported.SourceID == "" || imported.IncidentID == "" {
+			return result, util.ErrInvalid
+		}
+	}
+
+	// sort to keep rowids in the same order as the original database
+	sort.SliceStable(archive.Incidents, func(i, j int) bool {
+		return archive.Incidents[i].Timestamp.Before(archive.Incidents[j].Timestamp)
+	})
+
+	err := d.database.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		for _, incident := range archive.Incidents {
+			exists, err := tx.NewSelect().Model((*util.Incident)(nil)).Where("id = ?", incident.ID).Exists(ctx)
+			if err != nil {
+				return err
+			}
+			if exists {
+				result.Conflicts = append(result.Conflicts, util.ImportConflict{Type: "incident", ID: incident.ID})
+			} else {
+				_, err = tx.NewInsert().
+					Model(&incident).
+					Exec(ctx)
+				if err != nil {
+					return err
+				}
+				result.Incidents++
+			}
+
+			for _, update := range incident.Updates {
+				update.IncidentID = incident.ID
+				exists, err := tx.NewSelect().Model((*util.IncidentUpdate)(nil)).Where("id = ?", update.ID).Exists(ctx)
+				if err != nil {
+					return err
+				}
+				if exists {
+					result.Conflicts = append(result.Conflicts, util.ImportConflict{Type: "update", ID: update.ID})
+					continue
+				}
+				_, err = tx.NewInsert().
+					Model(update).
+					Exec(ctx)
+				if err != nil {
+					return err
+				}
+				result.Updates++
+			}
+		}
+
+		for _, msg := range archive.WebhookMessages {
+			exists, err := tx.NewSelect().Model((*util.WebhookMessage)(nil)).Where("id = ?", msg.ID).Where("platform = ?", msg.Platform).Exists(ctx)
+			if err != nil {
+				return err
+			}
+			if exists {
+				result.Conflicts = append(result.Conflicts, util.ImportConflict{Type: "webhook_message", ID: msg.ID})
+				continue
+			}
+			_, err = tx.NewInsert().
+				Model(&msg).
+				Exec(ctx)
+			if err != nil {
+				return err
+			}
+			result.WebhookMessages++
+		}
+
+		for _, archived := range archive.Subscribers {
+			sub := archived.Unarchive()
+			inserted, err := importRecord(ctx, tx, &result, &sub, util.ImportConflict{Type: "subscriber", ID: sub.Email},
+				"id = ? OR email = ? OR unsubscribe_token = ?", sub.ID, sub.Email, sub.UnsubscribeToken)
+			if err != nil {
+				return err
+			}
+			if inserted {
+				result.Subscribers++
+			}
+		}
+
+		for _, endpoint := range archive.WebhookEndpoints {
+			enabled := endpoint.Enabled
+			inserted, err := importRecord(ctx, tx, &result, &endpoint, util.ImportConflict{Type: "webhook_endpoint", ID: strconv.FormatInt(endpoint.ID, 10)},
+				"id = ?", endpoint.ID)
+			if err != nil {
+				return err
+			}
+			if !inserted {
+				continue
+			}
+			// enabled defaults to true, so inserting a disabled endpoint re-enables it
+			_, err = tx.NewUpdate().
+				Model((*util.WebhookEndpoint)(nil)).
+				Set("enabled = ?", enabled).
+				Where("id = ?", endpoint.ID).
+				Exec(ctx)
+			if err != nil {
+				return err
+			}
+			result.WebhookEndpoints++
+		}
+
+		for _, monitor := range archive.Monitors {
+			inserted, err := importRecord(ctx, tx, &result, &monitor, util.ImportConflict{Type: "monitor", ID: strconv.FormatInt(monitor.ID, 10)},
+				"id = ? OR token = ?", monitor.ID, monitor.Token)
+			if err != nil {
+				return err
+			}
+			if inserted {
+				result.Monitors++
+			}
+		}
+
+		for _, probe := range archive.Probes {
+			inserted, err := importRecord(ctx, tx, &result, &probe, util.ImportConflict{Type: "probe", ID: strconv.FormatInt(probe.ID, 10)},
+				"id = ?", probe.ID)
+			if err != nil {
+				return err
+			}
+			if inserted {
+				result.Probes++
+			}
+		}
+
+		for _, group := range archive.AlertGroups {
+			inserted, err := importRecord(ctx, tx, &result, &group, util.ImportConflict{Type: "alert_group", ID: group.GroupKey},
+				"group_key = ?", group.GroupKey)
+			if err != nil {
+				return err
+			}
+			if inserted {
+				result.AlertGroups++
+			}
+		}
+
+		for _, imported := range archive.ImportedIncidents {
+			inserted, err := importRecord(ctx, tx, &result, &imported, util.ImportConflict{Type: "imported_incident", ID: imported.Source + ":" + imported.SourceID},
+				"source = ? AND source_id = ?", imported.Source, imported.SourceID)
+			if err != nil {
+				return err
+			}
+			if inserted {
+				result.ImportedIncidents++
+			}
+		}
+
+		// the archive's status is only right for its own incidents, so when merging into existing ones it's worked out again
+		err := resetImportedStatus(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		// make sure newly created incidents don't reuse any of the imported IDs
+		err = d.syncSequences(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		if len(result.Conflicts) > 0 && !opts.SkipConflicts {
+			return util.ErrConflict
+		}
+		if opts.DryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errDryRun) {
+		if errors.Is(err, util.ErrConflict) {
+			result = util.ImportResult{DryRun: result.DryRun, Conflicts: result.Conflicts}
+		}
+		return result, err
+	}
+
+	return result, nil
+}
+
+// imports a single incident (and its updates) from another status page, assigning new IDs but keeping all timestamps.
+// returns false if this incident was already imported from the given source. like archives, this never emits events
+func (d *DB) ImportIncident(ctx context.Context, source string, sourceID string, incident util.Incident, dryRun bool) (string, bool, error) {
+	var id string
+	imported := false
+	err := d.database.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		existing := util.ImportedIncident{}
+		err := tx.NewSelect().
+			Model(&existing).
+			Where("source = ?", source).
+			Where("source_id = ?", sourceID).
+			Scan(ctx)
+		if err == nil {
+			id = existing.IncidentID
+			return nil
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		incident.ID, err = d.nextIncidentID(ctx, tx)
+		if err != nil {
+			return err
+		}
+		// updates get their IDs one by one as they're inserted, so validate them separately
+		updates := incident.Updates
+		incident.Updates = nil
+		err = util.Validate.Struct(incident)
+		if err != nil {
+			return util.ErrInvalid
+		}
+
+		_, err = tx.NewInsert().
+			Model(&incident).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		for _, update := range updates {
+			update.IncidentID = incident.ID
+			update.ID, err = d.nextUpdateID(ctx, tx, incident.ID)
+			if err != nil {
+				return err
+			}
+			err = util.Validate.Struct(update)
+			if err != nil {
+				return util.ErrInvalid
+			}
+			_, err = tx.NewInsert().
+				Model(update).
+				Exec(ctx)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = tx.NewInsert().
+			Model(&util.ImportedIncident{Source: source, SourceID: sourceID, IncidentID: incident.ID}).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		// unresolved incidents change the overall status, and no events are emitted to reset it
+		err = resetImportedStatus(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		id = incident.ID
+		imported = true
+		if dryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errDryRun) {
+		return "", false, err
+	}
+	return id, imported, nil
+}
+
+// works out the overall status from the active incidents after an import and saves it
+func resetImportedStatus(ctx context.Context, tx bun.Tx) error {
+	active := make([]util.Incident, 0)
+	err := tx.NewSelect().
+		Model(&active).
+		Column("id", "impact").
+		Where("status != ?", util.StatusResolved).
+		Scan(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = tx.NewInsert().
+		On("CONFLICT (id) DO UPDATE").
+		Model(&util.StatusWrapper{ID: 1, Status: util.StatusFor(active)}).
+		Exec(ctx)
+	return err
+}
+
+// inserts a record from an archive, or adds a conflict if a row matching where already exists.
+// returns whether the record was inserted
+func importRecord[T any](ctx context.Context, tx bun.Tx, result *util.ImportResult, record *T, conflict util.ImportConflict, where string, args ...any) (bool, error) {
+	exists, err := tx.NewSelect().Model((*T)(nil)).Where(where, args...).Exists(ctx)
+	if err != nil {
+		return false, err
+	}
+	if exists {
+		result.Conflicts = append(result.Conflicts, conflict)
+		return false, nil
+	}
+	_, err = tx.NewInsert().
+		Model(record).
+		Exec(ctx)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
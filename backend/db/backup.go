@@ -0,0 +1,37 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+)
+
+// writes a consistent snapshot of the database to path, this is safe to run while the database is in use
+func (d *DB) Backup(ctx context.Context, path string) error {
+	_, err := d.database.ExecContext(ctx, "VACUUM INTO ?", path)
+	return err
+}
+
+func (d *DB) Ping(ctx context.Context) error {
+	return d.database.PingContext(ctx)
+}
+
+// opens a backup read-only and makes sure it passes sqlite's integrity check
+func VerifyBackup(ctx context.Context, path string) error {
+	sqldb, err := sql.Open("sqlite3", (&url.URL{Scheme: "file", Opaque: path, RawQuery: "mode=ro"}).String())
+	if err != nil {
+		return err
+	}
+	defer sqldb.Close() //nolint:all
+
+	var result string
+	err = sqldb.QueryRowContext(ctx, "PRAGMA integrity_check").Scan(&result)
+	if err != nil {
+		return err
+	}
+	if result != "ok" {
+		return fmt.Errorf("integrity check failed: %s", result)
+	}
+	return nil
+}
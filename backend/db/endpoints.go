@@ -0,0 +1,193 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"pluralkit/status/util"
+
+	"github.com/uptrace/bun"
+)
+
+// how many delivery log entries are kept per endpoint
+const deliveryLogSize = 100
+
+func (d *DB) CreateEndpoint(ctx context.Context, endpoint util.WebhookEndpoint) (util.WebhookEndpoint, error) {
+	err := util.Validate.Struct(endpoint)
+	if err != nil {
+		return endpoint, util.ErrInvalid
+	}
+
+	endpoint.ID = 0
+	endpoint.Enabled = true
+	endpoint.ConsecutiveFailures = 0
+	endpoint.Secret, err = newToken()
+	if err != nil {
+		return endpoint, err
+	}
+
+	_, err = d.database.NewInsert().
+		Model(&endpoint).
+		Returning("*").
+		Exec(ctx)
+	return endpoint, err
+}
+
+func (d *DB) GetEndpoints(ctx context.Context) ([]util.WebhookEndpoint, error) {
+	endpoints := make([]util.WebhookEndpoint, 0)
+	err := d.database.NewSelect().
+		Model(&endpoints).
+		Order("id ASC").
+		Scan(ctx)
+	return endpoints, err
+}
+
+func (d *DB) GetEndpoint(ctx context.Context, id int64) (util.WebhookEndpoint, error) {
+	endpoint := util.WebhookEndpoint{}
+	err := d.database.NewSelect().
+		Model(&endpoint).
+		Where("id = ?", id).
+		Scan(ctx)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return endpoint, util.ErrNotFound
+		}
+		return endpoint, err
+	}
+	return endpoint, nil
+}
+
+func (d *DB) EditEndpoint(ctx context.Context, id int64, patch util.WebhookEndpointPatch) (util.WebhookEndpoint, error) {
+	endpoint := util.WebhookEndpoint{}
+	err := util.Validate.Struct(patch)
+	if err != nil {
+		return endpoint, util.ErrInvalid
+	}
+
+	err = d.database.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		err := tx.NewSelect().
+			Model(&endpoint).
+			Where("id = ?", id).
+			Scan(ctx)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return util.ErrNotFound
+			}
+			return err
+		}
+
+		if patch.URL != nil {
+			endpoint.URL = *patch.URL
+		}
+		if patch.Events != nil {
+			endpoint.Events = *patch.Events
+		}
+		if patch.Enabled != nil {
+			if *patch.Enabled && !endpoint.Enabled {
+				endpoint.ConsecutiveFailures = 0
+			}
+			endpoint.Enabled = *patch.Enabled
+		}
+
+		_, err = tx.NewUpdate().
+			Model(&endpoint).
+			Column("url", "events", "enabled", "consecutive_failures").
+			WherePK().
+			Exec(ctx)
+		return err
+	})
+	return endpoint, err
+}
+
+func (d *DB) DeleteEndpoint(ctx context.Context, id int64) error {
+	return d.database.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		res, err := tx.NewDelete().
+			Model((*util.WebhookEndpoint)(nil)).
+			Where("id = ?", id).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return err
+		} else if rows == 0 {
+			return util.ErrNotFound
+		}
+
+		_, err = tx.NewDelete().
+			Model((*util.WebhookDelivery)(nil)).
+			Where("endpoint_id = ?", id).
+			Exec(ctx)
+		return err
+	})
+}
+
+// logs a delivery attempt and updates the endpoint's failure count, disabling it once it reaches maxFailures.
+// returns true if the endpoint was disabled by this delivery
+func (d *DB) RecordDelivery(ctx context.Context, delivery util.WebhookDelivery, maxFailures int) (bool, error) {
+	disabled := false
+	err := d.database.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		endpoint := util.WebhookEndpoint{}
+		err := tx.NewSelect().
+			Model(&endpoint).
+			Where("id = ?", delivery.EndpointID).
+			Scan(ctx)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil // deleted while we were delivering
+		} else if err != nil {
+			return err
+		}
+
+		_, err = tx.NewInsert().
+			Model(&delivery).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.NewDelete().
+			Model((*util.WebhookDelivery)(nil)).
+			Where("endpoint_id = ?", delivery.EndpointID).
+			Where("id NOT IN (?)", tx.NewSelect().
+				Model((*util.WebhookDelivery)(nil)).
+				Column("id").
+				Where("endpoint_id = ?", delivery.EndpointID).
+				Order("id DESC").
+				Limit(deliveryLogSize)).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+
+		// attempts that are retried only count once the last one fails
+		if delivery.Success {
+			endpoint.ConsecutiveFailures = 0
+		} else if !delivery.Retried {
+			endpoint.ConsecutiveFailures++
+			if endpoint.Enabled && maxFailures > 0 && endpoint.ConsecutiveFailures >= maxFailures {
+				endpoint.Enabled = false
+				disabled = true
+			}
+		}
+		_, err = tx.NewUpdate().
+			Model(&endpoint).
+			Column("enabled", "consecutive_failures").
+			WherePK().
+			Exec(ctx)
+		return err
+	})
+	return disabled, err
+}
+
+// returns the latest deliveries to an endpoint, newest first
+func (d *DB) GetDeliveries(ctx context.Context, endpointID int64) ([]util.WebhookDelivery, error) {
+	deliveries := make([]util.WebhookDelivery, 0)
+	err := d.database.NewSelect().
+		Model(&deliveries).
+		Where("endpoint_id = ?", endpointID).
+		Order("id DESC").
+		Limit(deliveryLogSize).
+		Scan(ctx)
+	return deliveries, err
+}
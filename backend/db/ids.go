@@ -0,0 +1,97 @@
+package db
+
+import (
+	"context"
+	"pluralkit/status/util"
+
+	"github.com/uptrace/bun"
+)
+
+const (
+	sequenceIncidents = "incidents"
+	sequenceUpdates   = "updates"
+)
+
+// hands out the next value of a sequence, this must run in the same transaction as the insert using it
+func (d *DB) nextSequence(ctx context.Context, db bun.IDB, name string) (uint64, error) {
+	var value int64
+	err := db.NewUpdate().
+		Model((*util.IDSequence)(nil)).
+		Set("value = value + 1").
+		Where("name = ?", name).
+		Returning("value - 1").
+		Scan(ctx, &value)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(value), nil
+}
+
+func (d *DB) nextIncidentID(ctx context.Context, db bun.IDB) (string, error) {
+	id, err := d.nextSequence(ctx, db, sequenceIncidents)
+	if err != nil {
+		return "", err
+	}
+	return d.sq.Encode([]uint64{id})
+}
+
+func (d *DB) nextUpdateID(ctx context.Context, db bun.IDB, incidentID string) (string, error) {
+	isqid := d.sq.Decode(incidentID)
+	if len(isqid) == 0 {
+		return "", util.ErrInvalid
+	}
+
+	id, err := d.nextSequence(ctx, db, sequenceUpdates)
+	if err != nil {
+		return "", err
+	}
+	return d.sq.Encode([]uint64{isqid[0], id})
+}
+
+// makes sure every sequence is past all IDs already stored, which matters for databases created before
+// sequences existed (where IDs came from MAX(rowid)) and after importing records with existing IDs
+func (d *DB) syncSequences(ctx context.Context, db bun.IDB) error {
+	var incidentIDs []string
+	err := db.NewSelect().
+		Model((*util.Incident)(nil)).
+		Column("id").
+		Scan(ctx, &incidentIDs)
+	if err != nil {
+		return err
+	}
+	var updateIDs []string
+	err = db.NewSelect().
+		Model((*util.IncidentUpdate)(nil)).
+		Column("id").
+		Scan(ctx, &updateIDs)
+	if err != nil {
+		return err
+	}
+
+	// incident IDs encode [incident], update IDs encode [incident, update]
+	nextIncident, nextUpdate := uint64(0), uint64(0)
+	for _, id := range incidentIDs {
+		decoded := d.sq.Decode(id)
+		if len(decoded) > 0 && decoded[0] >= nextIncident {
+			nextIncident = decoded[0] + 1
+		}
+	}
+	for _, id := range updateIDs {
+		decoded := d.sq.Decode(id)
+		if len(decoded) > 1 && decoded[1] >= nextUpdate {
+			nextUpdate = decoded[1] + 1
+		}
+	}
+
+	for name, value := range map[string]uint64{sequenceIncidents: nextIncident, sequenceUpdates: nextUpdate} {
+		_, err = db.NewInsert().
+			Model(&util.IDSequence{Name: name, Value: int64(value)}).
+			On("CONFLICT (name) DO UPDATE").
+			Set("value = MAX(value, EXCLUDED.value)").
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"pluralkit/status/util"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+func (d *DB) CreateMonitor(ctx context.Context, monitor util.Monitor) (util.Monitor, error) {
+	err := util.Validate.Struct(monitor)
+	if err != nil {
+		return monitor, util.ErrInvalid
+	}
+
+	monitor.ID = 0
+	monitor.State = util.MonitorPending
+	monitor.LastPing = time.Time{}
+	monitor.IncidentID = ""
+	if monitor.Impact == "" {
+		monitor.Impact = util.ImpactMinor
+	}
+	monitor.Token, err = newToken()
+	if err != nil {
+		return monitor, err
+	}
+
+	_, err = d.database.NewInsert().
+		Model(&monitor).
+		Returning("*").
+		Exec(ctx)
+	return monitor, err
+}
+
+func (d *DB) GetMonitors(ctx context.Context) ([]util.Monitor, error) {
+	monitors := make([]util.Monitor, 0)
+	err := d.database.NewSelect().
+		Model(&monitors).
+		Order("id ASC").
+		Scan(ctx)
+	return monitors, err
+}
+
+func (d *DB) GetMonitor(ctx context.Context, id int64) (util.Monitor, error) {
+	monitor := util.Monitor{}
+	err := d.database.NewSelect().
+		Model(&monitor).
+		Where("id = ?", id).
+		Scan(ctx)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return monitor, util.ErrNotFound
+		}
+		return monitor, err
+	}
+	return monitor, nil
+}
+
+func (d *DB) EditMonitor(ctx context.Context, id int64, patch util.MonitorPatch) (util.Monitor, error) {
+	monitor := util.Monitor{}
+	err := util.Validate.Struct(patch)
+	if err != nil {
+		return monitor, util.ErrInvalid
+	}
+
+	err = d.database.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		err := tx.NewSelect().
+			Model(&monitor).
+			Where("id = ?", id).
+			Scan(ctx)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return util.ErrNotFound
+			}
+			return err
+		}
+
+		if patch.Name != nil {
+			monitor.Name = *patch.Name
+		}
+		if patch.Component != nil {
+			monitor.Component = *patch.Component
+		}
+		if patch.Interval != nil {
+			monitor.Interval = *patch.Interval
+		}
+		if patch.Grace != nil {
+			monitor.Grace = *patch.Grace
+		}
+		if patch.AutoIncident != nil {
+			monitor.AutoIncident = *patch.AutoIncident
+		}
+		if patch.Impact != nil {
+			monitor.Impact = *patch.Impact
+		}
+
+		_, err = tx.NewUpdate().
+			Model(&monitor).
+			Column("name", "component", "interval", "grace", "auto_incident", "impact").
+			WherePK().
+			Exec(ctx)
+		return err
+	})
+	return monitor, err
+}
+
+func (d *DB) DeleteMonitor(ctx context.Context, id int64) error {
+	res, err := d.database.NewDelete().
+		Model((*util.Monitor)(nil)).
+		Where("id = ?", id).
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	} else if rows == 0 {
+		return util.ErrNotFound
+	}
+	return nil
+}
+
+// records a ping for the monitor with the given token, returning the monitor as it was before the ping
+func (d *DB) PingMonitor(ctx context.Context, token string, timestamp time.Time) (util.Monitor, error) {
+	monitor := util.Monitor{}
+	err := d.database.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		err := tx.NewSelect().
+			Model(&monitor).
+			Where("token = ?", token).
+			Scan(ctx)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return util.ErrNotFound
+			}
+			return err
+		}
+
+		_, err = tx.NewUpdate().
+			Model((*util.Monitor)(nil)).
+			Set("last_ping = ?", timestamp).
+			Where("id = ?", monitor.ID).
+			Exec(ctx)
+		return err
+	})
+	return monitor, err
+}
+
+// saves a monitor's state and the incident opened for it
+func (d *DB) SaveMonitorState(ctx context.Context, monitor util.Monitor) error {
+	_, err := d.database.NewUpdate().
+		Model(&monitor).
+		Column("state", "incident_id").
+		WherePK().
+		Exec(ctx)
+	return err
+}
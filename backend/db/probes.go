@@ -0,0 +1,249 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"pluralkit/status/util"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// how many results are kept per probe
+const probeHistorySize = 100
+
+func (d *DB) CreateProbe(ctx context.Context, probe util.Probe) (util.Probe, error) {
+	err := util.Validate.Struct(probe)
+	if err != nil || !probe.ValidTarget() {
+		return probe, util.ErrInvalid
+	}
+
+	probe.ID = 0
+	probe.State = util.MonitorPending
+	probe.ConsecutiveFailures = 0
+	probe.LastCheck = time.Time{}
+	probe.LastError = ""
+	probe.IncidentID = ""
+	if probe.Type == util.ProbeHTTP {
+		if probe.Method == "" {
+			probe.Method = http.MethodGet
+		}
+		if probe.ExpectedStatus == 0 {
+			probe.ExpectedStatus = http.StatusOK
+		}
+	}
+	if probe.Timeout == 0 {
+		probe.Timeout = 10
+	}
+	if probe.FailureThreshold == 0 {
+		probe.FailureThreshold = 3
+	}
+	if probe.Impact == "" {
+		probe.Impact = util.ImpactMinor
+	}
+
+	_, err = d.database.NewInsert().
+		Model(&probe).
+		Returning("*").
+		Exec(ctx)
+	return probe, err
+}
+
+func (d *DB) GetProbes(ctx context.Context) ([]util.Probe, error) {
+	probes := make([]util.Probe, 0)
+	err := d.database.NewSelect().
+		Model(&probes).
+		Order("id ASC").
+		Scan(ctx)
+	return probes, err
+}
+
+func (d *DB) GetProbe(ctx context.Context, id int64) (util.Probe, error) {
+	probe := util.Probe{}
+	err := d.database.NewSelect().
+		Model(&probe).
+		Where("id = ?", id).
+		Scan(ctx)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return probe, util.ErrNotFound
+		}
+		return probe, err
+	}
+	return probe, nil
+}
+
+func (d *DB) EditProbe(ctx context.Context, id int64, patch util.ProbePatch) (util.Probe, error) {
+	probe := util.Probe{}
+	err := util.Validate.Struct(patch)
+	if err != nil {
+		return probe, util.ErrInvalid
+	}
+
+	err = d.database.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		err := tx.NewSelect().
+			Model(&probe).
+			Where("id = ?", id).
+			Scan(ctx)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return util.ErrNotFound
+			}
+			return err
+		}
+
+		if patch.Name != nil {
+			probe.Name = *patch.Name
+		}
+		if patch.Component != nil {
+			probe.Component = *patch.Component
+		}
+		if patch.Target != nil {
+			probe.Target = *patch.Target
+		}
+		if patch.Method != nil {
+			probe.Method = *patch.Method
+		}
+		if patch.ExpectedStatus != nil {
+			probe.ExpectedStatus = *patch.ExpectedStatus
+		}
+		if patch.Expect != nil {
+			probe.Expect = *patch.Expect
+		}
+		if patch.MaxLatency != nil {
+			probe.MaxLatency = *patch.MaxLatency
+		}
+		if patch.Interval != nil {
+			probe.Interval = *patch.Interval
+		}
+		if patch.Timeout != nil {
+			probe.Timeout = *patch.Timeout
+		}
+		if patch.Retries != nil {
+			probe.Retries = *patch.Retries
+		}
+		if patch.FailureThreshold != nil {
+			probe.FailureThreshold = *patch.FailureThreshold
+		}
+		if patch.AutoIncident != nil {
+			probe.AutoIncident = *patch.AutoIncident
+		}
+		if patch.Impact != nil {
+			probe.Impact = *patch.Impact
+		}
+		if !probe.ValidTarget() {
+			return util.ErrInvalid
+		}
+
+		_, err = tx.NewUpdate().
+			Model(&probe).
+			Column("name", "component", "target", "method", "expected_status", "expect", "max_latency",
+				"interval", "timeout", "retries", "failure_threshold", "auto_incident", "impact").
+			WherePK().
+			Exec(ctx)
+		return err
+	})
+	return probe, err
+}
+
+func (d *DB) DeleteProbe(ctx context.Context, id int64) error {
+	return d.database.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		res, err := tx.NewDelete().
+			Model((*util.Probe)(nil)).
+			Where("id = ?", id).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return err
+		} else if rows == 0 {
+			return util.ErrNotFound
+		}
+
+		_, err = tx.NewDelete().
+			Model((*util.ProbeResult)(nil)).
+			Where("probe_id = ?", id).
+			Exec(ctx)
+		return err
+	})
+}
+
+// stores a check's result and updates the probe's failure count, returning the updated probe
+func (d *DB) RecordProbeResult(ctx context.Context, result util.ProbeResult) (util.Probe, error) {
+	probe := util.Probe{}
+	err := d.database.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		err := tx.NewSelect().
+			Model(&probe).
+			Where("id = ?", result.ProbeID).
+			Scan(ctx)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return util.ErrNotFound
+			}
+			return err
+		}
+
+		result.ID = 0
+		_, err = tx.NewInsert().
+			Model(&result).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+
+		// only keep the newest results
+		_, err = tx.NewDelete().
+			Model((*util.ProbeResult)(nil)).
+			Where("probe_id = ?", result.ProbeID).
+			Where("id NOT IN (?)", tx.NewSelect().
+				Model((*util.ProbeResult)(nil)).
+				Column("id").
+				Where("probe_id = ?", result.ProbeID).
+				Order("id DESC").
+				Limit(probeHistorySize)).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+
+		if result.Success {
+			probe.ConsecutiveFailures = 0
+		} else {
+			probe.ConsecutiveFailures++
+		}
+		probe.LastCheck = result.Timestamp
+		probe.LastError = result.Error
+		_, err = tx.NewUpdate().
+			Model(&probe).
+			Column("consecutive_failures", "last_check", "last_error").
+			WherePK().
+			Exec(ctx)
+		return err
+	})
+	return probe, err
+}
+
+// results for a probe, newest first
+func (d *DB) GetProbeResults(ctx context.Context, probeID int64) ([]util.ProbeResult, error) {
+	results := make([]util.ProbeResult, 0)
+	err := d.database.NewSelect().
+		Model(&results).
+		Where("probe_id = ?", probeID).
+		Order("id DESC").
+		Scan(ctx)
+	return results, err
+}
+
+// saves a probe's state and the incident opened for it
+func (d *DB) SaveProbeState(ctx context.Context, probe util.Probe) error {
+	_, err := d.database.NewUpdate().
+		Model(&probe).
+		Column("state", "incident_id").
+		WherePK().
+		Exec(ctx)
+	return err
+}
@@ -0,0 +1,196 @@
+package db
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"pluralkit/status/util"
+	"strings"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// how long to wait before sending another confirmation email to the same address
+const confirmationCooldown = 10 * time.Minute
+
+func newToken() (string, error) {
+	buf := make([]byte, 32)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+func validateToken(token string) error {
+	err := util.Validate.Var(token, "required,len=64,hexadecimal")
+	if err != nil {
+		return util.ErrInvalid
+	}
+	return nil
+}
+
+// adds a new unconfirmed subscriber, or updates the filters of an existing unconfirmed one.
+// sendConfirmation is false if the address is already confirmed or was sent a confirmation recently
+func (d *DB) Subscribe(ctx context.Context, req util.SubscribeRequest) (sub util.Subscriber, sendConfirmation bool, err error) {
+	err = util.Validate.Struct(req)
+	if err != nil {
+		return sub, false, util.ErrInvalid
+	}
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+
+	err = d.database.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		err := tx.NewSelect().
+			Model(&sub).
+			Where("email = ?", email).
+			Scan(ctx)
+		if errors.Is(err, sql.ErrNoRows) {
+			sub = util.Subscriber{
+				Email:              email,
+				Impacts:            req.Impacts,
+				Components:         req.Components,
+				ConfirmationSentAt: time.Now(),
+			}
+			sub.ConfirmToken, err = newToken()
+			if err != nil {
+				return err
+			}
+			sub.UnsubscribeToken, err = newToken()
+			if err != nil {
+				return err
+			}
+			sendConfirmation = true
+			_, err = tx.NewInsert().
+				Model(&sub).
+				Returning("*").
+				Exec(ctx)
+			return err
+		} else if err != nil {
+			return err
+		}
+
+		// confirmed subscribers have to unsubscribe first, otherwise anyone could change their filters
+		if sub.Confirmed {
+			return nil
+		}
+
+		sub.Impacts = req.Impacts
+		sub.Components = req.Components
+		columns := []string{"impacts", "components"}
+		if time.Since(sub.ConfirmationSentAt) >= confirmationCooldown {
+			sub.ConfirmationSentAt = time.Now()
+			sendConfirmation = true
+			columns = append(columns, "confirmation_sent_at")
+		}
+		_, err = tx.NewUpdate().
+			Model(&sub).
+			Column(columns...).
+			WherePK().
+			Exec(ctx)
+		return err
+	})
+	if err != nil {
+		return util.Subscriber{}, false, err
+	}
+	return sub, sendConfirmation, nil
+}
+
+func (d *DB) ConfirmSubscriber(ctx context.Context, token string) (util.Subscriber, error) {
+	sub := util.Subscriber{}
+	err := validateToken(token)
+	if err != nil {
+		return sub, err
+	}
+
+	err = d.database.NewUpdate().
+		Model(&sub).
+		Set("confirmed = ?", true).
+		Set("confirm_token = NULL").
+		Where("confirm_token = ?", token).
+		Returning("*").
+		Scan(ctx)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return sub, util.ErrNotFound
+		}
+		return sub, err
+	}
+	return sub, nil
+}
+
+// looks up a subscriber by their confirmation token without confirming them
+func (d *DB) GetSubscriberByConfirmToken(ctx context.Context, token string) (util.Subscriber, error) {
+	sub := util.Subscriber{}
+	err := validateToken(token)
+	if err != nil {
+		return sub, err
+	}
+
+	err = d.database.NewSelect().
+		Model(&sub).
+		Where("confirm_token = ?", token).
+		Scan(ctx)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return sub, util.ErrNotFound
+		}
+		return sub, err
+	}
+	return sub, nil
+}
+
+// looks up a subscriber by their unsubscribe token without changing anything
+func (d *DB) GetSubscriberByUnsubscribeToken(ctx context.Context, token string) (util.Subscriber, error) {
+	sub := util.Subscriber{}
+	err := validateToken(token)
+	if err != nil {
+		return sub, err
+	}
+
+	err = d.database.NewSelect().
+		Model(&sub).
+		Where("unsubscribe_token = ?", token).
+		Scan(ctx)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return sub, util.ErrNotFound
+		}
+		return sub, err
+	}
+	return sub, nil
+}
+
+func (d *DB) Unsubscribe(ctx context.Context, token string) error {
+	err := validateToken(token)
+	if err != nil {
+		return err
+	}
+
+	res, err := d.database.NewDelete().
+		Model((*util.Subscriber)(nil)).
+		Where("unsubscribe_token = ?", token).
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return util.ErrNotFound
+	}
+	return nil
+}
+
+func (d *DB) GetConfirmedSubscribers(ctx context.Context) ([]util.Subscriber, error) {
+	subs := make([]util.Subscriber, 0)
+	err := d.database.NewSelect().
+		Model(&subs).
+		Where("confirmed = ?", true).
+		Scan(ctx)
+	return subs, err
+}
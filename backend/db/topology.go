@@ -0,0 +1,61 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"pluralkit/status/util"
+
+	"github.com/uptrace/bun"
+)
+
+// how many topology changes are kept
+const topologyLogSize = 100
+
+func (d *DB) RecordTopologyChange(ctx context.Context, change util.TopologyChange) (util.TopologyChange, error) {
+	change.ID = 0
+	err := d.database.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		_, err := tx.NewInsert().
+			Model(&change).
+			Returning("*").
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.NewDelete().
+			Model((*util.TopologyChange)(nil)).
+			Where("id NOT IN (?)", tx.NewSelect().
+				Model((*util.TopologyChange)(nil)).
+				Column("id").
+				Order("id DESC").
+				Limit(topologyLogSize)).
+			Exec(ctx)
+		return err
+	})
+	return change, err
+}
+
+// returns util.ErrNotFound if no topology has been recorded yet
+func (d *DB) GetLatestTopology(ctx context.Context) (util.TopologyChange, error) {
+	change := util.TopologyChange{}
+	err := d.database.NewSelect().
+		Model(&change).
+		Order("id DESC").
+		Limit(1).
+		Scan(ctx)
+	if errors.Is(err, sql.ErrNoRows) {
+		return change, util.ErrNotFound
+	}
+	return change, err
+}
+
+// newest first
+func (d *DB) GetTopologyChanges(ctx context.Context) ([]util.TopologyChange, error) {
+	changes := make([]util.TopologyChange, 0)
+	err := d.database.NewSelect().
+		Model(&changes).
+		Order("id DESC").
+		Scan(ctx)
+	return changes, err
+}
@@ -3,9 +3,11 @@ package db
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"log/slog"
 	"pluralkit/status/util"
+	"strings"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -25,11 +27,17 @@ type DB struct {
 func NewDB(config util.Config, logger *slog.Logger, eventChannel chan util.Event) *DB {
 	moduleLogger := logger.With(slog.String("module", "db"))
 
-	sqldb, err := sql.Open("sqlite3", config.DBLoc)
+	dsn, inMemory := sqliteDSN(config.DBLoc)
+	sqldb, err := sql.Open("sqlite3", dsn)
 	if err != nil {
 		moduleLogger.Error("error while opening database", slog.Any("error", err))
 		return nil
 	}
+	if inMemory {
+		// every connection to a private in-memory database gets its own empty database, and shared cache ones
+		// fail with "database table is locked" instead of waiting, so those stay on a single connection
+		sqldb.SetMaxOpenConns(1)
+	}
 
 	bunDB := bun.NewDB(sqldb, sqlitedialect.New(), bun.WithDiscardUnknownColumns())
 	if config.LogLevel == util.SlogLevel(slog.LevelDebug) {
@@ -58,6 +66,37 @@ func NewDB(config util.Config, logger *slog.Logger, eventChannel chan util.Event
 
 	return db
 }
+
+// sqlite only allows a single writer at a time. file databases use WAL so reads don't wait for writes,
+// and transactions take the write lock when they begin (waiting for it if needed) instead of failing
+// with "database is locked" when a read inside them turns into a write
+func sqliteDSN(loc string) (string, bool) {
+	if strings.Contains(loc, ":memory:") || strings.Contains(loc, "mode=memory") {
+		return loc, true
+	}
+	params := []string{"_journal_mode=WAL", "_txlock=immediate", "_busy_timeout=5000"}
+	for _, param := range params {
+		key, _, _ := strings.Cut(param, "=")
+		if strings.Contains(loc, key+"=") {
+			continue // set explicitly in the config
+		}
+		if strings.Contains(loc, "?") {
+			loc += "&" + param
+		} else {
+			loc += "?" + param
+		}
+	}
+	return loc, false
+}
+
+// sends out an event for a write, this must only be called after the write has been committed
+func (d *DB) emit(eventType util.EventType, modified any) {
+	d.events <- util.Event{
+		Type:     eventType,
+		Modified: modified,
+	}
+}
+
 func (d *DB) CloseDB() error {
 	return d.database.Close()
 }
@@ -73,6 +112,11 @@ func (d *DB) initDB() error {
 		d.logger.Error("error while creating incidents table", slog.Any("error", err))
 		return err
 	}
+	err = d.addColumnIfMissing(ctx, "incidents", "components", "VARCHAR")
+	if err != nil {
+		d.logger.Error("error while adding incidents components column", slog.Any("error", err))
+		return err
+	}
 	_, err = d.database.NewCreateIndex().
 		Model((*util.Incident)(nil)).
 		IfNotExists().
@@ -103,6 +147,11 @@ func (d *DB) initDB() error {
 		return err
 	}
 
+	err = d.migrateWebhookMessages(ctx)
+	if err != nil {
+		d.logger.Error("error while migrating webhook messages table", slog.Any("error", err))
+		return err
+	}
 	_, err = d.database.NewCreateTable().
 		Model((*util.WebhookMessage)(nil)).
 		IfNotExists().
@@ -112,9 +161,165 @@ func (d *DB) initDB() error {
 		return err
 	}
 
+	_, err = d.database.NewCreateTable().
+		Model((*util.IDSequence)(nil)).
+		IfNotExists().
+		Exec(ctx)
+	if err != nil {
+		d.logger.Error("error while creating id sequences table", slog.Any("error", err))
+		return err
+	}
+	// sequences only need to catch up with existing IDs once, imports sync them again themselves
+	sequences, err := d.database.NewSelect().
+		Model((*util.IDSequence)(nil)).
+		Count(ctx)
+	if err != nil {
+		d.logger.Error("error while counting id sequences", slog.Any("error", err))
+		return err
+	}
+	if sequences == 0 {
+		err = d.syncSequences(ctx, d.database)
+		if err != nil {
+			d.logger.Error("error while syncing id sequences", slog.Any("error", err))
+			return err
+		}
+	}
+
+	_, err = d.database.NewCreateTable().
+		Model((*util.ImportedIncident)(nil)).
+		IfNotExists().
+		ForeignKey(`("incident_id") REFERENCES "incidents" ("id") ON DELETE CASCADE`).
+		Exec(ctx)
+	if err != nil {
+		d.logger.Error("error while creating imported incidents table", slog.Any("error", err))
+		return err
+	}
+
+	_, err = d.database.NewCreateTable().
+		Model((*util.Subscriber)(nil)).
+		IfNotExists().
+		Exec(ctx)
+	if err != nil {
+		d.logger.Error("error while creating subscribers table", slog.Any("error", err))
+		return err
+	}
+
+	_, err = d.database.NewCreateTable().
+		Model((*util.WebhookEndpoint)(nil)).
+		IfNotExists().
+		Exec(ctx)
+	if err != nil {
+		d.logger.Error("error while creating webhook endpoints table", slog.Any("error", err))
+		return err
+	}
+	_, err = d.database.NewCreateTable().
+		Model((*util.WebhookDelivery)(nil)).
+		IfNotExists().
+		ForeignKey(`("endpoint_id") REFERENCES "webhook_endpoints" ("id") ON DELETE CASCADE`).
+		Exec(ctx)
+	if err != nil {
+		d.logger.Error("error while creating webhook deliveries table", slog.Any("error", err))
+		return err
+	}
+
+	_, err = d.database.NewCreateTable().
+		Model((*util.TopologyChange)(nil)).
+		IfNotExists().
+		Exec(ctx)
+	if err != nil {
+		d.logger.Error("error while creating shard topology changes table", slog.Any("error", err))
+		return err
+	}
+
+	_, err = d.database.NewCreateTable().
+		Model((*util.Monitor)(nil)).
+		IfNotExists().
+		Exec(ctx)
+	if err != nil {
+		d.logger.Error("error while creating monitors table", slog.Any("error", err))
+		return err
+	}
+
+	_, err = d.database.NewCreateTable().
+		Model((*util.Probe)(nil)).
+		IfNotExists().
+		Exec(ctx)
+	if err != nil {
+		d.logger.Error("error while creating probes table", slog.Any("error", err))
+		return err
+	}
+	_, err = d.database.NewCreateTable().
+		Model((*util.ProbeResult)(nil)).
+		IfNotExists().
+		ForeignKey(`("probe_id") REFERENCES "probes" ("id") ON DELETE CASCADE`).
+		Exec(ctx)
+	if err != nil {
+		d.logger.Error("error while creating probe results table", slog.Any("error", err))
+		return err
+	}
+
+	_, err = d.database.NewCreateTable().
+		Model((*util.AlertGroup)(nil)).
+		IfNotExists().
+		Exec(ctx)
+	if err != nil {
+		d.logger.Error("error while creating alert groups table", slog.Any("error", err))
+		return err
+	}
+
 	return nil
 }
 
+// webhook messages used to be discord only, keyed by just the incident/update ID.
+// sqlite can't change primary keys in place, so copy everything into a new table keyed by ID and platform
+func (d *DB) migrateWebhookMessages(ctx context.Context) error {
+	var count int
+	err := d.database.NewRaw("SELECT COUNT(*) FROM pragma_table_info('webhook_messages') WHERE name = 'platform'").Scan(ctx, &count)
+	if err != nil {
+		return err
+	}
+	var exists bool
+	err = d.database.NewRaw("SELECT COUNT(*) > 0 FROM sqlite_master WHERE type = 'table' AND name = 'webhook_messages'").Scan(ctx, &exists)
+	if err != nil || !exists || count > 0 {
+		return err
+	}
+
+	d.logger.Info("migrating webhook messages table")
+	return d.database.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		_, err := tx.ExecContext(ctx, "ALTER TABLE webhook_messages RENAME TO webhook_messages_old")
+		if err != nil {
+			return err
+		}
+		_, err = tx.NewCreateTable().
+			Model((*util.WebhookMessage)(nil)).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "INSERT INTO webhook_messages (id, platform, type, message_id) SELECT id, 'discord', type, message_id FROM webhook_messages_old")
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "DROP TABLE webhook_messages_old")
+		return err
+	})
+}
+
+// adds a column to an existing table, since CREATE TABLE IF NOT EXISTS won't touch databases created by older versions
+func (d *DB) addColumnIfMissing(ctx context.Context, table string, column string, definition string) error {
+	var count int
+	err := d.database.NewRaw("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", table, column).Scan(ctx, &count)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	_, err = d.database.NewRaw("ALTER TABLE ? ADD COLUMN ? "+definition, bun.Ident(table), bun.Ident(column)).Exec(ctx)
+	return err
+}
+
 func (d *DB) GetStatus(ctx context.Context) (util.Status, error) {
 	statusWrapper := &util.StatusWrapper{
 		ID: 1,
@@ -142,17 +347,21 @@ func (d *DB) SaveStatus(ctx context.Context, status util.Status) error {
 	return err
 }
 
-func (d *DB) GetMessageID(ctx context.Context, id string, msgType string) (int64, error) {
+func (d *DB) GetMessage(ctx context.Context, id string, msgType string, platform string) (util.WebhookMessage, error) {
 	msg := util.WebhookMessage{}
 	err := d.database.NewSelect().
 		Model(&msg).
 		Where("id = ?", id).
 		Where("type = ?", msgType).
+		Where("platform = ?", platform).
 		Scan(ctx)
 	if err != nil {
-		return 0, err
+		if errors.Is(err, sql.ErrNoRows) {
+			return msg, util.ErrNotFound
+		}
+		return msg, err
 	}
-	return msg.MessageID, nil
+	return msg, nil
 }
 func (d *DB) SaveMessageID(ctx context.Context, msgInfo util.WebhookMessage) error {
 	_, err := d.database.NewInsert().
@@ -272,42 +481,30 @@ func (d *DB) CreateIncident(ctx context.Context, incident util.Incident) (string
 		return "", errors.New("invalid status field")
 	}
 
-	var maxRow sql.NullInt64
-	var id int64
-	err := d.database.NewRaw("SELECT MAX(rowid) FROM incidents").Scan(ctx, &maxRow)
-	if err != nil {
-		return "", err
-	}
-	if !maxRow.Valid {
-		id = 0
-	} else {
-		id = maxRow.Int64
-	}
-
-	sqid, err := d.sq.Encode([]uint64{uint64(id)})
-	if err != nil {
-		return "", err
-	}
+	err := d.database.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		sqid, err := d.nextIncidentID(ctx, tx)
+		if err != nil {
+			return err
+		}
 
-	incident.ID = sqid
+		incident.ID = sqid
 
-	err = util.Validate.Struct(incident)
-	if err != nil {
-		return "", util.ErrInvalid
-	}
+		err = util.Validate.Struct(incident)
+		if err != nil {
+			return util.ErrInvalid
+		}
 
-	_, err = d.database.NewInsert().
-		Model(&incident).
-		Returning("*").
-		Exec(ctx)
+		_, err = tx.NewInsert().
+			Model(&incident).
+			Returning("*").
+			Exec(ctx)
+		return err
+	})
 	if err != nil {
 		return "", err
 	}
 
-	d.events <- util.Event{
-		Type:     util.EventCreateIncident,
-		Modified: incident,
-	}
+	d.emit(util.EventCreateIncident, incident)
 
 	return incident.ID, nil
 }
@@ -344,6 +541,13 @@ func (d *DB) EditIncident(ctx context.Context, id string, patch util.IncidentPat
 		}
 		patchMap["impact"] = *patch.Impact
 	}
+	if patch.Components != nil {
+		components, err := json.Marshal(*patch.Components)
+		if err != nil {
+			return err
+		}
+		patchMap["components"] = string(components)
+	}
 
 	if len(patchMap) == 0 {
 		return nil // prevent update if there isn't anything to update
@@ -351,30 +555,47 @@ func (d *DB) EditIncident(ctx context.Context, id string, patch util.IncidentPat
 	patchMap["last_update"] = time.Now()
 
 	incident := util.Incident{}
-	res, err := d.database.NewUpdate().
-		Model(&patchMap).
-		Table("incidents").
-		Returning("*").
-		Where("id = ?", id).
-		Exec(ctx, &incident)
-	if err != nil {
+	var previousStatus util.IncidentStatus
+	// the status before the edit goes out with the event, so notifiers can tell if it changed
+	err = d.database.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		err := tx.NewSelect().
+			Model((*util.Incident)(nil)).
+			Column("status").
+			Where("id = ?", id).
+			Scan(ctx, &previousStatus)
 		if errors.Is(err, sql.ErrNoRows) {
 			return util.ErrNotFound
+		} else if err != nil {
+			return err
 		}
-		return err
-	}
 
-	rows, err := res.RowsAffected()
+		res, err := tx.NewUpdate().
+			Model(&patchMap).
+			Table("incidents").
+			Returning("*").
+			Where("id = ?", id).
+			Exec(ctx, &incident)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return util.ErrNotFound
+			}
+			return err
+		}
+
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return err
+		} else if rows == 0 {
+			return util.ErrNotFound
+		}
+		return nil
+	})
 	if err != nil {
 		return err
-	} else if rows == 0 {
-		return util.ErrNotFound
 	}
+	incident.PreviousStatus = previousStatus
 
-	d.events <- util.Event{
-		Type:     util.EventEditIncident,
-		Modified: incident,
-	}
+	d.emit(util.EventEditIncident, incident)
 
 	return nil
 }
@@ -385,24 +606,51 @@ func (d *DB) DeleteIncident(ctx context.Context, incident util.Incident) error {
 		return util.ErrInvalid
 	}
 
-	res, err := d.database.NewDelete().
-		Model(&incident).
-		WherePK().
-		Exec(ctx)
-	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return util.ErrNotFound
+	err = d.database.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		// load the full incident first, so the delete event has everything that was removed
+		err := tx.NewSelect().
+			Model(&incident).
+			Relation("Updates").
+			WherePK().
+			Scan(ctx)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return util.ErrNotFound
+			}
+			return err
 		}
-		return err
-	}
 
-	rows, err := res.RowsAffected()
+		// updates are normally removed by the foreign key cascade, but that depends on _foreign_keys being set
+		_, err = tx.NewDelete().
+			Model((*util.IncidentUpdate)(nil)).
+			Where("incident_id = ?", incident.ID).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+
+		res, err := tx.NewDelete().
+			Model(&incident).
+			WherePK().
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return err
+		} else if rows == 0 {
+			return util.ErrNotFound
+		}
+		return nil
+	})
 	if err != nil {
 		return err
-	} else if rows == 0 {
-		return util.ErrNotFound
 	}
 
+	d.emit(util.EventDeleteIncident, incident)
+
 	return nil
 }
 
@@ -411,73 +659,60 @@ func (d *DB) CreateUpdate(ctx context.Context, update util.IncidentUpdate) (stri
 		return "", errors.New("incidentID not provided")
 	}
 
-	exists, err := d.database.NewSelect().Model((*util.Incident)(nil)).Where("id = ?", update.IncidentID).Exists(ctx)
-	if err != nil || !exists {
-		return "", util.ErrNotFound
-	}
-
-	var maxRow sql.NullInt64
-	var id int64
-	err = d.database.NewRaw("SELECT MAX(rowid) FROM incident_updates").Scan(ctx, &maxRow)
-	if err != nil {
-		return "", err
-	}
-	if !maxRow.Valid {
-		id = 0
-	} else {
-		id = maxRow.Int64
-	}
-
-	isqid := d.sq.Decode(update.IncidentID)
-	sqid, err := d.sq.Encode([]uint64{isqid[0], uint64(id)})
-	if err != nil {
-		return "", err
-	}
-	update.ID = sqid
-
-	err = util.Validate.Struct(update)
-	if err != nil {
-		return "", util.ErrInvalid
-	}
+	// the update and the incident's status have to change together, or not at all
+	err := d.database.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		err := tx.NewSelect().
+			Model((*util.Incident)(nil)).
+			Column("status").
+			Where("id = ?", update.IncidentID).
+			Scan(ctx, &update.PreviousStatus)
+		if errors.Is(err, sql.ErrNoRows) {
+			return util.ErrNotFound
+		} else if err != nil {
+			return err
+		}
 
-	_, err = d.database.NewInsert().
-		Model(&update).
-		Returning("*").
-		Exec(ctx)
-	if err != nil {
-		return "", err
-	}
+		sqid, err := d.nextUpdateID(ctx, tx, update.IncidentID)
+		if err != nil {
+			return err
+		}
+		update.ID = sqid
 
-	if update.Status != nil && update.Status.IsValid() {
-		resTime := time.Time{}
-		if *update.Status == util.StatusResolved {
-			resTime = time.Now()
+		err = util.Validate.Struct(update)
+		if err != nil {
+			return util.ErrInvalid
 		}
-		_, err = d.database.NewUpdate().
-			Model(&util.Incident{}).
-			Set("last_update = ?", time.Now()).
-			Set("status = ?", update.Status).
-			Set("resolution_timestamp = ?", resTime).
-			Where("id = ?", update.IncidentID).
+
+		_, err = tx.NewInsert().
+			Model(&update).
+			Returning("*").
 			Exec(ctx)
-	} else {
-		_, err = d.database.NewUpdate().
+		if err != nil {
+			return err
+		}
+
+		query := tx.NewUpdate().
 			Model(&util.Incident{}).
 			Set("last_update = ?", time.Now()).
-			Where("id = ?", update.IncidentID).
-			Exec(ctx)
-	}
-
+			Where("id = ?", update.IncidentID)
+		if update.Status != nil && update.Status.IsValid() {
+			resTime := time.Time{}
+			if *update.Status == util.StatusResolved {
+				resTime = time.Now()
+			}
+			query = query.
+				Set("status = ?", update.Status).
+				Set("resolution_timestamp = ?", resTime)
+		}
+		_, err = query.Exec(ctx)
+		return err
+	})
 	if err != nil {
 		return "", err
 	}
 
-	d.events <- util.Event{
-		Type:     util.EventCreateUpdate,
-		Modified: update,
-	}
-
-	return sqid, err
+	d.emit(util.EventCreateUpdate, update)
+	return update.ID, nil
 }
 
 func (d *DB) GetUpdate(ctx context.Context, id string) (util.IncidentUpdate, error) {
@@ -542,10 +777,7 @@ func (d *DB) EditUpdate(ctx context.Context, id string, update util.UpdatePatch)
 		return util.ErrNotFound
 	}
 
-	d.events <- util.Event{
-		Type:     util.EventEditUpdate,
-		Modified: updated,
-	}
+	d.emit(util.EventEditUpdate, updated)
 	return nil
 }
 
@@ -555,22 +787,39 @@ func (d *DB) DeleteUpdate(ctx context.Context, update util.IncidentUpdate) error
 		return util.ErrInvalid
 	}
 
-	res, err := d.database.NewDelete().
-		Model(&update).
-		WherePK().
-		Exec(ctx)
-	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return util.ErrNotFound
+	err = d.database.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		err := tx.NewSelect().
+			Model(&update).
+			WherePK().
+			Scan(ctx)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return util.ErrNotFound
+			}
+			return err
 		}
-		return err
-	}
 
-	rows, err := res.RowsAffected()
+		res, err := tx.NewDelete().
+			Model(&update).
+			WherePK().
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return err
+		} else if rows == 0 {
+			return util.ErrNotFound
+		}
+		return nil
+	})
 	if err != nil {
 		return err
-	} else if rows == 0 {
-		return util.ErrNotFound
 	}
+
+	d.emit(util.EventDeleteUpdate, update)
+
 	return nil
 }
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"pluralkit/status/util"
+	"pluralkit/status/webhook"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiscordClient(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("waits for exhausted buckets", func(t *testing.T) {
+		var mutex sync.Mutex
+		var times []time.Time
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mutex.Lock()
+			times = append(times, time.Now())
+			mutex.Unlock()
+			// both message routes share a bucket, which is exhausted after every request
+			w.Header().Set("X-RateLimit-Bucket", "abcd")
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			w.Header().Set("X-RateLimit-Reset-After", "0.2")
+			fmt.Fprint(w, `{"id": "1"}`)
+		}))
+		defer server.Close()
+
+		client := webhook.NewDiscordClient(util.Config{})
+		require.NoError(t, client.Do(ctx, http.MethodPatch, server.URL+"/webhooks/1/token/messages/100", nil, map[string]string{}, nil))
+
+		var wg sync.WaitGroup
+		for i := range 2 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				url := fmt.Sprintf("%s/webhooks/1/token/messages/%d", server.URL, 200+i)
+				assert.NoError(t, client.Do(ctx, http.MethodPatch, url, nil, map[string]string{}, nil))
+			}()
+		}
+		wg.Wait()
+
+		require.Len(t, times, 3)
+		assert.GreaterOrEqual(t, times[1].Sub(times[0]), 150*time.Millisecond)
+		assert.GreaterOrEqual(t, times[2].Sub(times[1]), 150*time.Millisecond)
+	})
+
+	t.Run("retries after 429", func(t *testing.T) {
+		var count atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if count.Add(1) == 1 {
+				w.Header().Set("Retry-After", "1")
+				w.WriteHeader(http.StatusTooManyRequests)
+				fmt.Fprint(w, `{"message": "You are being rate limited.", "retry_after": 0.1, "global": false}`)
+				return
+			}
+			fmt.Fprint(w, `{"id": "1234", "channel_id": "42"}`)
+		}))
+		defer server.Close()
+
+		client := webhook.NewDiscordClient(util.Config{})
+		var data webhook.DiscordResponse
+		start := time.Now()
+		err := client.Do(ctx, http.MethodPost, server.URL+"/webhooks/1/token", nil, map[string]string{"content": "hi"}, &data)
+		require.NoError(t, err)
+		assert.Equal(t, int32(2), count.Load())
+		assert.Equal(t, "1234", data.ID)
+		// retry_after from the body is more precise than the header
+		assert.Less(t, time.Since(start), 900*time.Millisecond)
+	})
+
+	t.Run("json errors", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasSuffix(r.URL.Path, "/gone") {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"code": 50035, "message": "Invalid Form Body", "errors": {"components": {"_errors": [{"code": "BASE_TYPE_REQUIRED"}]}}}`)
+		}))
+		defer server.Close()
+
+		client := webhook.NewDiscordClient(util.Config{})
+		err := client.Do(ctx, http.MethodPost, server.URL+"/webhooks/1/token", nil, map[string]string{}, nil)
+		var discordErr *webhook.DiscordError
+		require.True(t, errors.As(err, &discordErr))
+		assert.Equal(t, 50035, discordErr.Code)
+		assert.Equal(t, http.StatusBadRequest, discordErr.Status)
+		assert.Contains(t, err.Error(), "Invalid Form Body")
+		assert.Contains(t, err.Error(), "BASE_TYPE_REQUIRED")
+
+		err = client.Do(ctx, http.MethodPost, server.URL+"/webhooks/1/token/gone", nil, nil, nil)
+		require.True(t, errors.As(err, &discordErr))
+		assert.Equal(t, http.StatusBadGateway, discordErr.Status)
+		assert.Equal(t, "Bad Gateway", discordErr.Message)
+	})
+
+	t.Run("fails fast past the deadline", func(t *testing.T) {
+		var count atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			count.Add(1)
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			w.Header().Set("X-RateLimit-Reset-After", "30")
+			fmt.Fprint(w, `{"id": "1"}`)
+		}))
+		defer server.Close()
+
+		client := webhook.NewDiscordClient(util.Config{})
+		require.NoError(t, client.Do(ctx, http.MethodPost, server.URL+"/webhooks/1/token", nil, nil, nil))
+
+		// the bucket resets long after the deadline, so there's no point waiting for it
+		deadlineCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		start := time.Now()
+		err := client.Do(deadlineCtx, http.MethodPost, server.URL+"/webhooks/1/token", nil, nil, nil)
+		require.Error(t, err)
+		assert.Less(t, time.Since(start), time.Second)
+		assert.Equal(t, int32(1), count.Load())
+	})
+
+	t.Run("timeouts", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(500 * time.Millisecond)
+		}))
+		defer server.Close()
+
+		client := webhook.NewDiscordClient(util.Config{DiscordTimeout: 100 * time.Millisecond})
+		err := client.Do(ctx, http.MethodPost, server.URL+"/webhooks/1/secret-token", nil, nil, nil)
+		require.Error(t, err)
+		assert.NotContains(t, err.Error(), "secret-token")
+	})
+}
@@ -0,0 +1,203 @@
+// Package email renders and sends notification emails over SMTP
+package email
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"net/url"
+	"pluralkit/status/util"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+	"time"
+)
+
+//go:embed templates
+var templateFS embed.FS
+
+var textTemplates = texttemplate.Must(texttemplate.ParseFS(templateFS, "templates/*.txt"))
+var htmlTemplates = htmltemplate.Must(htmltemplate.ParseFS(templateFS, "templates/*.html"))
+
+// Mailer sends multipart text/html emails through an SMTP server.
+// notifications are queued and sent in the background by Run, confirmation emails are sent right away
+type Mailer struct {
+	logger      *slog.Logger
+	addr        string
+	from        string
+	fromAddress string // just the address part of from, for the envelope
+	auth        smtp.Auth
+	publicURL   string
+	workers     int
+
+	mutex   sync.Mutex
+	pending []message
+	sending int           // messages taken from the queue that haven't been sent yet
+	empty   *sync.Cond    // broadcast when nothing is pending or being sent
+	wake    chan struct{} // signalled when messages are queued
+}
+
+func NewMailer(config util.Config, logger *slog.Logger) *Mailer {
+	moduleLogger := logger.With(slog.String("module", "email"))
+	var auth smtp.Auth
+	if config.SMTPUsername != "" {
+		host, _, _ := net.SplitHostPort(config.SMTPAddr)
+		auth = smtp.PlainAuth("", config.SMTPUsername, config.SMTPPassword, host)
+	}
+	fromAddress := ""
+	from, err := mail.ParseAddress(config.SMTPFrom)
+	if err != nil {
+		moduleLogger.Error("invalid smtp from address, emails won't be sent", slog.Any("error", err))
+	} else {
+		fromAddress = from.Address
+	}
+	workers := config.SMTPWorkers
+	if workers <= 0 {
+		workers = 4
+	}
+	m := &Mailer{
+		logger:      moduleLogger,
+		addr:        config.SMTPAddr,
+		from:        config.SMTPFrom,
+		fromAddress: fromAddress,
+		auth:        auth,
+		publicURL:   strings.TrimSuffix(config.PublicURL, "/"),
+		workers:     workers,
+		wake:        make(chan struct{}, 1),
+	}
+	m.empty = sync.NewCond(&m.mutex)
+	return m
+}
+
+// data passed to all templates
+type templateData struct {
+	PublicURL      string
+	ConfirmURL     string
+	UnsubscribeURL string
+	IncidentURL    string
+	Incident       util.Incident
+	Update         util.IncidentUpdate
+	Resolved       bool
+}
+
+func (m *Mailer) newTemplateData(sub util.Subscriber) templateData {
+	return templateData{
+		PublicURL:      m.publicURL,
+		UnsubscribeURL: fmt.Sprintf("%s/api/v1/subscriptions/unsubscribe?token=%s", m.publicURL, url.QueryEscape(sub.UnsubscribeToken)),
+	}
+}
+
+// queues an email with the link to confirm a subscription
+func (m *Mailer) QueueConfirmation(sub util.Subscriber) error {
+	data := m.newTemplateData(sub)
+	data.ConfirmURL = fmt.Sprintf("%s/api/v1/subscriptions/confirm?token=%s", m.publicURL, url.QueryEscape(sub.ConfirmToken))
+	// not subscribed yet, so no unsubscribe link
+	data.UnsubscribeURL = ""
+	return m.queue(sub.Email, "confirm your subscription to PluralKit status updates", "confirm", data)
+}
+
+// queues an email about a new incident
+func (m *Mailer) QueueIncident(sub util.Subscriber, incident util.Incident) error {
+	data := m.newTemplateData(sub)
+	data.Incident = incident
+	data.IncidentURL = fmt.Sprintf("%s/i/%s", m.publicURL, incident.ID)
+	return m.queue(sub.Email, fmt.Sprintf("[PluralKit Status] new incident: %s", incident.Name), "incident", data)
+}
+
+// queues an email about an incident update
+func (m *Mailer) QueueUpdate(sub util.Subscriber, incident util.Incident, update util.IncidentUpdate) error {
+	data := m.newTemplateData(sub)
+	data.Incident = incident
+	data.Update = update
+	data.IncidentURL = fmt.Sprintf("%s/i/%s", m.publicURL, incident.ID)
+	data.Resolved = update.Status != nil && *update.Status == util.StatusResolved
+
+	subject := fmt.Sprintf("[PluralKit Status] update: %s", incident.Name)
+	if data.Resolved {
+		subject = fmt.Sprintf("[PluralKit Status] resolved: %s", incident.Name)
+	}
+	return m.queue(sub.Email, subject, "update", data)
+}
+
+func (m *Mailer) queue(to string, subject string, name string, data templateData) error {
+	if m.fromAddress == "" {
+		return errors.New("invalid from address")
+	}
+	msg, err := m.render(to, subject, name, data)
+	if err != nil {
+		return err
+	}
+	m.enqueue(message{to: to, data: msg})
+	return nil
+}
+
+// builds a multipart/alternative message with both a plain text and html body
+func (m *Mailer) render(to string, subject string, name string, data templateData) ([]byte, error) {
+	var text, html bytes.Buffer
+	err := textTemplates.ExecuteTemplate(&text, name+".txt", data)
+	if err != nil {
+		return nil, err
+	}
+	err = htmlTemplates.ExecuteTemplate(&html, name+".html", data)
+	if err != nil {
+		return nil, err
+	}
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", m.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	if data.UnsubscribeURL != "" {
+		// one-click unsubscribe (RFC 8058), mail clients POST to the url directly
+		fmt.Fprintf(&msg, "List-Unsubscribe: <%s>\r\n", data.UnsubscribeURL)
+		fmt.Fprintf(&msg, "List-Unsubscribe-Post: List-Unsubscribe=One-Click\r\n")
+	}
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: multipart/alternative; boundary=%q\r\n\r\n", writer.Boundary())
+
+	for _, part := range []struct {
+		contentType string
+		content     []byte
+	}{
+		{"text/plain; charset=utf-8", text.Bytes()},
+		{"text/html; charset=utf-8", html.Bytes()},
+	} {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", part.contentType)
+		header.Set("Content-Transfer-Encoding", "quoted-printable")
+		partWriter, err := writer.CreatePart(header)
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(partWriter)
+		_, err = qp.Write(part.content)
+		if err != nil {
+			return nil, err
+		}
+		err = qp.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = writer.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	msg.Write(body.Bytes())
+	return msg.Bytes(), nil
+}
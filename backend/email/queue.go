@@ -0,0 +1,198 @@
+package email
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log/slog"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"time"
+)
+
+// how long a worker keeps its SMTP connection open without anything to send
+const idleTimeout = 30 * time.Second
+
+// a rendered email waiting to be sent
+type message struct {
+	to   string
+	data []byte
+}
+
+// adds a message to the queue, it's sent in the background once Run is going
+func (m *Mailer) enqueue(msg message) {
+	m.mutex.Lock()
+	m.pending = append(m.pending, msg)
+	m.mutex.Unlock()
+	m.signal()
+}
+
+// wakes up a worker, if none are waiting the signal is kept for the next one to check
+func (m *Mailer) signal() {
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// takes the next message from the queue
+func (m *Mailer) next() (message, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if len(m.pending) == 0 {
+		return message{}, false
+	}
+	msg := m.pending[0]
+	m.pending[0] = message{}
+	m.pending = m.pending[1:]
+	m.sending++
+	if len(m.pending) > 0 {
+		// let another worker pick up the rest
+		m.signal()
+	}
+	return msg, true
+}
+
+func (m *Mailer) finish() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.sending--
+	if len(m.pending) == 0 && m.sending == 0 {
+		m.empty.Broadcast()
+	}
+}
+
+// blocks until every queued message has been sent (or failed to)
+func (m *Mailer) Wait() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for len(m.pending) > 0 || m.sending > 0 {
+		m.empty.Wait()
+	}
+}
+
+// sends queued messages with the configured number of workers until ctx is cancelled
+func (m *Mailer) Run(ctx context.Context) {
+	done := make(chan struct{})
+	for range m.workers {
+		go func() {
+			m.work(ctx)
+			done <- struct{}{}
+		}()
+	}
+	for range m.workers {
+		<-done
+	}
+}
+
+// sends messages one after another over a single connection, which is closed again once it's idle
+func (m *Mailer) work(ctx context.Context) {
+	var client *smtp.Client
+	defer func() {
+		if client != nil {
+			_ = client.Quit()
+		}
+	}()
+
+	idle := time.NewTimer(idleTimeout)
+	defer idle.Stop()
+	for {
+		msg, ok := m.next()
+		if !ok {
+			select {
+			case <-ctx.Done():
+				return
+			case <-m.wake:
+			case <-idle.C:
+				if client != nil {
+					_ = client.Quit()
+					client = nil
+				}
+				idle.Reset(idleTimeout)
+			}
+			continue
+		}
+
+		var err error
+		client, err = m.deliver(client, msg)
+		if err != nil {
+			m.logger.Error("error while sending email", slog.Any("error", err))
+		}
+		m.finish()
+		idle.Reset(idleTimeout)
+	}
+}
+
+// sends a message over client, reconnecting once if the connection went bad. returns the connection to reuse, if any
+func (m *Mailer) deliver(client *smtp.Client, msg message) (*smtp.Client, error) {
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if client == nil {
+			client, err = m.dial()
+			if err != nil {
+				return nil, err
+			}
+		}
+		err = m.transmit(client, msg)
+		var smtpErr *textproto.Error
+		if err == nil || errors.As(err, &smtpErr) {
+			// the server answered, so the connection is still fine even if it refused the message
+			return client, err
+		}
+		// the server might have closed a connection that was reused, so try once more on a new one
+		_ = client.Close()
+		client = nil
+	}
+	return nil, err
+}
+
+// connects the same way smtp.SendMail does, using STARTTLS and auth if the server supports them
+func (m *Mailer) dial() (*smtp.Client, error) {
+	client, err := smtp.Dial(m.addr)
+	if err != nil {
+		return nil, err
+	}
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		host, _, _ := net.SplitHostPort(m.addr)
+		err = client.StartTLS(&tls.Config{ServerName: host})
+		if err != nil {
+			_ = client.Close()
+			return nil, err
+		}
+	}
+	if m.auth != nil {
+		if ok, _ := client.Extension("AUTH"); ok {
+			err = client.Auth(m.auth)
+			if err != nil {
+				_ = client.Close()
+				return nil, err
+			}
+		}
+	}
+	return client, nil
+}
+
+func (m *Mailer) transmit(client *smtp.Client, msg message) error {
+	err := client.Reset()
+	if err != nil {
+		return err
+	}
+	err = client.Mail(m.fromAddress)
+	if err != nil {
+		return err
+	}
+	err = client.Rcpt(msg.to)
+	if err != nil {
+		return err
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(msg.data)
+	if err != nil {
+		return err
+	}
+	return writer.Close()
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"pluralkit/status/db"
+	"pluralkit/status/util"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sets up a file-backed database (so sqlite locking behaves like it does in production),
+// with events drained in the background so writers never block
+func setupTestFileDB(t *testing.T) (*db.DB, string) {
+	path := filepath.Join(t.TempDir(), "status.db")
+	cfg := util.Config{
+		DBLoc:    fmt.Sprintf("file:%s?_foreign_keys=on", path),
+		LogLevel: util.SlogLevel(slog.LevelError),
+	}
+	eventChannel := make(chan util.Event)
+	database := db.NewDB(cfg, slog.Default(), eventChannel)
+	require.NotNil(t, database)
+	go func() {
+		for range eventChannel {
+		}
+	}()
+	t.Cleanup(func() {
+		assert.NoError(t, database.CloseDB())
+		close(eventChannel)
+	})
+	return database, cfg.DBLoc
+}
+
+func TestConcurrentCreates(t *testing.T) {
+	database, _ := setupTestFileDB(t)
+	ctx := context.Background()
+
+	const workers = 16
+	const perWorker = 10
+
+	var mutex sync.Mutex
+	incidentIDs := make(map[string]struct{})
+	updateIDs := make(map[string]struct{})
+
+	var wg sync.WaitGroup
+	for w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range perWorker {
+				id, err := database.CreateIncident(ctx, util.Incident{Name: fmt.Sprintf("%d-%d", w, i), Status: util.StatusInvestigating, Impact: util.ImpactMinor})
+				if !assert.NoError(t, err) {
+					return
+				}
+				updateID, err := database.CreateUpdate(ctx, util.IncidentUpdate{IncidentID: id, Text: "update"})
+				if !assert.NoError(t, err) {
+					return
+				}
+
+				mutex.Lock()
+				incidentIDs[id] = struct{}{}
+				updateIDs[updateID] = struct{}{}
+				mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Len(t, incidentIDs, workers*perWorker)
+	assert.Len(t, updateIDs, workers*perWorker)
+}
+
+func TestIDsNotReused(t *testing.T) {
+	database, dbLoc := setupTestFileDB(t)
+	ctx := context.Background()
+
+	first, err := database.CreateIncident(ctx, util.Incident{Name: "first", Status: util.StatusInvestigating, Impact: util.ImpactMinor})
+	require.NoError(t, err)
+	newest, err := database.CreateIncident(ctx, util.Incident{Name: "newest", Status: util.StatusInvestigating, Impact: util.ImpactMinor})
+	require.NoError(t, err)
+	update, err := database.CreateUpdate(ctx, util.IncidentUpdate{IncidentID: newest, Text: "update"})
+	require.NoError(t, err)
+
+	require.NoError(t, database.DeleteUpdate(ctx, util.IncidentUpdate{ID: update}))
+	require.NoError(t, database.DeleteIncident(ctx, util.Incident{ID: newest}))
+
+	created, err := database.CreateIncident(ctx, util.Incident{Name: "created", Status: util.StatusInvestigating, Impact: util.ImpactMinor})
+	require.NoError(t, err)
+	assert.NotEqual(t, newest, created)
+	assert.NotEqual(t, first, created)
+	createdUpdate, err := database.CreateUpdate(ctx, util.IncidentUpdate{IncidentID: created, Text: "update"})
+	require.NoError(t, err)
+	assert.NotEqual(t, update, createdUpdate)
+
+	t.Run("survives restart", func(t *testing.T) {
+		reopened := db.NewDB(util.Config{DBLoc: dbLoc, LogLevel: util.SlogLevel(slog.LevelError)}, slog.Default(), make(chan util.Event, 1))
+		require.NotNil(t, reopened)
+		defer reopened.CloseDB() //nolint:all
+
+		id, err := reopened.CreateIncident(ctx, util.Incident{Name: "after restart", Status: util.StatusInvestigating, Impact: util.ImpactMinor})
+		require.NoError(t, err)
+		for _, existing := range []string{first, newest, created} {
+			assert.NotEqual(t, existing, id)
+		}
+	})
+}
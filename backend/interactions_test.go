@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"pluralkit/status/api"
+	"pluralkit/status/util"
+	"pluralkit/status/webhook"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiscordInteractions(t *testing.T) {
+	database, _ := setupTestFileDB(t)
+	ctx := context.Background()
+
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+
+	recorder := &requestRecorder{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := recorder.record(r)
+		switch req.Path {
+		case "/webhook":
+			fmt.Fprint(w, `{"id": "1000", "channel_id": "42"}`)
+		default:
+			fmt.Fprint(w, `{}`)
+		}
+	}))
+	defer server.Close()
+
+	cfg := util.Config{
+		DiscordPublicKey:  hex.EncodeToString(publicKey),
+		DiscordStaffRoles: []string{"10", "11"},
+		DiscordAPIURL:     server.URL + "/api",
+		PublicURL:         "https://status.example.com",
+	}
+	apiInstance := api.NewAPI(cfg, slog.Default(), database)
+	apiInstance.Discord = webhook.NewDiscordClient(cfg)
+	router := chi.NewRouter()
+	router.Use(render.SetContentType(render.ContentTypeJSON))
+	apiInstance.SetupRoutes(router)
+
+	send := func(t *testing.T, body string, sign bool) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodPost, "/api/v1/discord/interactions", strings.NewReader(body))
+		if sign {
+			timestamp := fmt.Sprint(time.Now().Unix())
+			req.Header.Set("X-Signature-Timestamp", timestamp)
+			req.Header.Set("X-Signature-Ed25519", hex.EncodeToString(ed25519.Sign(privateKey, []byte(timestamp+body))))
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// sends a staff member's interaction, returning the immediate response
+	interact := func(t *testing.T, interactionType webhook.InteractionType, data map[string]any) webhook.InteractionResponse {
+		recorder.reset()
+		body, err := json.Marshal(map[string]any{
+			"id":             "1",
+			"application_id": "500",
+			"type":           interactionType,
+			"token":          "interaction-token",
+			"member":         map[string]any{"roles": []string{"1", "11"}, "user": map[string]any{"id": "99", "username": "staff"}},
+			"data":           data,
+		})
+		require.NoError(t, err)
+		rr := send(t, string(body), true)
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		var resp webhook.InteractionResponse
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		return resp
+	}
+
+	// waits for the deferred response to be edited with the result
+	followup := func(t *testing.T) string {
+		var content string
+		require.Eventually(t, func() bool {
+			for _, req := range recorder.all() {
+				if req.Method == http.MethodPatch && req.Path == "/api/webhooks/500/interaction-token/messages/@original" {
+					content, _ = req.Body["content"].(string)
+					return true
+				}
+			}
+			return false
+		}, 5*time.Second, 10*time.Millisecond)
+		return content
+	}
+
+	command := func(name string, options ...map[string]any) map[string]any {
+		return map[string]any{
+			"name":    "incident",
+			"options": []map[string]any{{"name": name, "type": 1, "options": options}},
+		}
+	}
+	option := func(name string, value string) map[string]any {
+		return map[string]any{"name": name, "type": 3, "value": value}
+	}
+
+	t.Run("signatures", func(t *testing.T) {
+		ping := `{"type": 1}`
+		assert.Equal(t, http.StatusUnauthorized, send(t, ping, false).Code)
+
+		req, _ := http.NewRequest(http.MethodPost, "/api/v1/discord/interactions", strings.NewReader(ping))
+		req.Header.Set("X-Signature-Timestamp", "1")
+		req.Header.Set("X-Signature-Ed25519", hex.EncodeToString(ed25519.Sign(privateKey, []byte("2"+ping))))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+
+		rr = send(t, ping, true)
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.JSONEq(t, `{"type": 1}`, rr.Body.String())
+	})
+
+	t.Run("requires a staff role", func(t *testing.T) {
+		body := `{"type": 2, "member": {"roles": ["1"]}, "data": {"name": "incident", "options": [{"name": "create", "type": 1}]}}`
+		rr := send(t, body, true)
+		require.Equal(t, http.StatusOK, rr.Code)
+		var resp webhook.InteractionResponse
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		assert.Equal(t, webhook.CallbackMessage, resp.Type)
+		assert.Equal(t, int(webhook.Ephemeral), resp.Data.Flags)
+		assert.Contains(t, resp.Data.Content, "permission")
+
+		list, err := database.GetActiveIncidents(ctx)
+		require.NoError(t, err)
+		assert.Empty(t, list.Incidents)
+	})
+
+	var incidentID string
+	t.Run("create command", func(t *testing.T) {
+		resp := interact(t, webhook.InteractionCommand, command("create",
+			option("name", "bot down"), option("impact", "major"), option("description", "it's down")))
+		assert.Equal(t, webhook.CallbackDeferredMessage, resp.Type)
+		assert.Equal(t, int(webhook.Ephemeral), resp.Data.Flags)
+
+		content := followup(t)
+		list, err := database.GetActiveIncidents(ctx)
+		require.NoError(t, err)
+		require.Len(t, list.Incidents, 1)
+		for id, incident := range list.Incidents {
+			incidentID = id
+			assert.Equal(t, "bot down", incident.Name)
+			assert.Equal(t, util.ImpactMajor, incident.Impact)
+			assert.Equal(t, util.StatusInvestigating, incident.Status)
+		}
+		assert.Equal(t, fmt.Sprintf("created incident `%s`: https://status.example.com/i/%s", incidentID, incidentID), content)
+
+		resp = interact(t, webhook.InteractionCommand, command("create", option("name", "x"), option("impact", "huge")))
+		assert.Equal(t, webhook.CallbackMessage, resp.Type)
+	})
+	require.NotEmpty(t, incidentID)
+
+	t.Run("update buttons", func(t *testing.T) {
+		customID := webhook.IncidentButtonID("update", incidentID)
+		resp := interact(t, webhook.InteractionComponent, map[string]any{"custom_id": customID, "component_type": 2})
+		require.Equal(t, webhook.CallbackModal, resp.Type)
+		assert.Equal(t, customID, resp.Data.CustomID)
+		require.Len(t, resp.Data.Components, 2)
+
+		submit := func(status string) map[string]any {
+			return map[string]any{
+				"custom_id": customID,
+				"components": []map[string]any{
+					{"type": 1, "components": []map[string]any{{"type": 4, "custom_id": "text", "value": "found it"}}},
+					{"type": 1, "components": []map[string]any{{"type": 4, "custom_id": "status", "value": status}}},
+				},
+			}
+		}
+		resp = interact(t, webhook.InteractionModalSubmit, submit("sideways"))
+		assert.Equal(t, webhook.CallbackMessage, resp.Type)
+
+		resp = interact(t, webhook.InteractionModalSubmit, submit(" Monitoring "))
+		assert.Equal(t, webhook.CallbackDeferredMessage, resp.Type)
+		assert.Contains(t, followup(t), "posted update")
+
+		incident, err := database.GetIncident(ctx, incidentID)
+		require.NoError(t, err)
+		assert.Equal(t, util.StatusMonitoring, incident.Status)
+		require.Len(t, incident.Updates, 1)
+		assert.Equal(t, "found it", incident.Updates[0].Text)
+
+		resp = interact(t, webhook.InteractionComponent, map[string]any{"custom_id": webhook.IncidentButtonID("resolve", incidentID)})
+		require.Equal(t, webhook.CallbackModal, resp.Type)
+		require.Len(t, resp.Data.Components, 1)
+	})
+
+	t.Run("update and resolve commands", func(t *testing.T) {
+		interact(t, webhook.InteractionCommand, command("update", option("id", "zzzzzz"), option("text", "hello")))
+		assert.Contains(t, followup(t), "doesn't exist")
+
+		interact(t, webhook.InteractionCommand, command("resolve", option("id", incidentID)))
+		assert.Equal(t, fmt.Sprintf("resolved incident `%s`", incidentID), followup(t))
+
+		incident, err := database.GetIncident(ctx, incidentID)
+		require.NoError(t, err)
+		assert.Equal(t, util.StatusResolved, incident.Status)
+		require.Len(t, incident.Updates, 2)
+	})
+
+	t.Run("buttons on incident messages", func(t *testing.T) {
+		notifier := webhook.NewDiscordWebhook(util.Config{NotificationWebhook: server.URL + "/webhook", DiscordButtons: true}, apiInstance.Discord)
+		incident := util.Incident{ID: "abcdef", Name: "bot down", Status: util.StatusInvestigating, Impact: util.ImpactMinor}
+
+		recorder.reset()
+		_, err := notifier.SendIncident(incident)
+		require.NoError(t, err)
+		requests := recorder.all()
+		require.Len(t, requests, 1)
+		components := requests[0].Body["components"].([]any)
+		row := components[len(components)-1].(map[string]any)
+		assert.EqualValues(t, webhook.ActionRow, row["type"])
+		buttons := row["components"].([]any)
+		require.Len(t, buttons, 2)
+		assert.Equal(t, "incident:update:abcdef", buttons[0].(map[string]any)["custom_id"])
+		assert.Equal(t, "incident:resolve:abcdef", buttons[1].(map[string]any)["custom_id"])
+
+		// resolved incidents lose their buttons
+		recorder.reset()
+		incident.Status = util.StatusResolved
+		require.NoError(t, notifier.EditIncident(util.WebhookMessage{MessageID: 1000}, incident))
+		components = recorder.all()[0].Body["components"].([]any)
+		assert.NotEqualValues(t, webhook.ActionRow, components[len(components)-1].(map[string]any)["type"])
+	})
+}
@@ -2,14 +2,20 @@ package main
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"log/slog"
+	"maps"
 	"net/http"
 	"os"
 	"os/signal"
 	"pluralkit/status/api"
+	"pluralkit/status/backup"
 	"pluralkit/status/db"
+	"pluralkit/status/email"
 	"pluralkit/status/util"
 	"pluralkit/status/webhook"
+	"slices"
 	"syscall"
 	"time"
 
@@ -21,42 +27,32 @@ import (
 	_ "github.com/mattn/go-sqlite3"
 )
 
-func resetStatus(database *db.DB) {
+// recalculates the overall status from the active incidents, returning the new status and whether it changed
+func resetStatus(database *db.DB) (util.Status, bool) {
 	ctx := context.Background()
-	status := util.Status{
-		OverallStatus:   util.StatusOperational,
-		ActiveIncidents: make([]string, 0),
+	status := util.StatusFor(nil)
+
+	previous, err := database.GetStatus(ctx)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		slog.Error("error while getting previous status", slog.Any("error", err))
 	}
 
 	incidents, err := database.GetActiveIncidents(ctx)
 	if err != nil {
 		slog.Error("error while resetting status!", slog.Any("error", err))
-		return
-	}
-
-	highestImpact := util.ImpactNone
-	for key, val := range incidents.Incidents {
-		status.ActiveIncidents = append(status.ActiveIncidents, key)
-
-		if val.Impact.IsGreater(highestImpact) {
-			highestImpact = val.Impact
-		}
-	}
-
-	switch highestImpact {
-	case util.ImpactMajor:
-		status.OverallStatus = util.StatusMajorOutage
-	case util.ImpactMinor:
-		status.OverallStatus = util.StatusDegraded
-	default:
-		status.OverallStatus = util.StatusOperational
+		return status, false
 	}
+	status = util.StatusFor(slices.Collect(maps.Values(incidents.Incidents)))
 
 	err = database.SaveStatus(ctx, status)
 	if err != nil {
 		slog.Error("error while saving status to db", slog.Any("error", err))
-		return
+		return status, false
 	}
+
+	slices.Sort(previous.ActiveIncidents)
+	changed := previous.OverallStatus != status.OverallStatus || !slices.Equal(previous.ActiveIncidents, status.ActiveIncidents)
+	return status, changed
 }
 
 func main() {
@@ -71,23 +67,71 @@ func main() {
 		Level: slog.Level(cfg.LogLevel),
 	}))
 
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(cfg, logger, os.Args[1:]))
+	}
+
 	//setup our signal handler
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 
+	//cancelled on shutdown to stop background tasks
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
 	//setup event channel
 	eventChannel := make(chan util.Event)
 
-	//setup discord notif webhook
-	doNotifs := cfg.NotificationWebhook != ""
-	webhook := webhook.NewDiscordWebhook(cfg)
-
 	logger.Info("setting up database")
 	db := db.NewDB(cfg, logger, eventChannel)
 	if db == nil {
 		os.Exit(1)
 	}
 
+	//setup notifiers
+	if cfg.DiscordBotToken == "" {
+		threadMessages := cfg.DiscordThreadMode == util.DiscordThreadsMessage
+		for _, target := range cfg.DiscordTargets {
+			threadMessages = threadMessages || target.ThreadMode == util.DiscordThreadsMessage
+		}
+		if threadMessages {
+			logger.Error("starting threads on discord messages needs a bot token, set pluralkit__status__discord_bot_token")
+			os.Exit(1)
+		}
+	}
+	// one client for everything talking to discord, so rate limits are tracked in one place
+	discordClient := webhook.NewDiscordClient(cfg)
+	notifiers := make([]webhook.Notifier, 0)
+	if cfg.NotificationWebhook != "" {
+		notifiers = append(notifiers, webhook.NewDiscordWebhook(cfg, discordClient))
+	}
+	for name, target := range cfg.DiscordTargets {
+		notifiers = append(notifiers, webhook.NewDiscordTarget(cfg, name, target, discordClient))
+	}
+	if cfg.SlackWebhook != "" || (cfg.SlackToken != "" && cfg.SlackChannel != "") {
+		notifiers = append(notifiers, webhook.NewSlackNotifier(cfg))
+	}
+	if cfg.MatrixHomeserver != "" && cfg.MatrixToken != "" && cfg.MatrixRoom != "" {
+		notifiers = append(notifiers, webhook.NewMatrixNotifier(cfg))
+	}
+	if cfg.TelegramToken != "" && cfg.TelegramChat != "" {
+		notifiers = append(notifiers, webhook.NewTelegramNotifier(cfg))
+	}
+	if cfg.MastodonInstance != "" && cfg.MastodonToken != "" {
+		notifiers = append(notifiers, webhook.NewMastodonNotifier(cfg))
+	}
+	var mailer *email.Mailer
+	if cfg.SMTPAddr != "" && cfg.SMTPFrom != "" {
+		mailer = email.NewMailer(cfg, logger)
+		go mailer.Run(ctx)
+		notifiers = append(notifiers, webhook.NewEmailNotifier(db, mailer))
+	}
+	dispatcher := webhook.NewDispatcher(logger, db, notifiers...)
+	dispatcher.SetRules(cfg.NotificationRules)
+	deliverer := webhook.NewDeliverer(cfg, logger, db)
+	go deliverer.Run(ctx)
+	dispatcher.AddHandler(deliverer)
+
 	resetStatus(db)
 
 	logger.Info("starting http api on ", slog.String("address", cfg.BindAddr))
@@ -98,6 +142,15 @@ func main() {
 	r.Use(middleware.Timeout(30 * time.Second))
 
 	apiInstance := api.NewAPI(cfg, logger, db)
+	apiInstance.Mailer = mailer
+	apiInstance.Discord = discordClient
+	go apiInstance.Shards.Run(ctx)
+	go apiInstance.Monitors.Run(ctx)
+	if cfg.BackupDir != "" {
+		logger.Info("backing up database", slog.String("directory", cfg.BackupDir), slog.Duration("interval", cfg.BackupInterval))
+		apiInstance.Backups = backup.NewScheduler(cfg, logger, db)
+		go apiInstance.Backups.Run(ctx)
+	}
 	apiInstance.SetupRoutes(r)
 
 	if cfg.RunDev {
@@ -116,103 +169,16 @@ func main() {
 
 	go func() {
 		//this is really just a notif handler for now, if other status checks get added, probably check them here
-		ctx := context.Background()
 		for {
 			select {
 			case <-quit:
 				return
 			case event := <-eventChannel:
-				resetStatus(db)
-				if err != nil {
-					logger.Error("error while getting status!", slog.Any("error", err))
-					continue
-				}
-				if !doNotifs {
-					continue
-				}
-				switch event.Type {
-				case util.EventCreateIncident:
-					incident, ok := (event.Modified).(util.Incident)
-					if !ok {
-						continue
-					}
-
-					msgID, err := webhook.SendIncident(incident)
-					if err != nil {
-						logger.Error("error while sending incident notif!", slog.Any("error", err))
-						continue
-					}
-					err = db.SaveMessageID(ctx, util.WebhookMessage{
-						ID:        incident.ID,
-						MessageID: msgID,
-						Type:      "incident",
-					})
-					if err != nil {
-						logger.Error("error while saving webhook message id", slog.Any("error", err))
-					}
-				case util.EventCreateUpdate:
-					update, ok := (event.Modified).(util.IncidentUpdate)
-					if !ok {
-						continue
-					}
-					incident, err := db.GetIncident(ctx, update.IncidentID)
-					if err != nil {
-						logger.Error("error while getting incident for update notif!", slog.Any("error", err))
-						continue
-					}
-
-					msgID, err := webhook.SendUpdate(incident, update)
-					if err != nil {
-						logger.Error("error while sending update notif!", slog.Any("error", err))
-						continue
-					}
-					err = db.SaveMessageID(ctx, util.WebhookMessage{
-						ID:        update.ID,
-						MessageID: msgID,
-						Type:      "update",
-					})
-					if err != nil {
-						logger.Error("error while saving webhook message id", slog.Any("error", err))
-					}
-				case util.EventEditIncident:
-					incident, ok := (event.Modified).(util.Incident)
-					if !ok {
-						continue
-					}
-
-					msgID, err := db.GetMessageID(ctx, incident.ID, "incident")
-					if err != nil {
-						logger.Error("error while getting msg id!", slog.Any("error", err))
-						continue
-					}
-
-					err = webhook.EditIncident(msgID, incident)
-					if err != nil {
-						logger.Error("error while editing incident notif!", slog.Any("error", err))
-						continue
-					}
-				case util.EventEditUpdate:
-					update, ok := (event.Modified).(util.IncidentUpdate)
-					if !ok {
-						continue
-					}
-					incident, err := db.GetIncident(ctx, update.IncidentID)
-					if err != nil {
-						logger.Error("error while getting incident for update notif!", slog.Any("error", err))
-						continue
-					}
-
-					msgID, err := db.GetMessageID(ctx, update.ID, "update")
-					if err != nil {
-						logger.Error("error while getting msg id!", slog.Any("error", err))
-						continue
-					}
-
-					err = webhook.EditUpdate(msgID, incident, update)
-					if err != nil {
-						logger.Error("error while editing update notif!", slog.Any("error", err))
-						continue
-					}
+				status, changed := resetStatus(db)
+				dispatcher.Handle(ctx, event)
+				// status changes don't come from the db, so they're dispatched here instead of through the channel
+				if changed {
+					dispatcher.Handle(ctx, util.Event{Type: util.EventStatusChange, Modified: status})
 				}
 			}
 		}
@@ -221,6 +187,7 @@ func main() {
 	//wait until sigint/sigterm and safely shutdown
 	sig := <-quit
 	logger.Info("shutting down", slog.String("signal", sig.String()))
+	cancel()
 	err = db.CloseDB()
 	logger.Error("error while closing db", slog.Any("error", err))
 }
@@ -0,0 +1,285 @@
+// Package monitors tracks push monitors, which other services ping to show they're still up,
+// and runs synthetic probes against http, tcp and dns targets
+package monitors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"pluralkit/status/db"
+	"pluralkit/status/util"
+	"sync"
+	"time"
+)
+
+type Checker struct {
+	logger     *slog.Logger
+	database   *db.DB
+	interval   time.Duration
+	httpClient *http.Client // timeouts are set per probe
+
+	mutex        sync.Mutex // held while monitors and probes change state, so pings and checks can't race each other. never held while creating incidents
+	runningMutex sync.Mutex
+	running      map[int64]bool // probes currently being checked
+}
+
+func NewChecker(config util.Config, logger *slog.Logger, database *db.DB) *Checker {
+	moduleLogger := logger.With(slog.String("module", "monitors"))
+	interval := config.MonitorInterval
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	return &Checker{
+		logger:     moduleLogger,
+		database:   database,
+		interval:   interval,
+		httpClient: &http.Client{},
+		running:    make(map[int64]bool),
+	}
+}
+
+// checks every interval until ctx is cancelled
+func (c *Checker) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := c.checkMonitors(ctx)
+			if err != nil {
+				c.logger.Error("error while checking monitors", slog.Any("error", err))
+			}
+			// probes can take a while, so they don't hold up the next tick
+			go func() {
+				err := c.checkProbes(ctx)
+				if err != nil {
+					c.logger.Error("error while checking probes", slog.Any("error", err))
+				}
+			}()
+		}
+	}
+}
+
+// marks monitors that missed their deadline as down and runs the probes that are due
+func (c *Checker) Check(ctx context.Context) error {
+	err := c.checkMonitors(ctx)
+	if err != nil {
+		return err
+	}
+	return c.checkProbes(ctx)
+}
+
+// marks monitors that missed their deadline as down
+func (c *Checker) checkMonitors(ctx context.Context) error {
+	transitions, err := c.expireMonitors(ctx)
+	for _, t := range transitions {
+		c.apply(ctx, t)
+	}
+	return err
+}
+
+func (c *Checker) expireMonitors(ctx context.Context) ([]transition, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	monitors, err := c.database.GetMonitors(ctx)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var transitions []transition
+	for _, monitor := range monitors {
+		if monitor.State != util.MonitorDown && now.After(monitor.Deadline()) {
+			_, t := c.setState(ctx, monitor, util.MonitorDown)
+			transitions = append(transitions, t)
+		}
+	}
+	return transitions, nil
+}
+
+// records a ping from the monitor with the given token, which brings it back up if it was down
+func (c *Checker) Ping(ctx context.Context, token string) (util.Monitor, error) {
+	monitor, t, err := c.ping(ctx, token)
+	if err != nil {
+		return monitor, err
+	}
+	if t != nil {
+		c.apply(ctx, *t)
+	}
+	return monitor, nil
+}
+
+func (c *Checker) ping(ctx context.Context, token string) (util.Monitor, *transition, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+	monitor, err := c.database.PingMonitor(ctx, token, now)
+	if err != nil {
+		return monitor, nil, err
+	}
+	monitor.LastPing = now
+	if monitor.State == util.MonitorUp {
+		return monitor, nil, nil
+	}
+	monitor, t := c.setState(ctx, monitor, util.MonitorUp)
+	return monitor, &t, nil
+}
+
+// moves a monitor to a new state, returning the incident change to apply once the mutex is released. caller must hold the mutex
+func (c *Checker) setState(ctx context.Context, monitor util.Monitor, state util.MonitorState) (util.Monitor, transition) {
+	previous := monitor.State
+	monitor.State = state
+	t := transition{
+		subject:    subject{kind: "monitor", id: monitor.ID, name: monitor.Name, component: monitor.Component, impact: monitor.Impact},
+		incidentID: monitor.IncidentID,
+	}
+	if state == util.MonitorDown {
+		c.logger.Warn("monitor is down", slog.Int64("monitor", monitor.ID), slog.String("name", monitor.Name), slog.Time("last_ping", monitor.LastPing))
+		t.down = true
+		t.open = monitor.AutoIncident
+		t.description = fmt.Sprintf("%s hasn't checked in for over %s.", monitor.Name, time.Duration(monitor.Interval+monitor.Grace)*time.Second)
+	} else if previous == util.MonitorDown {
+		c.logger.Info("monitor is back up", slog.Int64("monitor", monitor.ID), slog.String("name", monitor.Name))
+		monitor.IncidentID = ""
+	}
+
+	err := c.database.SaveMonitorState(ctx, monitor)
+	if err != nil {
+		c.logger.Error("error while saving monitor state", slog.Int64("monitor", monitor.ID), slog.Any("error", err))
+	}
+	return monitor, t
+}
+
+// what an automatic incident is opened for, monitors and probes are handled the same way
+type subject struct {
+	kind      string // "monitor" or "probe", for logs
+	id        int64
+	name      string
+	component string
+	impact    util.Impact
+}
+
+// a state change whose incident still has to be opened or resolved. creating incidents waits for the event loop,
+// which can be slow (e.g. while webhooks are sent), so this happens after the mutex is released
+type transition struct {
+	subject     subject
+	down        bool
+	open        bool   // whether to open an incident for going down
+	incidentID  string // the incident to resolve, or the last one opened, which is reused if it's still open
+	description string
+}
+
+func (c *Checker) apply(ctx context.Context, t transition) {
+	if !t.down {
+		c.resolveIncident(ctx, t.subject, t.incidentID)
+		return
+	}
+	if !t.open {
+		return
+	}
+	id := c.openIncident(ctx, t.subject, t.incidentID, t.description)
+	if id == "" || id == t.incidentID {
+		return
+	}
+
+	c.mutex.Lock()
+	stillDown, err := c.saveIncident(ctx, t.subject, id)
+	c.mutex.Unlock()
+	if err != nil {
+		c.logger.Error("error while saving automatic incident", slog.Int64(t.subject.kind, t.subject.id), slog.String("incident", id), slog.Any("error", err))
+		return
+	}
+	if !stillDown {
+		// it came back up while the incident was being opened
+		c.resolveIncident(ctx, t.subject, id)
+	}
+}
+
+// stores the incident opened for something that went down, returning false instead if it's not down anymore. caller must hold the mutex
+func (c *Checker) saveIncident(ctx context.Context, s subject, id string) (bool, error) {
+	if s.kind == "probe" {
+		probe, err := c.database.GetProbe(ctx, s.id)
+		if err != nil {
+			return false, err
+		}
+		if probe.State != util.MonitorDown {
+			return false, nil
+		}
+		probe.IncidentID = id
+		return true, c.database.SaveProbeState(ctx, probe)
+	}
+
+	monitor, err := c.database.GetMonitor(ctx, s.id)
+	if err != nil {
+		return false, err
+	}
+	if monitor.State != util.MonitorDown {
+		return false, nil
+	}
+	monitor.IncidentID = id
+	return true, c.database.SaveMonitorState(ctx, monitor)
+}
+
+// returns true if the incident exists and isn't resolved yet
+func (c *Checker) incidentOpen(ctx context.Context, id string) bool {
+	if id == "" {
+		return false
+	}
+	incident, err := c.database.GetIncident(ctx, id)
+	if err != nil {
+		if !errors.Is(err, util.ErrNotFound) {
+			c.logger.Error("error while getting automatic incident", slog.String("incident", id), slog.Any("error", err))
+		}
+		return false
+	}
+	return incident.Status != util.StatusResolved
+}
+
+// opens an incident for something that went down, returning its ID.
+// if the last one is still open (e.g. it came back up and went down again before anyone resolved it), that's kept instead
+func (c *Checker) openIncident(ctx context.Context, s subject, existing string, description string) string {
+	if c.incidentOpen(ctx, existing) {
+		return existing
+	}
+
+	incident := util.Incident{
+		Name:        s.name + " is down",
+		Description: description,
+		Status:      util.StatusInvestigating,
+		Impact:      s.impact,
+	}
+	if s.component != "" {
+		incident.Components = []string{s.component}
+	}
+	id, err := c.database.CreateIncident(ctx, incident)
+	if err != nil {
+		c.logger.Error("error while creating automatic incident", slog.Int64(s.kind, s.id), slog.Any("error", err))
+		return ""
+	}
+	c.logger.Info("opened automatic incident", slog.Int64(s.kind, s.id), slog.String("incident", id))
+	return id
+}
+
+// resolves the incident opened for something that's back up, unless someone already did
+func (c *Checker) resolveIncident(ctx context.Context, s subject, id string) {
+	if !c.incidentOpen(ctx, id) {
+		return
+	}
+
+	resolved := util.StatusResolved
+	_, err := c.database.CreateUpdate(ctx, util.IncidentUpdate{
+		IncidentID: id,
+		Text:       s.name + " is back up.",
+		Status:     &resolved,
+	})
+	if err != nil {
+		c.logger.Error("error while resolving automatic incident", slog.Int64(s.kind, s.id), slog.String("incident", id), slog.Any("error", err))
+		return
+	}
+	c.logger.Info("resolved automatic incident", slog.Int64(s.kind, s.id), slog.String("incident", id))
+}
@@ -0,0 +1,244 @@
+package monitors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"pluralkit/status/util"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// how much of an http response is searched for the expected text
+const maxProbeBody = 1 << 20
+
+// runs all probes that are due, waiting for them to finish. probes still running from an earlier call are skipped
+func (c *Checker) checkProbes(ctx context.Context) error {
+	probes, err := c.database.GetProbes(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var wg sync.WaitGroup
+	for _, probe := range probes {
+		if now.Before(probe.LastCheck.Add(time.Duration(probe.Interval) * time.Second)) {
+			continue
+		}
+		if !c.startProbe(probe.ID) {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer c.finishProbe(probe.ID)
+			_, err := c.check(ctx, probe)
+			if err != nil && !errors.Is(err, util.ErrNotFound) {
+				c.logger.Error("error while checking probe", slog.Int64("probe", probe.ID), slog.Any("error", err))
+			}
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+// marks a probe as running, returning false if it already was
+func (c *Checker) startProbe(id int64) bool {
+	c.runningMutex.Lock()
+	defer c.runningMutex.Unlock()
+	if c.running[id] {
+		return false
+	}
+	c.running[id] = true
+	return true
+}
+
+func (c *Checker) finishProbe(id int64) {
+	c.runningMutex.Lock()
+	defer c.runningMutex.Unlock()
+	delete(c.running, id)
+}
+
+// checks the probe with the given ID right away, whether or not it's due
+func (c *Checker) CheckProbe(ctx context.Context, id int64) (util.ProbeResult, error) {
+	probe, err := c.database.GetProbe(ctx, id)
+	if err != nil {
+		return util.ProbeResult{}, err
+	}
+	return c.check(ctx, probe)
+}
+
+// runs one check of a probe, stores the result and updates its state
+func (c *Checker) check(ctx context.Context, probe util.Probe) (util.ProbeResult, error) {
+	// the network part runs without the mutex, so slow probes don't hold up pings
+	result := c.runProbe(ctx, probe)
+
+	t, err := c.recordResult(ctx, result)
+	if err != nil {
+		return result, err
+	}
+	if t != nil {
+		c.apply(ctx, *t)
+	}
+	return result, nil
+}
+
+// stores a probe's result and moves it to a new state if it changed, returning the incident change to apply
+func (c *Checker) recordResult(ctx context.Context, result util.ProbeResult) (*transition, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	// re-read from the database, the probe might have been edited while it ran
+	updated, err := c.database.RecordProbeResult(ctx, result)
+	if err != nil {
+		return nil, err
+	}
+
+	state := updated.State
+	if updated.ConsecutiveFailures == 0 {
+		state = util.MonitorUp
+	} else if updated.ConsecutiveFailures >= updated.FailureThreshold {
+		state = util.MonitorDown
+	}
+	if state == updated.State {
+		return nil, nil
+	}
+	_, t := c.setProbeState(ctx, updated, state)
+	return &t, nil
+}
+
+// moves a probe to a new state, returning the incident change to apply once the mutex is released. caller must hold the mutex
+func (c *Checker) setProbeState(ctx context.Context, probe util.Probe, state util.MonitorState) (util.Probe, transition) {
+	previous := probe.State
+	probe.State = state
+	t := transition{
+		subject:    subject{kind: "probe", id: probe.ID, name: probe.Name, component: probe.Component, impact: probe.Impact},
+		incidentID: probe.IncidentID,
+	}
+	if state == util.MonitorDown {
+		c.logger.Warn("probe is down", slog.Int64("probe", probe.ID), slog.String("name", probe.Name), slog.String("error", probe.LastError))
+		t.down = true
+		t.open = probe.AutoIncident
+		t.description = fmt.Sprintf("%s failed %d checks in a row: %s", probe.Name, probe.ConsecutiveFailures, probe.LastError)
+	} else if previous == util.MonitorDown {
+		c.logger.Info("probe is back up", slog.Int64("probe", probe.ID), slog.String("name", probe.Name))
+		probe.IncidentID = ""
+	}
+
+	err := c.database.SaveProbeState(ctx, probe)
+	if err != nil {
+		c.logger.Error("error while saving probe state", slog.Int64("probe", probe.ID), slog.Any("error", err))
+	}
+	return probe, t
+}
+
+// runs a probe's check, retrying failed attempts up to its retry count
+func (c *Checker) runProbe(ctx context.Context, probe util.Probe) util.ProbeResult {
+	timeout := time.Duration(probe.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	maxLatency := time.Duration(probe.MaxLatency) * time.Millisecond
+
+	result := util.ProbeResult{ProbeID: probe.ID}
+	for attempt := 0; attempt <= probe.Retries; attempt++ {
+		result.Attempts++
+		start := time.Now()
+		statusCode, err := c.attempt(ctx, probe, timeout)
+		latency := time.Since(start)
+		result.Latency = latency.Milliseconds()
+		result.StatusCode = statusCode
+		if err == nil && maxLatency > 0 && latency > maxLatency {
+			err = fmt.Errorf("took %dms, over the limit of %dms", latency.Milliseconds(), probe.MaxLatency)
+		}
+		if err == nil {
+			result.Success = true
+			result.Error = ""
+			break
+		}
+		result.Error = err.Error()
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	result.Timestamp = time.Now()
+	return result
+}
+
+// a single attempt, returning the http status code if there was one
+func (c *Checker) attempt(ctx context.Context, probe util.Probe, timeout time.Duration) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	switch probe.Type {
+	case util.ProbeHTTP:
+		return c.probeHTTP(ctx, probe)
+	case util.ProbeTCP:
+		return 0, probeTCP(ctx, probe)
+	case util.ProbeDNS:
+		return 0, probeDNS(ctx, probe)
+	}
+	return 0, fmt.Errorf("unknown probe type %q", probe.Type)
+}
+
+func (c *Checker) probeHTTP(ctx context.Context, probe util.Probe) (int, error) {
+	method := probe.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	expectedStatus := probe.ExpectedStatus
+	if expectedStatus == 0 {
+		expectedStatus = http.StatusOK
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, probe.Target, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", "PluralKit-Status-Probe")
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != expectedStatus {
+		return resp.StatusCode, fmt.Errorf("expected status %d, got %d", expectedStatus, resp.StatusCode)
+	}
+	if probe.Expect != "" {
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxProbeBody))
+		if err != nil {
+			return resp.StatusCode, err
+		}
+		if !strings.Contains(string(body), probe.Expect) {
+			return resp.StatusCode, errors.New("response doesn't contain the expected text")
+		}
+	}
+	return resp.StatusCode, nil
+}
+
+func probeTCP(ctx context.Context, probe util.Probe) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", probe.Target)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func probeDNS(ctx context.Context, probe util.Probe) error {
+	addresses, err := net.DefaultResolver.LookupHost(ctx, probe.Target)
+	if err != nil {
+		return err
+	}
+	if probe.Expect != "" && !slices.Contains(addresses, probe.Expect) {
+		return fmt.Errorf("resolved to %s, expected %s", strings.Join(addresses, ", "), probe.Expect)
+	}
+	return nil
+}
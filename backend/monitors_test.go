@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"pluralkit/status/api"
+	"pluralkit/status/db"
+	"pluralkit/status/monitors"
+	"pluralkit/status/util"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMonitors(t *testing.T) {
+	database, _ := setupTestFileDB(t)
+	ctx := context.Background()
+
+	cfg := util.Config{AuthToken: testAuthToken, PublicURL: "https://status.example.com/"}
+	apiInstance := api.NewAPI(cfg, slog.Default(), database)
+	router := chi.NewRouter()
+	router.Use(render.SetContentType(render.ContentTypeJSON))
+	apiInstance.SetupRoutes(router)
+
+	request := func(method string, path string, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+testAuthToken)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	monitors := func() map[string]util.Monitor {
+		var list []util.Monitor
+		require.Equal(t, http.StatusOK, getJSON(t, router, "/api/v1/monitors", &list))
+		byName := make(map[string]util.Monitor)
+		for _, monitor := range list {
+			assert.Empty(t, monitor.Token)
+			byName[monitor.Name] = monitor
+		}
+		return byName
+	}
+	activeIncidents := func() []util.Incident {
+		list, err := database.GetActiveIncidents(ctx)
+		require.NoError(t, err)
+		incidents := make([]util.Incident, 0)
+		for _, incident := range list.Incidents {
+			incidents = append(incidents, incident)
+		}
+		return incidents
+	}
+
+	t.Run("invalid monitors", func(t *testing.T) {
+		for _, body := range []string{
+			`{"interval": 60}`,
+			`{"name": "dashboard"}`,
+			`{"name": "dashboard", "interval": 60, "grace": -1}`,
+			`{"name": "dashboard", "interval": 60, "impact": "huge"}`,
+			`{"name": "` + strings.Repeat("a", 91) + `", "interval": 60}`,
+		} {
+			assert.Equal(t, http.StatusBadRequest, request(http.MethodPost, "/api/v1/admin/monitors", body).Code, body)
+		}
+	})
+
+	rr := request(http.MethodPost, "/api/v1/admin/monitors", `{"name": "Dashboard", "component": "dashboard", "interval": 1, "auto_incident": true}`)
+	require.Equal(t, http.StatusCreated, rr.Code)
+	var dashboard util.Monitor
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &dashboard))
+	require.NotEmpty(t, dashboard.Token)
+	assert.Equal(t, "https://status.example.com/api/v1/ping/"+dashboard.Token, dashboard.PingURL)
+	assert.Equal(t, util.MonitorPending, dashboard.State)
+	assert.Equal(t, util.ImpactMinor, dashboard.Impact)
+
+	// never pinged and without incidents, so it only goes down quietly
+	rr = request(http.MethodPost, "/api/v1/admin/monitors", `{"name": "Backups", "interval": 1}`)
+	require.Equal(t, http.StatusCreated, rr.Code)
+
+	assert.Equal(t, util.MonitorPending, monitors()["Dashboard"].State)
+
+	ping := func(method string, token string) int {
+		req, _ := http.NewRequest(method, "/api/v1/ping/"+token, nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	assert.Equal(t, http.StatusNotFound, ping(http.MethodGet, "not-a-token"))
+	require.Equal(t, http.StatusOK, ping(http.MethodGet, dashboard.Token))
+	assert.Equal(t, util.MonitorUp, monitors()["Dashboard"].State)
+	assert.False(t, monitors()["Dashboard"].LastPing.IsZero())
+
+	require.NoError(t, apiInstance.Monitors.Check(ctx))
+	assert.Equal(t, util.MonitorUp, monitors()["Dashboard"].State)
+
+	// missing the deadline opens one incident on the linked component
+	time.Sleep(1100 * time.Millisecond)
+	require.NoError(t, apiInstance.Monitors.Check(ctx))
+	require.NoError(t, apiInstance.Monitors.Check(ctx))
+	current := monitors()
+	assert.Equal(t, util.MonitorDown, current["Dashboard"].State)
+	assert.Equal(t, util.MonitorDown, current["Backups"].State)
+	assert.Empty(t, current["Backups"].IncidentID)
+
+	incidents := activeIncidents()
+	require.Len(t, incidents, 1)
+	assert.Equal(t, "Dashboard is down", incidents[0].Name)
+	assert.Equal(t, []string{"dashboard"}, incidents[0].Components)
+	assert.Equal(t, util.ImpactMinor, incidents[0].Impact)
+	assert.Equal(t, incidents[0].ID, current["Dashboard"].IncidentID)
+
+	// pings bring it back up and resolve the incident
+	require.Equal(t, http.StatusOK, ping(http.MethodPost, dashboard.Token))
+	assert.Equal(t, util.MonitorUp, monitors()["Dashboard"].State)
+	assert.Empty(t, monitors()["Dashboard"].IncidentID)
+	assert.Empty(t, activeIncidents())
+	incident, err := database.GetIncident(ctx, incidents[0].ID)
+	require.NoError(t, err)
+	require.Len(t, incident.Updates, 1)
+	assert.Equal(t, "Dashboard is back up.", incident.Updates[0].Text)
+
+	t.Run("editing and deleting", func(t *testing.T) {
+		path := fmt.Sprintf("/api/v1/admin/monitors/%d", dashboard.ID)
+		rr := request(http.MethodPatch, path, `{"interval": 300, "grace": 60, "impact": "major"}`)
+		require.Equal(t, http.StatusOK, rr.Code)
+		var edited util.Monitor
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &edited))
+		assert.Equal(t, 300, edited.Interval)
+		assert.Equal(t, 60, edited.Grace)
+		assert.Equal(t, util.ImpactMajor, edited.Impact)
+		assert.Equal(t, "Dashboard", edited.Name)
+		assert.Empty(t, edited.Token)
+
+		assert.Equal(t, http.StatusBadRequest, request(http.MethodPatch, path, `{"name": ""}`).Code)
+		assert.Equal(t, http.StatusBadRequest, request(http.MethodPatch, path, `{"interval": 0}`).Code)
+		assert.Equal(t, http.StatusNotFound, request(http.MethodPatch, "/api/v1/admin/monitors/999", `{}`).Code)
+
+		assert.Equal(t, http.StatusOK, request(http.MethodDelete, path, "").Code)
+		assert.Equal(t, http.StatusNotFound, request(http.MethodDelete, path, "").Code)
+		assert.Equal(t, http.StatusNotFound, ping(http.MethodGet, dashboard.Token))
+	})
+}
+
+// opening an automatic incident waits for the event loop, which shouldn't hold up pings in the meantime
+func TestMonitorIncidentsDontBlockPings(t *testing.T) {
+	cfg := util.Config{DBLoc: fmt.Sprintf("file:%s?_foreign_keys=on", filepath.Join(t.TempDir(), "status.db"))}
+	events := make(chan util.Event)
+	database := db.NewDB(cfg, slog.Default(), events)
+	require.NotNil(t, database)
+	t.Cleanup(func() {
+		assert.NoError(t, database.CloseDB())
+	})
+	ctx := context.Background()
+	checker := monitors.NewChecker(cfg, slog.Default(), database)
+
+	_, err := database.CreateMonitor(ctx, util.Monitor{Name: "Dashboard", Interval: 1, AutoIncident: true})
+	require.NoError(t, err)
+	backups, err := database.CreateMonitor(ctx, util.Monitor{Name: "Backups", Interval: 60})
+	require.NoError(t, err)
+	time.Sleep(1100 * time.Millisecond)
+
+	checked := make(chan error, 1)
+	go func() {
+		checked <- checker.Check(ctx)
+	}()
+	// the incident is stored before its event is sent, so once it's there the check is stuck on the event channel
+	require.Eventually(t, func() bool {
+		list, err := database.GetActiveIncidents(ctx)
+		return err == nil && len(list.Incidents) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	pinged := make(chan error, 1)
+	go func() {
+		_, err := checker.Ping(ctx, backups.Token)
+		pinged <- err
+	}()
+	select {
+	case err := <-pinged:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("ping waited for the incident's event")
+	}
+
+	event := <-events
+	assert.Equal(t, util.EventCreateIncident, event.Type)
+	require.NoError(t, <-checked)
+	dashboard, err := database.GetMonitor(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, util.MonitorDown, dashboard.State)
+	assert.NotEmpty(t, dashboard.IncidentID)
+}
@@ -0,0 +1,649 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"pluralkit/status/db"
+	"pluralkit/status/util"
+	"pluralkit/status/webhook"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/caarlos0/env/v11"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// records requests made to a stand-in notification API
+type recordedRequest struct {
+	Method string
+	Path   string
+	Query  url.Values
+	Header http.Header
+	Body   map[string]any
+}
+
+type requestRecorder struct {
+	mutex    sync.Mutex
+	requests []recordedRequest
+}
+
+func (rr *requestRecorder) record(r *http.Request) recordedRequest {
+	req := recordedRequest{Method: r.Method, Path: r.URL.Path, Query: r.URL.Query(), Header: r.Header}
+	_ = json.NewDecoder(r.Body).Decode(&req.Body)
+	rr.mutex.Lock()
+	rr.requests = append(rr.requests, req)
+	rr.mutex.Unlock()
+	return req
+}
+
+func (rr *requestRecorder) reset() {
+	rr.mutex.Lock()
+	rr.requests = nil
+	rr.mutex.Unlock()
+}
+
+func (rr *requestRecorder) all() []recordedRequest {
+	rr.mutex.Lock()
+	defer rr.mutex.Unlock()
+	return append([]recordedRequest(nil), rr.requests...)
+}
+
+// creates an incident with an update, returned the same way they'd be sent in events
+func createTestIncident(t *testing.T, dbInstance *db.DB, impact util.Impact) (util.Incident, util.IncidentUpdate) {
+	ctx := context.Background()
+	incidentID, err := dbInstance.CreateIncident(ctx, util.Incident{Name: "bot <down>", Description: "it's down & out", Status: util.StatusInvestigating, Impact: impact})
+	require.NoError(t, err)
+	status := util.StatusIdentified
+	updateID, err := dbInstance.CreateUpdate(ctx, util.IncidentUpdate{IncidentID: incidentID, Text: "found it", Status: &status})
+	require.NoError(t, err)
+
+	incident, err := dbInstance.GetIncident(ctx, incidentID)
+	require.NoError(t, err)
+	update, err := dbInstance.GetUpdate(ctx, updateID)
+	require.NoError(t, err)
+	incident.Updates = nil
+	return incident, update
+}
+
+func TestSlackNotifier(t *testing.T) {
+	_, dbInstance, teardown := setupTestAPI(t)
+	defer teardown()
+	ctx := context.Background()
+
+	recorder := &requestRecorder{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := recorder.record(r)
+		switch req.Path {
+		case "/api/chat.postMessage":
+			fmt.Fprintf(w, `{"ok": true, "channel": "C1234", "ts": "1700000000.%06d"}`, len(recorder.all()))
+		case "/api/chat.update":
+			fmt.Fprint(w, `{"ok": true}`)
+		case "/webhook":
+			fmt.Fprint(w, "ok")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	incident, update := createTestIncident(t, dbInstance, util.ImpactMajor)
+
+	t.Run("web api", func(t *testing.T) {
+		notifier := webhook.NewSlackNotifier(util.Config{
+			SlackToken:   "xoxb-token",
+			SlackChannel: "#status",
+			SlackAPIURL:  server.URL + "/api",
+		})
+		dispatcher := webhook.NewDispatcher(slog.Default(), dbInstance, notifier)
+
+		dispatcher.Handle(ctx, util.Event{Type: util.EventCreateIncident, Modified: incident})
+		dispatcher.Handle(ctx, util.Event{Type: util.EventCreateUpdate, Modified: update})
+		requests := recorder.all()
+		require.Len(t, requests, 2)
+		assert.Equal(t, "Bearer xoxb-token", requests[0].Header.Get("Authorization"))
+		assert.Equal(t, "#status", requests[0].Body["channel"])
+		assert.Equal(t, "new incident: bot &lt;down&gt;", requests[0].Body["text"])
+		attachment := requests[0].Body["attachments"].([]any)[0].(map[string]any)
+		assert.Equal(t, "#ff637d", attachment["color"])
+
+		msg, err := dbInstance.GetMessage(ctx, incident.ID, "incident", "slack")
+		require.NoError(t, err)
+		assert.Equal(t, "1700000000.000001", msg.Ref)
+		assert.Equal(t, "C1234", msg.ChannelID)
+
+		incident.Name = "edited"
+		dispatcher.Handle(ctx, util.Event{Type: util.EventEditIncident, Modified: incident})
+		dispatcher.Handle(ctx, util.Event{Type: util.EventEditUpdate, Modified: update})
+		requests = recorder.all()
+		require.Len(t, requests, 4)
+		assert.Equal(t, "/api/chat.update", requests[2].Path)
+		assert.Equal(t, "C1234", requests[2].Body["channel"])
+		assert.Equal(t, "1700000000.000001", requests[2].Body["ts"])
+		assert.Equal(t, "1700000000.000002", requests[3].Body["ts"])
+	})
+
+	t.Run("incoming webhook", func(t *testing.T) {
+		recorder.reset()
+		notifier := webhook.NewSlackNotifier(util.Config{SlackWebhook: server.URL + "/webhook"})
+		msg, err := notifier.SendIncident(incident)
+		require.NoError(t, err)
+		assert.Empty(t, msg.Ref)
+
+		err = notifier.EditIncident(msg, incident)
+		require.NoError(t, err)
+		assert.Len(t, recorder.all(), 1)
+	})
+}
+
+func TestWebhookMessagesMigration(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "status.db")
+	dbLoc := fmt.Sprintf("file:%s?_foreign_keys=on", path)
+
+	raw, err := sql.Open("sqlite3", dbLoc)
+	require.NoError(t, err)
+	_, err = raw.Exec(`CREATE TABLE "webhook_messages" ("id" VARCHAR NOT NULL, "type" VARCHAR, "message_id" BIGINT, PRIMARY KEY ("id"))`)
+	require.NoError(t, err)
+	_, err = raw.Exec(`INSERT INTO "webhook_messages" VALUES ('86Rf07xd', 'incident', 1234)`)
+	require.NoError(t, err)
+	require.NoError(t, raw.Close())
+
+	database := db.NewDB(util.Config{DBLoc: dbLoc, LogLevel: util.SlogLevel(slog.LevelError)}, slog.Default(), make(chan util.Event, 1))
+	require.NotNil(t, database)
+	defer database.CloseDB() //nolint:all
+
+	ctx := context.Background()
+	msg, err := database.GetMessage(ctx, "86Rf07xd", "incident", "discord")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1234), msg.MessageID)
+
+	err = database.SaveMessageID(ctx, util.WebhookMessage{ID: "86Rf07xd", Type: "incident", Platform: "slack", Ref: "1.2"})
+	assert.NoError(t, err)
+}
+
+func TestMatrixNotifier(t *testing.T) {
+	_, dbInstance, teardown := setupTestAPI(t)
+	defer teardown()
+	ctx := context.Background()
+
+	recorder := &requestRecorder{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder.record(r)
+		if r.Header.Get("Authorization") != "Bearer matrix-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"errcode": "M_UNKNOWN_TOKEN", "error": "bad token"}`)
+			return
+		}
+		fmt.Fprintf(w, `{"event_id": "$event%d"}`, len(recorder.all()))
+	}))
+	defer server.Close()
+
+	notifier := webhook.NewMatrixNotifier(util.Config{
+		MatrixHomeserver: server.URL,
+		MatrixToken:      "matrix-token",
+		MatrixRoom:       "!room:example.com",
+	})
+	dispatcher := webhook.NewDispatcher(slog.Default(), dbInstance, notifier)
+	incident, update := createTestIncident(t, dbInstance, util.ImpactMinor)
+
+	dispatcher.Handle(ctx, util.Event{Type: util.EventCreateIncident, Modified: incident})
+	dispatcher.Handle(ctx, util.Event{Type: util.EventCreateUpdate, Modified: update})
+	requests := recorder.all()
+	require.Len(t, requests, 2)
+	assert.Equal(t, http.MethodPut, requests[0].Method)
+	assert.Contains(t, requests[0].Path, "/_matrix/client/v3/rooms/!room:example.com/send/m.room.message/")
+	assert.Equal(t, "org.matrix.custom.html", requests[0].Body["format"])
+	assert.Contains(t, requests[0].Body["formatted_body"], "bot &lt;down&gt;")
+	assert.Contains(t, requests[0].Body["formatted_body"], `data-mx-color="#fcb700"`)
+
+	relation := requests[1].Body["m.relates_to"].(map[string]any)
+	assert.Equal(t, "m.thread", relation["rel_type"])
+	assert.Equal(t, "$event1", relation["event_id"])
+
+	msg, err := dbInstance.GetMessage(ctx, update.ID, "update", "matrix")
+	require.NoError(t, err)
+	assert.Equal(t, "$event2", msg.Ref)
+	assert.Equal(t, "!room:example.com", msg.ChannelID)
+
+	update.Text = "edited"
+	dispatcher.Handle(ctx, util.Event{Type: util.EventEditUpdate, Modified: update})
+	requests = recorder.all()
+	require.Len(t, requests, 3)
+	relation = requests[2].Body["m.relates_to"].(map[string]any)
+	assert.Equal(t, "m.replace", relation["rel_type"])
+	assert.Equal(t, "$event2", relation["event_id"])
+	newContent := requests[2].Body["m.new_content"].(map[string]any)
+	assert.Contains(t, newContent["body"], "edited")
+	assert.NotContains(t, newContent, "m.relates_to")
+}
+
+func TestTelegramNotifier(t *testing.T) {
+	_, dbInstance, teardown := setupTestAPI(t)
+	defer teardown()
+	ctx := context.Background()
+
+	recorder := &requestRecorder{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := recorder.record(r)
+		switch req.Path {
+		case "/bot123:abc/sendMessage":
+			fmt.Fprintf(w, `{"ok": true, "result": {"message_id": %d, "chat": {"id": -100987}}}`, len(recorder.all()))
+		case "/bot123:abc/editMessageText":
+			if req.Body["text"] == recorder.all()[0].Body["text"] {
+				fmt.Fprint(w, `{"ok": false, "error_code": 400, "description": "Bad Request: message is not modified"}`)
+				return
+			}
+			fmt.Fprint(w, `{"ok": true, "result": {"message_id": 1, "chat": {"id": -100987}}}`)
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"ok": false, "error_code": 401, "description": "Unauthorized"}`)
+		}
+	}))
+	defer server.Close()
+
+	notifier := webhook.NewTelegramNotifier(util.Config{
+		TelegramToken:  "123:abc",
+		TelegramChat:   "@pluralkitstatus",
+		TelegramAPIURL: server.URL,
+		PublicURL:      "https://status.example.com",
+	})
+	dispatcher := webhook.NewDispatcher(slog.Default(), dbInstance, notifier)
+	incident, update := createTestIncident(t, dbInstance, util.ImpactMajor)
+
+	dispatcher.Handle(ctx, util.Event{Type: util.EventCreateIncident, Modified: incident})
+	dispatcher.Handle(ctx, util.Event{Type: util.EventCreateUpdate, Modified: update})
+	requests := recorder.all()
+	require.Len(t, requests, 2)
+	assert.Equal(t, "@pluralkitstatus", requests[0].Body["chat_id"])
+	assert.Equal(t, "MarkdownV2", requests[0].Body["parse_mode"])
+	assert.Contains(t, requests[0].Body["text"], `*new incident: bot <down\>*`)
+	assert.Contains(t, requests[0].Body["text"], `it's down & out`)
+	assert.Contains(t, requests[0].Body["text"], "(https://status.example.com/i/"+incident.ID+")")
+
+	// updates reply to the incident message
+	assert.Equal(t, "-100987", requests[1].Body["chat_id"])
+	reply := requests[1].Body["reply_parameters"].(map[string]any)
+	assert.Equal(t, float64(1), reply["message_id"])
+
+	msg, err := dbInstance.GetMessage(ctx, incident.ID, "incident", "telegram")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), msg.MessageID)
+	assert.Equal(t, "-100987", msg.ChannelID)
+
+	// unchanged edits aren't errors
+	err = notifier.EditIncident(msg, incident)
+	assert.NoError(t, err)
+
+	incident.Name = "edited (again)!"
+	err = notifier.EditIncident(msg, incident)
+	require.NoError(t, err)
+	requests = recorder.all()
+	require.Len(t, requests, 4)
+	assert.Equal(t, "/bot123:abc/editMessageText", requests[3].Path)
+	assert.Equal(t, float64(1), requests[3].Body["message_id"])
+	assert.Contains(t, requests[3].Body["text"], `edited \(again\)\!`)
+	assert.NotContains(t, requests[3].Body, "reply_parameters")
+
+	_, err = webhook.NewTelegramNotifier(util.Config{TelegramToken: "wrong", TelegramChat: "@x", TelegramAPIURL: server.URL}).SendIncident(incident)
+	require.Error(t, err)
+	assert.NotContains(t, err.Error(), "wrong")
+}
+
+func TestMastodonNotifier(t *testing.T) {
+	_, dbInstance, teardown := setupTestAPI(t)
+	defer teardown()
+	ctx := context.Background()
+
+	recorder := &requestRecorder{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder.record(r)
+		if r.Header.Get("Authorization") != "Bearer masto-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error": "The access token is invalid"}`)
+			return
+		}
+		fmt.Fprintf(w, `{"id": "1000%d"}`, len(recorder.all()))
+	}))
+	defer server.Close()
+
+	notifier := webhook.NewMastodonNotifier(util.Config{
+		MastodonInstance:   server.URL,
+		MastodonToken:      "masto-token",
+		MastodonVisibility: "public",
+		MastodonMaxChars:   500,
+		PublicURL:          "https://status.example.com",
+	})
+	dispatcher := webhook.NewDispatcher(slog.Default(), dbInstance, notifier)
+	incident, update := createTestIncident(t, dbInstance, util.ImpactMajor)
+	link := "https://status.example.com/i/" + incident.ID
+
+	dispatcher.Handle(ctx, util.Event{Type: util.EventCreateIncident, Modified: incident})
+	dispatcher.Handle(ctx, util.Event{Type: util.EventCreateUpdate, Modified: update})
+	requests := recorder.all()
+	require.Len(t, requests, 2)
+	assert.Equal(t, "/api/v1/statuses", requests[0].Path)
+	assert.Equal(t, "incident-"+incident.ID, requests[0].Header.Get("Idempotency-Key"))
+	assert.Equal(t, "public", requests[0].Body["visibility"])
+	assert.Contains(t, requests[0].Body["status"], "new incident: bot <down>")
+	assert.Contains(t, requests[0].Body["status"], link)
+	assert.Equal(t, "10001", requests[1].Body["in_reply_to_id"])
+
+	t.Run("updates without a status change aren't posted", func(t *testing.T) {
+		recorder.reset()
+		update.Status = nil
+		msg, err := notifier.SendUpdate(util.WebhookMessage{Ref: "10001"}, incident, update)
+		require.NoError(t, err)
+		assert.Empty(t, msg.Ref)
+		assert.Empty(t, recorder.all())
+	})
+
+	t.Run("updates repeating the current status aren't posted", func(t *testing.T) {
+		events := make(chan util.Event, 10)
+		database := db.NewDB(util.Config{DBLoc: "file:mastodon_repeat?mode=memory&cache=shared", LogLevel: util.SlogLevel(slog.LevelError)}, slog.Default(), events)
+		require.NotNil(t, database)
+		defer database.CloseDB() //nolint:all
+		dispatcher := webhook.NewDispatcher(slog.Default(), database, notifier)
+
+		id, err := database.CreateIncident(ctx, util.Incident{Name: "api slow", Status: util.StatusInvestigating, Impact: util.ImpactMinor})
+		require.NoError(t, err)
+		investigating := util.StatusInvestigating
+		_, err = database.CreateUpdate(ctx, util.IncidentUpdate{IncidentID: id, Text: "still looking", Status: &investigating})
+		require.NoError(t, err)
+		identified := util.StatusIdentified
+		_, err = database.CreateUpdate(ctx, util.IncidentUpdate{IncidentID: id, Text: "found it", Status: &identified})
+		require.NoError(t, err)
+
+		recorder.reset()
+		require.Len(t, events, 3)
+		for len(events) > 0 {
+			dispatcher.Handle(ctx, <-events)
+		}
+		requests := recorder.all()
+		require.Len(t, requests, 2)
+		assert.Contains(t, requests[0].Body["status"], "new incident: api slow")
+		assert.Contains(t, requests[1].Body["status"], "found it")
+	})
+
+	t.Run("status changes from edits are posted", func(t *testing.T) {
+		events := make(chan util.Event, 10)
+		database := db.NewDB(util.Config{DBLoc: "file:mastodon_edits?mode=memory&cache=shared", LogLevel: util.SlogLevel(slog.LevelError)}, slog.Default(), events)
+		require.NotNil(t, database)
+		defer database.CloseDB() //nolint:all
+		dispatcher := webhook.NewDispatcher(slog.Default(), database, notifier)
+
+		id, err := database.CreateIncident(ctx, util.Incident{Name: "api slow", Status: util.StatusInvestigating, Impact: util.ImpactMinor})
+		require.NoError(t, err)
+		recorder.reset()
+		dispatcher.Handle(ctx, <-events)
+		require.Len(t, recorder.all(), 1)
+
+		// the incident's post is edited, and the new status is posted as a reply to it
+		recorder.reset()
+		resolved := util.StatusResolved
+		require.NoError(t, database.EditIncident(ctx, id, util.IncidentPatch{Status: &resolved}))
+		dispatcher.Handle(ctx, <-events)
+		requests := recorder.all()
+		require.Len(t, requests, 2)
+		assert.Equal(t, http.MethodPut, requests[0].Method)
+		assert.Equal(t, "/api/v1/statuses/10001", requests[0].Path)
+		assert.Equal(t, http.MethodPost, requests[1].Method)
+		assert.Equal(t, "10001", requests[1].Body["in_reply_to_id"])
+		assert.Contains(t, requests[1].Body["status"], "✅ resolved: api slow")
+		assert.Contains(t, requests[1].Body["status"], "changed to resolved")
+
+		// other edits only edit the post
+		recorder.reset()
+		name := "api very slow"
+		require.NoError(t, database.EditIncident(ctx, id, util.IncidentPatch{Name: &name}))
+		dispatcher.Handle(ctx, <-events)
+		requests = recorder.all()
+		require.Len(t, requests, 1)
+		assert.Equal(t, http.MethodPut, requests[0].Method)
+	})
+
+	t.Run("long posts are truncated", func(t *testing.T) {
+		recorder.reset()
+		resolved := util.StatusResolved
+		update.Status = &resolved
+		update.Text = strings.Repeat("ä", 1000)
+		_, err := notifier.SendUpdate(util.WebhookMessage{Ref: "10001"}, incident, update)
+		require.NoError(t, err)
+		requests := recorder.all()
+		require.Len(t, requests, 1)
+		status := requests[0].Body["status"].(string)
+		assert.True(t, strings.HasPrefix(status, "✅ resolved: bot <down>"))
+		assert.True(t, strings.HasSuffix(status, "…\n\n"+link))
+		// the link counts as 23 characters no matter its length
+		assert.Equal(t, 500, len([]rune(strings.TrimSuffix(status, link)))+23)
+	})
+
+	t.Run("edits", func(t *testing.T) {
+		recorder.reset()
+		err := notifier.EditIncident(util.WebhookMessage{Ref: "10001"}, incident)
+		require.NoError(t, err)
+		requests := recorder.all()
+		require.Len(t, requests, 1)
+		assert.Equal(t, http.MethodPut, requests[0].Method)
+		assert.Equal(t, "/api/v1/statuses/10001", requests[0].Path)
+	})
+}
+
+func TestNotificationRules(t *testing.T) {
+	dbInstance, _ := setupTestFileDB(t)
+	ctx := context.Background()
+
+	recorder := &requestRecorder{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder.record(r)
+		fmt.Fprintf(w, `{"id": "%d"}`, len(recorder.all()))
+	}))
+	defer server.Close()
+
+	var cfg util.Config
+	err := env.ParseWithOptions(&cfg, env.Options{Environment: map[string]string{
+		"pluralkit__status__notification_webhook": server.URL + "/main",
+		"pluralkit__status__notification_role":    "1111",
+		"pluralkit__status__discord_targets":      `{"maintenance": {"webhook": "` + server.URL + `/maintenance", "role": "2222"}}`,
+		"pluralkit__status__notification_rules": `[
+			{"targets": ["discord"], "components": ["maintenance"], "drop": true},
+			{"targets": ["discord:maintenance"], "components": ["maintenance"]},
+			{"targets": ["discord:maintenance"], "drop": true},
+			{"impacts": ["none"], "drop": true},
+			{"targets": ["discord"], "events": ["create_update"], "statuses": ["monitoring"], "mention": false},
+			{"targets": ["discord"], "impacts": ["major"]},
+			{"targets": ["discord"], "mention": false}
+		]`,
+	}})
+	require.NoError(t, err)
+	require.Len(t, cfg.NotificationRules, 7)
+
+	client := webhook.NewDiscordClient(cfg)
+	notifiers := []webhook.Notifier{webhook.NewDiscordWebhook(cfg, client)}
+	for name, target := range cfg.DiscordTargets {
+		notifiers = append(notifiers, webhook.NewDiscordTarget(cfg, name, target, client))
+	}
+	dispatcher := webhook.NewDispatcher(slog.Default(), dbInstance, notifiers...)
+	dispatcher.SetRules(cfg.NotificationRules)
+
+	// sends an incident through the dispatcher, returning the webhooks it was posted to and whether the role was pinged
+	send := func(t *testing.T, incident util.Incident) map[string]bool {
+		recorder.reset()
+		id, err := dbInstance.CreateIncident(ctx, incident)
+		require.NoError(t, err)
+		incident, err = dbInstance.GetIncident(ctx, id)
+		require.NoError(t, err)
+		dispatcher.Handle(ctx, util.Event{Type: util.EventCreateIncident, Modified: incident})
+
+		sent := make(map[string]bool)
+		for _, req := range recorder.all() {
+			sent[req.Path] = req.Body["allowed_mentions"] != nil
+		}
+		return sent
+	}
+
+	t.Run("mentions by impact", func(t *testing.T) {
+		sent := send(t, util.Incident{Name: "slow", Status: util.StatusInvestigating, Impact: util.ImpactMinor})
+		assert.Equal(t, map[string]bool{"/main": false}, sent)
+		sent = send(t, util.Incident{Name: "down", Status: util.StatusInvestigating, Impact: util.ImpactMajor})
+		assert.Equal(t, map[string]bool{"/main": true}, sent)
+		assert.Contains(t, recorder.all()[0].Body["components"].([]any)[0].(map[string]any)["content"], "<@&1111>")
+	})
+
+	t.Run("dropped impact", func(t *testing.T) {
+		sent := send(t, util.Incident{Name: "fyi", Status: util.StatusInvestigating, Impact: util.ImpactNone})
+		assert.Empty(t, sent)
+	})
+
+	t.Run("separate target", func(t *testing.T) {
+		sent := send(t, util.Incident{Name: "db upgrade", Status: util.StatusInvestigating, Impact: util.ImpactNone, Components: []string{"maintenance"}})
+		assert.Equal(t, map[string]bool{"/maintenance": true}, sent)
+		assert.Contains(t, recorder.all()[0].Body["components"].([]any)[0].(map[string]any)["content"], "<@&2222>")
+	})
+
+	t.Run("no mentions on monitoring updates", func(t *testing.T) {
+		send(t, util.Incident{Name: "down again", Status: util.StatusInvestigating, Impact: util.ImpactMajor})
+		incidentID := ""
+		list, err := dbInstance.GetActiveIncidents(ctx)
+		require.NoError(t, err)
+		for id, incident := range list.Incidents {
+			if incident.Name == "down again" {
+				incidentID = id
+			}
+		}
+
+		recorder.reset()
+		monitoring := util.StatusMonitoring
+		updateID, err := dbInstance.CreateUpdate(ctx, util.IncidentUpdate{IncidentID: incidentID, Text: "fixed, watching", Status: &monitoring})
+		require.NoError(t, err)
+		update, err := dbInstance.GetUpdate(ctx, updateID)
+		require.NoError(t, err)
+		dispatcher.Handle(ctx, util.Event{Type: util.EventCreateUpdate, Modified: update})
+		requests := recorder.all()
+		require.Len(t, requests, 1)
+		assert.Nil(t, requests[0].Body["allowed_mentions"])
+	})
+
+	t.Run("invalid rules", func(t *testing.T) {
+		var cfg util.Config
+		err := env.ParseWithOptions(&cfg, env.Options{Environment: map[string]string{
+			"pluralkit__status__notification_rules": `[{"impacts": ["catastrophic"], "drop": true}]`,
+		}})
+		assert.Error(t, err)
+		err = env.ParseWithOptions(&cfg, env.Options{Environment: map[string]string{
+			"pluralkit__status__discord_targets": `{"maintenance": {"role": "2222"}}`,
+		}})
+		assert.Error(t, err)
+	})
+}
+
+func TestDiscordThreads(t *testing.T) {
+	dbInstance, _ := setupTestFileDB(t)
+	ctx := context.Background()
+
+	recorder := &requestRecorder{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := recorder.record(r)
+		switch {
+		case req.Path == "/webhook" && req.Query.Get("thread_id") != "":
+			fmt.Fprintf(w, `{"id": "%d", "channel_id": "%s"}`, 1000+len(recorder.all()), req.Query.Get("thread_id"))
+		case req.Path == "/webhook":
+			// forum posts come back in their new thread, normal messages in the webhook's channel
+			channel := "42"
+			if req.Body["thread_name"] != nil {
+				channel = "5000"
+			}
+			fmt.Fprintf(w, `{"id": "%d", "channel_id": "%s"}`, 1000+len(recorder.all()), channel)
+		case req.Path == "/api/channels/42/messages/1001/threads":
+			assert.Equal(t, "Bot bot-token", req.Header.Get("Authorization"))
+			fmt.Fprint(w, `{"id": "1001"}`)
+		default:
+			fmt.Fprint(w, `{}`)
+		}
+	}))
+	defer server.Close()
+
+	// creates an incident and an update, sending both through the dispatcher
+	run := func(t *testing.T, dispatcher *webhook.Dispatcher) (util.Incident, util.IncidentUpdate) {
+		recorder.reset()
+		id, err := dbInstance.CreateIncident(ctx, util.Incident{Name: "bot down", Status: util.StatusInvestigating, Impact: util.ImpactMajor})
+		require.NoError(t, err)
+		incident, err := dbInstance.GetIncident(ctx, id)
+		require.NoError(t, err)
+		dispatcher.Handle(ctx, util.Event{Type: util.EventCreateIncident, Modified: incident})
+
+		updateID, err := dbInstance.CreateUpdate(ctx, util.IncidentUpdate{IncidentID: id, Text: "found it"})
+		require.NoError(t, err)
+		update, err := dbInstance.GetUpdate(ctx, updateID)
+		require.NoError(t, err)
+		dispatcher.Handle(ctx, util.Event{Type: util.EventCreateUpdate, Modified: update})
+		return incident, update
+	}
+
+	t.Run("forum", func(t *testing.T) {
+		notifier := webhook.NewDiscordWebhook(util.Config{
+			NotificationWebhook: server.URL + "/webhook",
+			DiscordThreadMode:   util.DiscordThreadsForum,
+		}, webhook.NewDiscordClient(util.Config{}))
+		dispatcher := webhook.NewDispatcher(slog.Default(), dbInstance, notifier)
+		incident, update := run(t, dispatcher)
+
+		requests := recorder.all()
+		require.Len(t, requests, 3)
+		assert.Equal(t, "bot down", requests[0].Body["thread_name"])
+		assert.Equal(t, "5000", requests[1].Query.Get("thread_id"))
+		assert.Nil(t, requests[1].Body["thread_name"])
+
+		// the incident message is edited to show the update count
+		assert.Equal(t, http.MethodPatch, requests[2].Method)
+		assert.Equal(t, "/webhook/messages/1001", requests[2].Path)
+		assert.Equal(t, "5000", requests[2].Query.Get("thread_id"))
+		content, _ := json.Marshal(requests[2].Body)
+		assert.Contains(t, string(content), "1 update in the thread")
+
+		msg, err := dbInstance.GetMessage(ctx, incident.ID, "incident", "discord")
+		require.NoError(t, err)
+		assert.Equal(t, "5000", msg.ChannelID)
+		msg, err = dbInstance.GetMessage(ctx, update.ID, "update", "discord")
+		require.NoError(t, err)
+		assert.Equal(t, int64(1002), msg.MessageID)
+		assert.Equal(t, "5000", msg.ChannelID)
+
+		recorder.reset()
+		dispatcher.Handle(ctx, util.Event{Type: util.EventEditUpdate, Modified: update})
+		dispatcher.Handle(ctx, util.Event{Type: util.EventEditIncident, Modified: incident})
+		requests = recorder.all()
+		require.Len(t, requests, 2)
+		assert.Equal(t, "/webhook/messages/1002", requests[0].Path)
+		assert.Equal(t, "5000", requests[0].Query.Get("thread_id"))
+		// edit events don't carry updates, but the summary is kept
+		content, _ = json.Marshal(requests[1].Body)
+		assert.Contains(t, string(content), "1 update in the thread")
+	})
+
+	t.Run("message", func(t *testing.T) {
+		notifier := webhook.NewDiscordTarget(util.Config{
+			DiscordBotToken: "bot-token",
+			DiscordAPIURL:   server.URL + "/api",
+		}, "threads", util.DiscordTarget{Webhook: server.URL + "/webhook", ThreadMode: util.DiscordThreadsMessage}, webhook.NewDiscordClient(util.Config{}))
+		dispatcher := webhook.NewDispatcher(slog.Default(), dbInstance, notifier)
+		run(t, dispatcher)
+
+		requests := recorder.all()
+		require.Len(t, requests, 4)
+		assert.Nil(t, requests[0].Body["thread_name"])
+		assert.Equal(t, "/api/channels/42/messages/1001/threads", requests[1].Path)
+		assert.Equal(t, "bot down", requests[1].Body["name"])
+		assert.Equal(t, "1001", requests[2].Query.Get("thread_id"))
+		// the incident message is in the channel, not the thread
+		assert.Equal(t, "/webhook/messages/1001", requests[3].Path)
+		assert.Empty(t, requests[3].Query.Get("thread_id"))
+	})
+}
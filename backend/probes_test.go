@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"pluralkit/status/api"
+	"pluralkit/status/util"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProbes(t *testing.T) {
+	database, _ := setupTestFileDB(t)
+	ctx := context.Background()
+
+	cfg := util.Config{AuthToken: testAuthToken}
+	apiInstance := api.NewAPI(cfg, slog.Default(), database)
+	router := chi.NewRouter()
+	router.Use(render.SetContentType(render.ContentTypeJSON))
+	apiInstance.SetupRoutes(router)
+
+	request := func(method string, path string, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+testAuthToken)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	create := func(body string) util.Probe {
+		rr := request(http.MethodPost, "/api/v1/admin/probes", body)
+		require.Equal(t, http.StatusCreated, rr.Code, body)
+		var probe util.Probe
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &probe))
+		return probe
+	}
+	check := func(id int64) util.ProbeResult {
+		rr := request(http.MethodPost, fmt.Sprintf("/api/v1/admin/probes/%d/check", id), "")
+		require.Equal(t, http.StatusOK, rr.Code)
+		var result util.ProbeResult
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &result))
+		return result
+	}
+	probes := func() map[string]util.Probe {
+		rr := request(http.MethodGet, "/api/v1/admin/probes", "")
+		require.Equal(t, http.StatusOK, rr.Code)
+		var list []util.Probe
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &list))
+		byName := make(map[string]util.Probe)
+		for _, probe := range list {
+			byName[probe.Name] = probe
+		}
+		return byName
+	}
+	activeIncidents := func() []util.Incident {
+		list, err := database.GetActiveIncidents(ctx)
+		require.NoError(t, err)
+		incidents := make([]util.Incident, 0)
+		for _, incident := range list.Incidents {
+			incidents = append(incidents, incident)
+		}
+		return incidents
+	}
+
+	// the server fails while status is set, and fails the next request while failNext is set
+	var status, failNext atomic.Int32
+	var delay atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Duration(delay.Load()))
+		if code := status.Load(); code != 0 {
+			w.WriteHeader(int(code))
+			return
+		}
+		if failNext.CompareAndSwap(1, 0) {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte(`{"status": "ok"}`))
+	}))
+	defer server.Close()
+
+	t.Run("invalid probes", func(t *testing.T) {
+		for _, body := range []string{
+			`{"name": "API", "type": "http", "target": "` + server.URL + `"}`,
+			`{"name": "API", "type": "ftp", "target": "` + server.URL + `", "interval": 60}`,
+			`{"name": "API", "type": "http", "target": "localhost:80", "interval": 60}`,
+			`{"name": "API", "type": "tcp", "target": "` + server.URL + `", "interval": 60}`,
+			`{"name": "API", "type": "dns", "target": "not a hostname", "interval": 60}`,
+			`{"name": "API", "type": "http", "target": "` + server.URL + `", "interval": 60, "retries": 6}`,
+			`{"name": "API", "type": "http", "target": "` + server.URL + `", "interval": 60, "method": "DELETE"}`,
+			`{"type": "http", "target": "` + server.URL + `", "interval": 60}`,
+		} {
+			assert.Equal(t, http.StatusBadRequest, request(http.MethodPost, "/api/v1/admin/probes", body).Code, body)
+		}
+	})
+
+	healthProbe := create(`{"name": "API", "component": "api", "type": "http", "target": "` + server.URL + `/health", "expect": "ok",
+		"interval": 60, "retries": 1, "failure_threshold": 2, "auto_incident": true}`)
+	assert.Equal(t, http.MethodGet, healthProbe.Method)
+	assert.Equal(t, http.StatusOK, healthProbe.ExpectedStatus)
+	assert.Equal(t, 10, healthProbe.Timeout)
+	assert.Equal(t, util.ImpactMinor, healthProbe.Impact)
+	assert.Equal(t, util.MonitorPending, healthProbe.State)
+
+	// only probes that are due are checked
+	require.NoError(t, apiInstance.Monitors.Check(ctx))
+	require.NoError(t, apiInstance.Monitors.Check(ctx))
+	assert.Equal(t, util.MonitorUp, probes()["API"].State)
+	results, err := database.GetProbeResults(ctx, healthProbe.ID)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.True(t, results[0].Success)
+	assert.Equal(t, http.StatusOK, results[0].StatusCode)
+
+	t.Run("retries", func(t *testing.T) {
+		failNext.Store(1)
+		result := check(healthProbe.ID)
+		assert.True(t, result.Success)
+		assert.Equal(t, 2, result.Attempts)
+		assert.Empty(t, result.Error)
+	})
+
+	// one failed check isn't enough to take it down
+	status.Store(http.StatusInternalServerError)
+	result := check(healthProbe.ID)
+	assert.False(t, result.Success)
+	assert.Equal(t, 2, result.Attempts)
+	assert.Equal(t, http.StatusInternalServerError, result.StatusCode)
+	assert.Equal(t, "expected status 200, got 500", result.Error)
+	assert.Equal(t, util.MonitorUp, probes()["API"].State)
+	assert.Empty(t, activeIncidents())
+
+	// the second one is
+	check(healthProbe.ID)
+	current := probes()["API"]
+	assert.Equal(t, util.MonitorDown, current.State)
+	assert.Equal(t, 2, current.ConsecutiveFailures)
+	assert.Equal(t, "expected status 200, got 500", current.LastError)
+	incidents := activeIncidents()
+	require.Len(t, incidents, 1)
+	assert.Equal(t, "API is down", incidents[0].Name)
+	assert.Equal(t, "API failed 2 checks in a row: expected status 200, got 500", incidents[0].Description)
+	assert.Equal(t, []string{"api"}, incidents[0].Components)
+	assert.Equal(t, incidents[0].ID, current.IncidentID)
+
+	// further failures keep the same incident
+	check(healthProbe.ID)
+	assert.Len(t, activeIncidents(), 1)
+
+	t.Run("components", func(t *testing.T) {
+		rr := request(http.MethodPost, "/api/v1/admin/monitors", `{"name": "Dashboard", "component": "api", "interval": 60}`)
+		require.Equal(t, http.StatusCreated, rr.Code)
+		create(`{"name": "Unlinked", "type": "tcp", "target": "localhost:1", "interval": 60}`)
+
+		var components []util.ComponentChecks
+		require.Equal(t, http.StatusOK, getJSON(t, router, "/api/v1/components", &components))
+
+		// targets and errors are only shown to admins
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/components", nil))
+		assert.NotContains(t, rr.Body.String(), server.URL)
+		assert.NotContains(t, rr.Body.String(), "expected status")
+		assert.Equal(t, http.StatusNotFound, getJSON(t, router, "/api/v1/probes", nil))
+		assert.Equal(t, http.StatusUnauthorized, getJSON(t, router, "/api/v1/admin/probes", nil))
+		assert.Equal(t, http.StatusUnauthorized, getJSON(t, router, fmt.Sprintf("/api/v1/admin/probes/%d/results", healthProbe.ID), nil))
+		require.Len(t, components, 1)
+		assert.Equal(t, "api", components[0].Component)
+		assert.Equal(t, util.MonitorDown, components[0].State)
+		require.Len(t, components[0].Monitors, 1)
+		assert.Empty(t, components[0].Monitors[0].Token)
+		require.Len(t, components[0].Probes, 1)
+		assert.Equal(t, "API", components[0].Probes[0].Name)
+	})
+
+	// coming back up resolves the incident
+	status.Store(0)
+	check(healthProbe.ID)
+	current = probes()["API"]
+	assert.Equal(t, util.MonitorUp, current.State)
+	assert.Empty(t, current.IncidentID)
+	assert.Empty(t, activeIncidents())
+	incident, err := database.GetIncident(ctx, incidents[0].ID)
+	require.NoError(t, err)
+	require.Len(t, incident.Updates, 1)
+	assert.Equal(t, "API is back up.", incident.Updates[0].Text)
+
+	t.Run("results history", func(t *testing.T) {
+		rr := request(http.MethodGet, fmt.Sprintf("/api/v1/admin/probes/%d/results", healthProbe.ID), "")
+		require.Equal(t, http.StatusOK, rr.Code)
+		var results []util.ProbeResult
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &results))
+		require.Len(t, results, 6)
+		assert.True(t, results[0].Success)
+		assert.False(t, results[1].Success)
+		assert.True(t, results[0].Timestamp.After(results[1].Timestamp))
+		assert.Equal(t, http.StatusNotFound, request(http.MethodGet, "/api/v1/admin/probes/999/results", "").Code)
+	})
+
+	t.Run("body and latency", func(t *testing.T) {
+		path := fmt.Sprintf("/api/v1/admin/probes/%d", healthProbe.ID)
+		require.Equal(t, http.StatusOK, request(http.MethodPatch, path, `{"expect": "healthy", "retries": 0}`).Code)
+		result := check(healthProbe.ID)
+		assert.False(t, result.Success)
+		assert.Equal(t, "response doesn't contain the expected text", result.Error)
+
+		require.Equal(t, http.StatusOK, request(http.MethodPatch, path, `{"expect": "", "max_latency": 1}`).Code)
+		delay.Store(int64(20 * time.Millisecond))
+		result = check(healthProbe.ID)
+		delay.Store(0)
+		assert.False(t, result.Success)
+		assert.Contains(t, result.Error, "over the limit of 1ms")
+	})
+
+	t.Run("tcp", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		probe := create(`{"name": "Gateway", "type": "tcp", "target": "` + listener.Addr().String() + `", "interval": 60, "timeout": 1, "failure_threshold": 1}`)
+		assert.True(t, check(probe.ID).Success)
+
+		listener.Close()
+		result := check(probe.ID)
+		assert.False(t, result.Success)
+		assert.NotEmpty(t, result.Error)
+		assert.Equal(t, util.MonitorDown, probes()["Gateway"].State)
+	})
+
+	t.Run("dns", func(t *testing.T) {
+		probe := create(`{"name": "DNS", "type": "dns", "target": "localhost", "expect": "127.0.0.1", "interval": 60, "timeout": 1}`)
+		assert.True(t, check(probe.ID).Success)
+
+		path := fmt.Sprintf("/api/v1/admin/probes/%d", probe.ID)
+		require.Equal(t, http.StatusOK, request(http.MethodPatch, path, `{"expect": "10.0.0.1"}`).Code)
+		result := check(probe.ID)
+		assert.False(t, result.Success)
+		assert.Contains(t, result.Error, "expected 10.0.0.1")
+	})
+
+	t.Run("editing and deleting", func(t *testing.T) {
+		path := fmt.Sprintf("/api/v1/admin/probes/%d", healthProbe.ID)
+		assert.Equal(t, http.StatusBadRequest, request(http.MethodPatch, path, `{"target": "localhost:80"}`).Code)
+		assert.Equal(t, http.StatusBadRequest, request(http.MethodPatch, path, `{"failure_threshold": 0}`).Code)
+		assert.Equal(t, http.StatusBadRequest, request(http.MethodPatch, path, `{"timeout": 61}`).Code)
+		assert.Equal(t, http.StatusNotFound, request(http.MethodPatch, "/api/v1/admin/probes/999", `{}`).Code)
+		assert.Equal(t, http.StatusNotFound, request(http.MethodPost, "/api/v1/admin/probes/999/check", "").Code)
+
+		assert.Equal(t, http.StatusOK, request(http.MethodDelete, path, "").Code)
+		assert.Equal(t, http.StatusNotFound, request(http.MethodDelete, path, "").Code)
+		results, err := database.GetProbeResults(ctx, healthProbe.ID)
+		require.NoError(t, err)
+		assert.Empty(t, results)
+	})
+}
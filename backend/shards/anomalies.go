@@ -0,0 +1,256 @@
+package shards
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"pluralkit/status/util"
+	"slices"
+	"time"
+)
+
+// the name of incidents opened for reconnect storms
+const stormIncidentName = "Shards reconnecting"
+
+// a shard that reconnected or went up and down too often within the window
+type ShardAnomaly struct {
+	Source        string  `json:"source"`
+	ShardID       int     `json:"shard_id"`
+	ClusterID     int     `json:"cluster_id"`
+	Reconnects    int     `json:"reconnects"`
+	ReconnectRate float64 `json:"reconnect_rate"` // per hour over the window
+	StateChanges  int     `json:"state_changes"`
+}
+
+// a cluster where enough shards are flapping
+type ClusterAnomaly struct {
+	ID             int    `json:"id"`
+	Source         string `json:"source"`
+	FlappingShards int    `json:"flapping_shards"`
+	NumShards      int    `json:"num_shards"`
+}
+
+type Anomalies struct {
+	Timestamp          time.Time        `json:"timestamp"`
+	Window             string           `json:"window"`
+	Storm              bool             `json:"storm"`
+	ReconnectingShards int              `json:"reconnecting_shards"` // shards that reconnected at least once within the window
+	TotalShards        int              `json:"total_shards"`        // shards whose state is known
+	Shards             []ShardAnomaly   `json:"shards"`
+	Clusters           []ClusterAnomaly `json:"clusters"`
+}
+
+// fills in defaults for thresholds that aren't set, e.g. in configs that weren't loaded from the environment
+func anomalyDefaults(t util.AnomalyThresholds) util.AnomalyThresholds {
+	if t.Window <= 0 {
+		t.Window = 10 * time.Minute
+	}
+	if t.FlapReconnects <= 0 {
+		t.FlapReconnects = 3
+	}
+	if t.FlapStateChanges <= 0 {
+		t.FlapStateChanges = 4
+	}
+	if t.ClusterFlapRatio <= 0 || t.ClusterFlapRatio > 1 {
+		t.ClusterFlapRatio = 0.25
+	}
+	if t.StormRatio <= 0 || t.StormRatio > 1 {
+		t.StormRatio = 0.2
+	}
+	return t
+}
+
+// how many times a shard reconnected and went up or down since the given time. reconnects are counted from its
+// disconnection count, or from its last reconnect time moving if the count didn't change
+func reconnects(history []Sample, since time.Time) (count int, stateChanges int) {
+	for i := 1; i < len(history); i++ {
+		previous, current := history[i-1], history[i]
+		if current.Timestamp.Before(since) {
+			continue
+		}
+		reconnected := disconnectionDelta(previous, current)
+		if reconnected == 0 && current.LastReconnect.After(previous.LastReconnect) {
+			reconnected = 1
+		}
+		count += reconnected
+		if current.Up != previous.Up {
+			stateChanges++
+		}
+	}
+	return count, stateChanges
+}
+
+// finds flapping shards and clusters in the combined state, caller must hold the write lock
+func (p *Poller) detectAnomalies(info ClustersInfo, now time.Time) Anomalies {
+	t := p.anomalyThresholds
+	since := now.Add(-t.Window)
+	anomalies := Anomalies{
+		Timestamp: now,
+		Window:    t.Window.String(),
+		Shards:    make([]ShardAnomaly, 0),
+		Clusters:  make([]ClusterAnomaly, 0),
+	}
+	for _, cluster := range info.Clusters {
+		known, flapping := 0, 0
+		for _, shard := range cluster.Shards {
+			if shard.Status == HealthUnknown {
+				continue
+			}
+			known++
+			count, stateChanges := reconnects(p.history[historyKey{source: cluster.Source, shardID: shard.ShardID}], since)
+			if count > 0 {
+				anomalies.ReconnectingShards++
+			}
+			if count < t.FlapReconnects && stateChanges < t.FlapStateChanges {
+				continue
+			}
+			flapping++
+			anomalies.Shards = append(anomalies.Shards, ShardAnomaly{
+				Source:        cluster.Source,
+				ShardID:       shard.ShardID,
+				ClusterID:     cluster.ID,
+				Reconnects:    count,
+				ReconnectRate: float64(count) / t.Window.Hours(),
+				StateChanges:  stateChanges,
+			})
+		}
+		anomalies.TotalShards += known
+		if flapping > 0 && float64(flapping) >= t.ClusterFlapRatio*float64(known) {
+			anomalies.Clusters = append(anomalies.Clusters, ClusterAnomaly{
+				ID:             cluster.ID,
+				Source:         cluster.Source,
+				FlappingShards: flapping,
+				NumShards:      known,
+			})
+		}
+	}
+	anomalies.Storm = anomalies.ReconnectingShards > 0 &&
+		float64(anomalies.ReconnectingShards) >= t.StormRatio*float64(anomalies.TotalShards)
+	return anomalies
+}
+
+// opens an incident when a storm starts and resolves it once it's over. an incident left open by a storm from
+// before a restart is picked up on the first poll, and resolved once a whole window went by without a storm,
+// since reconnects from before the restart can't be counted
+func (p *Poller) updateStormIncident(ctx context.Context, anomalies Anomalies, started bool, ended bool, now time.Time) {
+	p.stormMutex.Lock()
+	defer p.stormMutex.Unlock()
+	if !p.stormLoaded {
+		p.loadStormIncident(ctx, now)
+	}
+
+	switch {
+	case started:
+		p.stormRecovered = time.Time{}
+		p.openStormIncident(ctx, anomalies)
+	case ended:
+		p.resolveStormIncident(ctx)
+	case !anomalies.Storm && !p.stormRecovered.IsZero() && now.Sub(p.stormRecovered) >= p.anomalyThresholds.Window:
+		p.stormRecovered = time.Time{}
+		p.resolveStormIncident(ctx)
+	}
+}
+
+// finds the newest open storm incident, which was opened before a restart since we haven't opened one yet.
+// stormMutex must be held
+func (p *Poller) loadStormIncident(ctx context.Context, now time.Time) {
+	active, err := p.database.GetActiveIncidents(ctx)
+	if err != nil {
+		p.logger.Error("error while getting active incidents", slog.Any("error", err))
+		return
+	}
+	p.stormLoaded = true
+
+	var latest util.Incident
+	for _, incident := range active.Incidents {
+		if incident.Name == stormIncidentName && incident.Timestamp.After(latest.Timestamp) {
+			latest = incident
+		}
+	}
+	if latest.ID == "" || p.stormIncident != "" {
+		return
+	}
+	p.stormIncident = latest.ID
+	p.stormRecovered = now
+	p.logger.Info("found reconnect storm incident from before a restart", slog.String("incident", latest.ID))
+}
+
+// opens a minor incident for a reconnect storm, unless the one opened for the last storm is still open.
+// stormMutex must be held
+func (p *Poller) openStormIncident(ctx context.Context, anomalies Anomalies) {
+	if p.incidentOpen(ctx, p.stormIncident) {
+		return
+	}
+
+	id, err := p.database.CreateIncident(ctx, util.Incident{
+		Name: stormIncidentName,
+		Description: fmt.Sprintf("%d of %d shards reconnected in the last %s, some servers may not get responses from PluralKit.",
+			anomalies.ReconnectingShards, anomalies.TotalShards, anomalies.Window),
+		Status: util.StatusInvestigating,
+		Impact: util.ImpactMinor,
+	})
+	if err != nil {
+		p.logger.Error("error while creating reconnect storm incident", slog.Any("error", err))
+		return
+	}
+	p.stormIncident = id
+	p.logger.Info("opened incident for reconnect storm", slog.String("incident", id))
+}
+
+// resolves the incident opened for a reconnect storm once it's over, unless someone already did.
+// stormMutex must be held
+func (p *Poller) resolveStormIncident(ctx context.Context) {
+	id := p.stormIncident
+	if !p.incidentOpen(ctx, id) {
+		p.stormIncident = ""
+		return
+	}
+
+	resolved := util.StatusResolved
+	_, err := p.database.CreateUpdate(ctx, util.IncidentUpdate{
+		IncidentID: id,
+		Text:       "Shards have stopped reconnecting.",
+		Status:     &resolved,
+	})
+	if err != nil {
+		p.logger.Error("error while resolving reconnect storm incident", slog.String("incident", id), slog.Any("error", err))
+		return
+	}
+	p.stormIncident = ""
+	p.logger.Info("resolved incident for reconnect storm", slog.String("incident", id))
+}
+
+// returns true if the incident exists and isn't resolved yet
+func (p *Poller) incidentOpen(ctx context.Context, id string) bool {
+	if id == "" {
+		return false
+	}
+	incident, err := p.database.GetIncident(ctx, id)
+	if err != nil {
+		if !errors.Is(err, util.ErrNotFound) {
+			p.logger.Error("error while getting reconnect storm incident", slog.String("incident", id), slog.Any("error", err))
+		}
+		return false
+	}
+	return incident.Status != util.StatusResolved
+}
+
+// flapping shards and clusters as of the last poll
+func (p *Poller) Anomalies() Anomalies {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	anomalies := p.anomalies
+	anomalies.Shards = slices.Clone(anomalies.Shards)
+	anomalies.Clusters = slices.Clone(anomalies.Clusters)
+	if anomalies.Shards == nil {
+		anomalies.Shards = make([]ShardAnomaly, 0)
+	}
+	if anomalies.Clusters == nil {
+		anomalies.Clusters = make([]ClusterAnomaly, 0)
+	}
+	if anomalies.Window == "" {
+		anomalies.Window = p.anomalyThresholds.Window.String()
+	}
+	return anomalies
+}
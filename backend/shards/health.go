@@ -0,0 +1,71 @@
+package shards
+
+import (
+	"pluralkit/status/util"
+	"time"
+)
+
+// Health is how a shard or cluster is doing, from best to worst
+type Health string
+
+const (
+	HealthHealthy  Health = "healthy"
+	HealthDegraded Health = "degraded"
+	HealthSevere   Health = "severe"
+	HealthDown     Health = "down"
+	HealthUnknown  Health = "unknown" // the shard's source is failing, so we don't know how it's doing
+)
+
+// fills in defaults for thresholds that aren't set, e.g. in configs that weren't loaded from the environment
+func withDefaults(t util.HealthThresholds) util.HealthThresholds {
+	if t.HeartbeatTimeout <= 0 {
+		t.HeartbeatTimeout = 10 * time.Minute
+	}
+	if t.ReconnectGrace <= 0 {
+		t.ReconnectGrace = 10 * time.Second
+	}
+	if t.DegradedLatency <= 0 {
+		t.DegradedLatency = 200
+	}
+	if t.SevereLatency <= 0 {
+		t.SevereLatency = 400
+	}
+	if t.ClusterUpRatio <= 0 || t.ClusterUpRatio >= 1 {
+		t.ClusterUpRatio = 0.5
+	}
+	return t
+}
+
+func latencyHealth(t util.HealthThresholds, latency int) Health {
+	switch {
+	case latency >= t.SevereLatency:
+		return HealthSevere
+	case latency >= t.DegradedLatency:
+		return HealthDegraded
+	default:
+		return HealthHealthy
+	}
+}
+
+// shards are down without a recent heartbeat, or if upstream says so and they haven't just reconnected
+func classifyShard(t util.HealthThresholds, shard Shard, now time.Time) Health {
+	if now.Sub(shard.LastHeartbeat.Time) >= t.HeartbeatTimeout {
+		return HealthDown
+	}
+	if !shard.Up && now.Sub(shard.LastReconnect.Time) > t.ReconnectGrace {
+		return HealthDown
+	}
+	return latencyHealth(t, shard.Latency)
+}
+
+// clusters are down unless enough of their shards are up, and at least degraded while any shard is down
+func classifyCluster(t util.HealthThresholds, cluster *Cluster) Health {
+	if cluster.NumShards == 0 || float64(cluster.ShardsUp) <= t.ClusterUpRatio*float64(cluster.NumShards) {
+		return HealthDown
+	}
+	health := latencyHealth(t, cluster.AvgLatency)
+	if health == HealthHealthy && cluster.ShardsUp < cluster.NumShards {
+		return HealthDegraded
+	}
+	return health
+}
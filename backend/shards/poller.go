@@ -0,0 +1,497 @@
+// Package shards polls PluralKit's shard state in the background, so requests are always served from memory
+package shards
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"pluralkit/status/db"
+	"pluralkit/status/util"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// the fewest samples of each shard's state that are kept, more are kept if the rate window needs them
+const minHistorySize = 60
+
+// snapshots older than this many intervals are stale even if nothing failed, e.g. if polling got stuck
+const staleIntervals = 3
+
+// the name of pluralkit__status__shards_endpoint when no sources are configured
+const defaultSource = "default"
+
+// an upstream shard state endpoint and the last state fetched from it
+type source struct {
+	name        string
+	endpoint    string
+	info        ClustersInfo
+	lastSuccess time.Time
+	lastError   string
+	failures    int
+}
+
+type historyKey struct {
+	source  string
+	shardID int
+}
+
+type fetchResult struct {
+	info   ClustersInfo
+	shards []Shard
+	err    error
+}
+
+type Poller struct {
+	logger            *slog.Logger
+	interval          time.Duration
+	rateWindow        time.Duration
+	historySize       int
+	maxConcurrency    int
+	health            util.HealthThresholds
+	anomalyThresholds util.AnomalyThresholds
+	httpClient        *http.Client
+	database          *db.DB
+
+	mutex       sync.RWMutex
+	sources     []*source
+	lastSuccess time.Time // the last poll where at least one source succeeded
+	lastAttempt time.Time
+	lastError   string
+	failures    int // polls in a row where every source failed
+	history     map[historyKey][]Sample
+	stats       []StatsSample
+	anomalies   Anomalies
+
+	topologyMutex sync.Mutex
+	topology      *util.TopologyChange // the last recorded topology, loaded from the database on the first poll
+
+	stormMutex     sync.Mutex
+	stormIncident  string    // the incident opened for the current reconnect storm, resolved once it's over
+	stormLoaded    bool      // whether the database was checked for a storm incident left open before a restart
+	stormRecovered time.Time // when such an incident was found, zero if there wasn't one or a storm took it over
+}
+
+func NewPoller(config util.Config, logger *slog.Logger, database *db.DB) *Poller {
+	moduleLogger := logger.With(slog.String("module", "shards"))
+	interval := config.ShardsPollInterval
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	rateWindow := config.ShardsRateWindow
+	if rateWindow <= 0 {
+		rateWindow = 10 * time.Minute
+	}
+	anomalyThresholds := anomalyDefaults(config.Anomalies)
+	sources := make([]*source, 0)
+	for _, configured := range config.ShardsSources {
+		sources = append(sources, &source{name: configured.Name, endpoint: configured.Endpoint})
+	}
+	if len(sources) == 0 {
+		sources = append(sources, &source{name: defaultSource, endpoint: config.ShardsEndpoint})
+	}
+	for _, source := range sources {
+		source.info = ClustersInfo{MaxConcurrency: config.MaxConcurrency}
+	}
+	return &Poller{
+		logger:            moduleLogger,
+		interval:          interval,
+		rateWindow:        rateWindow,
+		historySize:       max(minHistorySize, int(rateWindow/interval)+1, int(anomalyThresholds.Window/interval)+1),
+		maxConcurrency:    config.MaxConcurrency,
+		health:            withDefaults(config.Health),
+		anomalyThresholds: anomalyThresholds,
+		httpClient:        &http.Client{Timeout: 10 * time.Second},
+		database:          database,
+		sources:           sources,
+		history:           make(map[historyKey][]Sample),
+	}
+}
+
+// polls immediately and then every interval until ctx is cancelled
+func (p *Poller) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		_ = p.Poll(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// fetches the current shard state from every source at once. sources that fail keep their previous state,
+// which is shown as unknown, and the poll only fails if every source did
+func (p *Poller) Poll(ctx context.Context) error {
+	now := time.Now()
+	results := make([]fetchResult, len(p.sources))
+	var wg sync.WaitGroup
+	for i, source := range p.sources {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i].info, results[i].shards, results[i].err = p.fetch(ctx, source.endpoint)
+		}()
+	}
+	wg.Wait()
+
+	p.mutex.Lock()
+	p.lastAttempt = now
+	errs := make([]error, 0)
+	for i, source := range p.sources {
+		result := results[i]
+		if result.err != nil {
+			source.failures++
+			source.lastError = result.err.Error()
+			errs = append(errs, fmt.Errorf("%s: %w", source.name, result.err))
+			p.logger.Warn("error while polling shard state", slog.String("source", source.name), slog.Int("consecutive_failures", source.failures), slog.Any("error", result.err))
+			continue
+		}
+
+		if source.failures > 0 {
+			p.logger.Info("shard state polling recovered", slog.String("source", source.name), slog.Int("failures", source.failures))
+		}
+		for _, cluster := range result.info.Clusters {
+			cluster.Source = source.name
+		}
+		source.failures = 0
+		source.lastError = ""
+		source.lastSuccess = now
+		p.recordHistory(source.name, result.shards, now)
+		p.updateStats(source.name, &result.info, now)
+		source.info = result.info
+	}
+
+	if len(errs) == len(p.sources) {
+		p.failures++
+		err := errors.Join(errs...)
+		p.lastError = err.Error()
+		p.mutex.Unlock()
+		return err
+	}
+	p.failures = 0
+	p.lastError = ""
+	p.lastSuccess = now
+	// sources that never succeeded would look like a topology change once they do
+	complete := true
+	for _, source := range p.sources {
+		complete = complete && !source.lastSuccess.IsZero()
+	}
+	info := p.combine(p.sourceSnapshots())
+	p.recordStats(info, now)
+	anomalies := p.detectAnomalies(info, now)
+	stormStarted := anomalies.Storm && !p.anomalies.Storm
+	stormEnded := !anomalies.Storm && p.anomalies.Storm
+	p.anomalies = anomalies
+	p.mutex.Unlock()
+
+	if complete {
+		p.checkTopology(ctx, info)
+	}
+	if stormStarted {
+		p.logger.Warn("shard reconnect storm", slog.Int("reconnecting_shards", anomalies.ReconnectingShards), slog.Int("shards", anomalies.TotalShards))
+	} else if stormEnded {
+		p.logger.Info("shard reconnect storm is over")
+	}
+	if p.anomalyThresholds.StormIncident {
+		p.updateStormIncident(ctx, anomalies, stormStarted, stormEnded, now)
+	}
+	return nil
+}
+
+func (p *Poller) fetch(ctx context.Context, endpoint string) (ClustersInfo, []Shard, error) {
+	info := ClustersInfo{MaxConcurrency: p.maxConcurrency}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return info, nil, err
+	}
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return info, nil, err
+	}
+	defer resp.Body.Close() //nolint:all
+	if resp.StatusCode != 200 {
+		return info, nil, fmt.Errorf("shards endpoint returned %s", resp.Status)
+	}
+
+	var data struct {
+		Shards []Shard `json:"shards"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&data)
+	if err != nil {
+		return info, nil, err
+	}
+	info, err = buildClusters(data.Shards, p.maxConcurrency, p.health)
+	return info, data.Shards, err
+}
+
+// groups shards into clusters the way upstream reports them, so cluster count and sizes can change between polls.
+// cluster IDs are indexes into Clusters, clusters without any shards are still included (empty) to keep it that way
+func buildClusters(shards []Shard, maxConcurrency int, health util.HealthThresholds) (ClustersInfo, error) {
+	info := ClustersInfo{MaxConcurrency: maxConcurrency}
+	if len(shards) == 0 {
+		return info, fmt.Errorf("shards endpoint returned no shards")
+	}
+
+	sort.Slice(shards, func(i, j int) bool {
+		return shards[i].ShardID < shards[j].ShardID
+	})
+
+	numClusters := 0
+	for _, shard := range shards {
+		// more clusters than shards means upstream sent something broken
+		if shard.ClusterID < 0 || shard.ClusterID >= len(shards) || shard.ShardID < 0 {
+			return info, fmt.Errorf("shard %d is on unexpected cluster %d", shard.ShardID, shard.ClusterID)
+		}
+		numClusters = max(numClusters, shard.ClusterID+1)
+		info.NumShards = max(info.NumShards, shard.ShardID+1)
+	}
+
+	info.Clusters = make([]*Cluster, numClusters)
+	for i := range info.Clusters {
+		info.Clusters[i] = &Cluster{ID: i, Shards: make([]Shard, 0)}
+	}
+	now := time.Now()
+	for key, shard := range shards {
+		cluster := info.Clusters[shard.ClusterID]
+		cluster.AvgLatency += shard.Latency
+		info.AvgLatency += shard.Latency
+		shard.Status = classifyShard(health, shard, now)
+		shard.Up = shard.Status != HealthDown
+		if shard.Up {
+			info.ShardsUp++
+			cluster.ShardsUp++
+		}
+		cluster.Shards = append(cluster.Shards, shard)
+		shards[key] = shard
+	}
+
+	for _, cluster := range info.Clusters {
+		cluster.NumShards = len(cluster.Shards)
+		if cluster.NumShards > 0 {
+			cluster.AvgLatency /= cluster.NumShards
+		}
+		cluster.Status = classifyCluster(health, cluster)
+		cluster.Up = cluster.Status != HealthDown
+	}
+	info.AvgLatency /= len(shards)
+	return info, nil
+}
+
+// the clusters of every source combined, with clusters renumbered in source order. caller must hold the lock
+func (p *Poller) combine(sources []SourceSnapshot) ClustersInfo {
+	info := ClustersInfo{MaxConcurrency: p.maxConcurrency, Clusters: make([]*Cluster, 0)}
+	totalShards := 0
+	shards := make([]Shard, 0)
+	for _, source := range sources {
+		for _, cluster := range source.Clusters {
+			shards = append(shards, cluster.Shards...)
+			copied := *cluster
+			copied.ID = len(info.Clusters)
+			info.Clusters = append(info.Clusters, &copied)
+			info.AvgLatency += cluster.AvgLatency * cluster.NumShards
+			totalShards += cluster.NumShards
+		}
+		info.NumShards += source.NumShards
+		info.ShardsUp += source.ShardsUp
+	}
+	if totalShards > 0 {
+		info.AvgLatency /= totalShards
+	}
+	// percentiles can't be combined, so they're worked out from every shard again
+	info.Stats = statsOf(shards, p.lastSuccess, p.rateWindow)
+	return info
+}
+
+// the number of shards in each cluster
+func (c ClustersInfo) clusterSizes() []int {
+	sizes := make([]int, len(c.Clusters))
+	for i, cluster := range c.Clusters {
+		sizes[i] = len(cluster.Shards)
+	}
+	return sizes
+}
+
+// records the topology if it changed since the last one we know about, e.g. after PluralKit reshards
+func (p *Poller) checkTopology(ctx context.Context, info ClustersInfo) {
+	p.topologyMutex.Lock()
+	defer p.topologyMutex.Unlock()
+
+	if p.topology == nil {
+		latest, err := p.database.GetLatestTopology(ctx)
+		if err == nil {
+			p.topology = &latest
+		} else if !errors.Is(err, util.ErrNotFound) {
+			p.logger.Error("error while getting shard topology", slog.Any("error", err))
+			return
+		}
+	}
+
+	sizes := info.clusterSizes()
+	if p.topology != nil && p.topology.NumShards == info.NumShards && slices.Equal(p.topology.ClusterSizes, sizes) {
+		return
+	}
+
+	change := util.TopologyChange{
+		Timestamp:    time.Now(),
+		NumShards:    info.NumShards,
+		ClusterSizes: sizes,
+	}
+	if p.topology != nil {
+		change.PreviousShards = p.topology.NumShards
+		change.PreviousClusterSizes = p.topology.ClusterSizes
+		p.logger.Warn("shard topology changed",
+			slog.Int("shards", change.NumShards), slog.Int("clusters", len(change.ClusterSizes)),
+			slog.Int("previous_shards", change.PreviousShards), slog.Int("previous_clusters", len(change.PreviousClusterSizes)),
+		)
+	}
+	recorded, err := p.database.RecordTopologyChange(ctx, change)
+	if err != nil {
+		p.logger.Error("error while recording shard topology change", slog.Any("error", err))
+		return
+	}
+	p.topology = &recorded
+}
+
+// adds a sample for every shard of a source, caller must hold the write lock
+func (p *Poller) recordHistory(source string, shards []Shard, timestamp time.Time) {
+	for _, shard := range shards {
+		key := historyKey{source: source, shardID: shard.ShardID}
+		history := append(p.history[key], Sample{
+			Timestamp:          timestamp,
+			Up:                 shard.Up,
+			Latency:            shard.Latency,
+			DisconnectionCount: shard.DisconnectionCount,
+			LastReconnect:      shard.LastReconnect.Time,
+		})
+		if len(history) > p.historySize {
+			history = slices.Clone(history[len(history)-p.historySize:])
+		}
+		p.history[key] = history
+	}
+}
+
+// counts each shard's disconnections within the rate window from its history (so that has to be recorded first),
+// and works out the stats of every cluster and the source as a whole. caller must hold the write lock
+func (p *Poller) updateStats(source string, info *ClustersInfo, now time.Time) {
+	since := now.Add(-p.rateWindow)
+	shards := make([]Shard, 0, info.NumShards)
+	for _, cluster := range info.Clusters {
+		for i := range cluster.Shards {
+			shard := &cluster.Shards[i]
+			shard.Disconnections = disconnections(p.history[historyKey{source: source, shardID: shard.ShardID}], since)
+		}
+		cluster.Stats = statsOf(cluster.Shards, now, p.rateWindow)
+		shards = append(shards, cluster.Shards...)
+	}
+	info.Stats = statsOf(shards, now, p.rateWindow)
+}
+
+// adds a sample of the combined stats, caller must hold the write lock
+func (p *Poller) recordStats(info ClustersInfo, timestamp time.Time) {
+	sample := StatsSample{Timestamp: timestamp, Stats: info.Stats, Clusters: make([]Stats, len(info.Clusters))}
+	for i, cluster := range info.Clusters {
+		sample.Clusters[i] = cluster.Stats
+	}
+	p.stats = append(p.stats, sample)
+	if len(p.stats) > p.historySize {
+		p.stats = slices.Clone(p.stats[len(p.stats)-p.historySize:])
+	}
+}
+
+// caller must hold the lock
+func (p *Poller) stale() bool {
+	return p.failures > 0 || time.Since(p.lastSuccess) > staleIntervals*p.interval
+}
+
+// copies of every source's last state in order, caller must hold the lock.
+// sources that failed during the last good poll are unknown, if every source is failing the whole snapshot is stale instead
+func (p *Poller) sourceSnapshots() []SourceSnapshot {
+	snapshots := make([]SourceSnapshot, len(p.sources))
+	for i, source := range p.sources {
+		snapshots[i] = SourceSnapshot{
+			Name:                source.name,
+			ClustersInfo:        source.info.clone(),
+			Timestamp:           source.lastSuccess,
+			Unknown:             source.lastSuccess.IsZero() || source.lastSuccess.Before(p.lastSuccess),
+			ConsecutiveFailures: source.failures,
+			Error:               source.lastError,
+		}
+		if snapshots[i].Unknown {
+			snapshots[i].markUnknown()
+		}
+	}
+	return snapshots
+}
+
+// returns the last good snapshot, ok is false if polling hasn't succeeded yet
+func (p *Poller) Snapshot() (snapshot Snapshot, ok bool) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	if p.lastSuccess.IsZero() {
+		return Snapshot{}, false
+	}
+	sources := p.sourceSnapshots()
+	return Snapshot{
+		ClustersInfo:        p.combine(sources),
+		Timestamp:           p.lastSuccess,
+		Age:                 time.Since(p.lastSuccess).Seconds(),
+		Stale:               p.stale(),
+		ConsecutiveFailures: p.failures,
+		Sources:             sources,
+	}, true
+}
+
+// recent samples for a shard of a source, oldest first
+func (p *Poller) History(source string, shardID int) []Sample {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	history := slices.Clone(p.history[historyKey{source: source, shardID: shardID}])
+	if history == nil {
+		history = make([]Sample, 0)
+	}
+	return history
+}
+
+// recent stats of every cluster and all of them together, oldest first
+func (p *Poller) StatsHistory() []StatsSample {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	stats := slices.Clone(p.stats)
+	if stats == nil {
+		stats = make([]StatsSample, 0)
+	}
+	return stats
+}
+
+func (p *Poller) Status() Status {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	status := Status{
+		Healthy:             !p.lastSuccess.IsZero() && !p.stale(),
+		Interval:            p.interval.String(),
+		LastAttempt:         p.lastAttempt,
+		LastSuccess:         p.lastSuccess,
+		LastError:           p.lastError,
+		ConsecutiveFailures: p.failures,
+		Sources:             make([]SourceStatus, len(p.sources)),
+	}
+	for i, source := range p.sources {
+		status.Sources[i] = SourceStatus{
+			Name:                source.name,
+			Healthy:             !source.lastSuccess.IsZero() && source.failures == 0,
+			LastSuccess:         source.lastSuccess,
+			LastError:           source.lastError,
+			ConsecutiveFailures: source.failures,
+		}
+		status.Healthy = status.Healthy && status.Sources[i].Healthy
+	}
+	return status
+}
@@ -0,0 +1,89 @@
+package shards
+
+import (
+	"math"
+	"slices"
+	"time"
+)
+
+// percentiles of a value over a group of shards, so tail problems don't hide behind the average
+type Distribution struct {
+	P50 int `json:"p50"`
+	P90 int `json:"p90"`
+	P99 int `json:"p99"`
+	Max int `json:"max"`
+}
+
+type Stats struct {
+	Latency           Distribution `json:"latency"`            // milliseconds
+	HeartbeatAge      Distribution `json:"heartbeat_age"`      // seconds since each shard's last heartbeat
+	Disconnections    int          `json:"disconnections"`     // within the rate window
+	DisconnectionRate float64      `json:"disconnection_rate"` // disconnections per hour over the rate window
+}
+
+// the stats of every cluster (and all of them together) at one point in time
+type StatsSample struct {
+	Timestamp time.Time `json:"timestamp"`
+	Stats
+	Clusters []Stats `json:"clusters"` // indexed by cluster ID
+}
+
+// nearest-rank percentiles, values must be sorted
+func distribution(values []int) Distribution {
+	if len(values) == 0 {
+		return Distribution{}
+	}
+	rank := func(percentile float64) int {
+		index := int(math.Ceil(percentile/100*float64(len(values)))) - 1
+		return values[max(index, 0)]
+	}
+	return Distribution{
+		P50: rank(50),
+		P90: rank(90),
+		P99: rank(99),
+		Max: values[len(values)-1],
+	}
+}
+
+// stats over shards whose state is known, heartbeat ages are relative to now
+func statsOf(shards []Shard, now time.Time, window time.Duration) Stats {
+	latencies := make([]int, 0, len(shards))
+	ages := make([]int, 0, len(shards))
+	var stats Stats
+	for _, shard := range shards {
+		if shard.Status == HealthUnknown {
+			continue
+		}
+		latencies = append(latencies, shard.Latency)
+		ages = append(ages, max(int(now.Sub(shard.LastHeartbeat.Time).Seconds()), 0))
+		stats.Disconnections += shard.Disconnections
+	}
+	slices.Sort(latencies)
+	slices.Sort(ages)
+	stats.Latency = distribution(latencies)
+	stats.HeartbeatAge = distribution(ages)
+	if window > 0 {
+		stats.DisconnectionRate = float64(stats.Disconnections) / window.Hours()
+	}
+	return stats
+}
+
+// how many times a shard disconnected between two samples.
+// a count lower than the one before means upstream restarted and started counting from zero again
+func disconnectionDelta(previous Sample, current Sample) int {
+	if current.DisconnectionCount >= previous.DisconnectionCount {
+		return current.DisconnectionCount - previous.DisconnectionCount
+	}
+	return current.DisconnectionCount
+}
+
+// how many times a shard disconnected since the given time, from the differences between its samples
+func disconnections(history []Sample, since time.Time) int {
+	count := 0
+	for i := 1; i < len(history); i++ {
+		if !history[i].Timestamp.Before(since) {
+			count += disconnectionDelta(history[i-1], history[i])
+		}
+	}
+	return count
+}
@@ -0,0 +1,147 @@
+package shards
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+type UnixTime struct {
+	time.Time
+}
+
+func (u *UnixTime) UnmarshalJSON(b []byte) error {
+	var timestamp int64
+	err := json.Unmarshal(b, &timestamp)
+	if err != nil {
+		return err
+	}
+	u.Time = time.Unix(timestamp, 0)
+	return nil
+}
+func (u UnixTime) MarshalJSON() ([]byte, error) {
+	if u.Time.IsZero() { //nolint:all
+		return []byte("0"), nil
+	}
+	return []byte(fmt.Sprintf("%d", u.Time.Unix())), nil //nolint:all
+}
+
+type Shard struct {
+	ShardID            int      `json:"shard_id"`
+	ClusterID          int      `json:"cluster_id"`
+	Up                 bool     `json:"up"`
+	DisconnectionCount int      `json:"disconnection_count"`
+	Latency            int      `json:"latency"`
+	LastHeartbeat      UnixTime `json:"last_heartbeat"`
+	LastConnection     UnixTime `json:"last_connection"`
+	LastReconnect      UnixTime `json:"last_reconnect"`
+	Status             Health   `json:"status"`
+	Disconnections     int      `json:"disconnections"` // within the rate window, counted from the shard's history
+}
+
+type Cluster struct {
+	ID         int     `json:"id"`
+	Source     string  `json:"source"`
+	AvgLatency int     `json:"avg_latency"`
+	NumShards  int     `json:"num_shards"`
+	ShardsUp   int     `json:"shards_up"`
+	Shards     []Shard `json:"-"` // sorted by shard ID
+	Up         bool    `json:"up"`
+	Status     Health  `json:"status"`
+	Stats      Stats   `json:"stats"`
+}
+
+type ClustersInfo struct {
+	AvgLatency     int        `json:"avg_latency"`
+	MaxConcurrency int        `json:"max_concurrency"`
+	NumShards      int        `json:"num_shards"`
+	ShardsUp       int        `json:"shards_up"`
+	Clusters       []*Cluster `json:"clusters"`
+	Stats          Stats      `json:"stats"`
+}
+
+// a deep copy, so snapshots can be handed out without holding the lock
+func (c ClustersInfo) clone() ClustersInfo {
+	clusters := make([]*Cluster, len(c.Clusters))
+	for i, cluster := range c.Clusters {
+		copied := *cluster
+		copied.Shards = append([]Shard(nil), cluster.Shards...)
+		clusters[i] = &copied
+	}
+	c.Clusters = clusters
+	return c
+}
+
+// a shard's state at one point in time
+type Sample struct {
+	Timestamp          time.Time `json:"timestamp"`
+	Up                 bool      `json:"up"`
+	Latency            int       `json:"latency"`
+	DisconnectionCount int       `json:"disconnection_count"`
+	LastReconnect      time.Time `json:"last_reconnect"`
+}
+
+// marks everything as unknown, for sources that are failing. this should only be called on a clone
+func (c *ClustersInfo) markUnknown() {
+	c.ShardsUp = 0
+	c.Stats = Stats{}
+	for _, cluster := range c.Clusters {
+		cluster.Up = false
+		cluster.Status = HealthUnknown
+		cluster.ShardsUp = 0
+		cluster.Stats = Stats{}
+		for i := range cluster.Shards {
+			cluster.Shards[i].Up = false
+			cluster.Shards[i].Status = HealthUnknown
+		}
+	}
+}
+
+// Snapshot is the last cluster state fetched successfully, along with how fresh it is
+type Snapshot struct {
+	ClustersInfo                         // all sources combined
+	Timestamp           time.Time        `json:"timestamp"`
+	Age                 float64          `json:"age"` // seconds since the snapshot was fetched
+	Stale               bool             `json:"stale"`
+	ConsecutiveFailures int              `json:"consecutive_failures"`
+	Sources             []SourceSnapshot `json:"sources"`
+}
+
+// one source's last known state. while a source is failing its shards are unknown, instead of failing everything
+type SourceSnapshot struct {
+	Name string `json:"name"`
+	ClustersInfo
+	Timestamp           time.Time `json:"timestamp"` // when the source was last fetched successfully, zero if it never was
+	Unknown             bool      `json:"unknown"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	Error               string    `json:"error,omitempty"`
+}
+
+// returns the snapshot of the named source
+func (s Snapshot) Source(name string) (SourceSnapshot, bool) {
+	for _, source := range s.Sources {
+		if source.Name == name {
+			return source, true
+		}
+	}
+	return SourceSnapshot{}, false
+}
+
+// Status is the poller's own health, for readiness checks and alerting
+type Status struct {
+	Healthy             bool           `json:"healthy"`
+	Interval            string         `json:"interval"`
+	LastAttempt         time.Time      `json:"last_attempt"`
+	LastSuccess         time.Time      `json:"last_success"`
+	LastError           string         `json:"last_error,omitempty"`
+	ConsecutiveFailures int            `json:"consecutive_failures"`
+	Sources             []SourceStatus `json:"sources"`
+}
+
+type SourceStatus struct {
+	Name                string    `json:"name"`
+	Healthy             bool      `json:"healthy"`
+	LastSuccess         time.Time `json:"last_success"`
+	LastError           string    `json:"last_error,omitempty"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+}
@@ -0,0 +1,194 @@
+// Package statuspage converts incident history exported from Atlassian Statuspage into incidents
+package statuspage
+
+import (
+	"encoding/json"
+	"errors"
+	"pluralkit/status/util"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// source name used when recording imported incidents
+const Source = "statuspage"
+
+type Component struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Status string `json:"status"`
+}
+
+type AffectedComponent struct {
+	Code      string `json:"code"`
+	Name      string `json:"name"`
+	OldStatus string `json:"old_status"`
+	NewStatus string `json:"new_status"`
+}
+
+type IncidentUpdate struct {
+	ID                 string              `json:"id"`
+	IncidentID         string              `json:"incident_id"`
+	Status             string              `json:"status"`
+	Body               string              `json:"body"`
+	CreatedAt          time.Time           `json:"created_at"`
+	DisplayAt          *time.Time          `json:"display_at"`
+	AffectedComponents []AffectedComponent `json:"affected_components"`
+}
+
+type Incident struct {
+	ID              string           `json:"id"`
+	Name            string           `json:"name"`
+	Status          string           `json:"status"`
+	Impact          string           `json:"impact"`
+	CreatedAt       time.Time        `json:"created_at"`
+	UpdatedAt       *time.Time       `json:"updated_at"`
+	StartedAt       *time.Time       `json:"started_at"`
+	ResolvedAt      *time.Time       `json:"resolved_at"`
+	IncidentUpdates []IncidentUpdate `json:"incident_updates"`
+	Components      []Component      `json:"components"`
+}
+
+// Export is a statuspage export, either the incidents list from the API or a full page export.
+// page components aren't needed, incidents list the ones they affected themselves
+type Export struct {
+	Incidents []Incident `json:"incidents"`
+}
+
+// Parse reads an export, accepting both a bare array of incidents and an object with an "incidents" key
+func Parse(data []byte) (Export, error) {
+	var export Export
+	if strings.HasPrefix(strings.TrimSpace(string(data)), "[") {
+		err := json.Unmarshal(data, &export.Incidents)
+		return export, err
+	}
+
+	err := json.Unmarshal(data, &export)
+	if err != nil {
+		return export, err
+	}
+	if export.Incidents == nil {
+		return export, errors.New("export does not contain any incidents")
+	}
+	return export, nil
+}
+
+// MapStatus maps statuspage incident and maintenance statuses onto ours
+func MapStatus(status string) (util.IncidentStatus, bool) {
+	switch status {
+	case "investigating":
+		return util.StatusInvestigating, true
+	case "identified":
+		return util.StatusIdentified, true
+	case "monitoring":
+		return util.StatusMonitoring, true
+	case "resolved", "postmortem", "completed":
+		return util.StatusResolved, true
+	case "scheduled", "in_progress", "verifying":
+		return util.StatusMaintenance, true
+	default:
+		return "", false
+	}
+}
+
+// MapImpact maps statuspage impacts onto ours, critical outages count as major and maintenance has no impact
+func MapImpact(impact string) util.Impact {
+	switch impact {
+	case "minor":
+		return util.ImpactMinor
+	case "major", "critical":
+		return util.ImpactMajor
+	default:
+		return util.ImpactNone
+	}
+}
+
+// Convert turns a statuspage incident into one of ours, without IDs since those get assigned when inserting
+func Convert(incident Incident) (util.Incident, error) {
+	status, ok := MapStatus(incident.Status)
+	if !ok {
+		return util.Incident{}, errors.New("unknown incident status " + incident.Status)
+	}
+
+	converted := util.Incident{
+		Timestamp:  incident.CreatedAt,
+		LastUpdate: incident.CreatedAt,
+		Status:     status,
+		Impact:     MapImpact(incident.Impact),
+		Name:       truncate(incident.Name, 100),
+		Updates:    make([]*util.IncidentUpdate, 0, len(incident.IncidentUpdates)),
+	}
+	if incident.StartedAt != nil && !incident.StartedAt.IsZero() {
+		converted.Timestamp = *incident.StartedAt
+	}
+	if incident.UpdatedAt != nil {
+		converted.LastUpdate = *incident.UpdatedAt
+	}
+	if status == util.StatusResolved && incident.ResolvedAt != nil {
+		converted.ResolutionTimestamp = *incident.ResolvedAt
+	}
+
+	// statuspage lists updates newest first. sorting a copy leaves the caller's incident as it was
+	updates := slices.Clone(incident.IncidentUpdates)
+	sort.SliceStable(updates, func(i, j int) bool {
+		return updateTime(updates[i]).Before(updateTime(updates[j]))
+	})
+
+	components := make([]string, 0)
+	addComponent := func(name string) {
+		if name == "" || len(components) >= 25 {
+			return
+		}
+		name = truncate(name, 100)
+		for _, existing := range components {
+			if existing == name {
+				return
+			}
+		}
+		components = append(components, name)
+	}
+	for _, component := range incident.Components {
+		addComponent(component.Name)
+	}
+
+	for _, update := range updates {
+		for _, component := range update.AffectedComponents {
+			addComponent(component.Name)
+		}
+		if strings.TrimSpace(update.Body) == "" {
+			continue
+		}
+
+		converted.Updates = append(converted.Updates, &util.IncidentUpdate{
+			Text:      truncate(update.Body, 1800),
+			Timestamp: updateTime(update),
+		})
+		if status, ok := MapStatus(update.Status); ok {
+			converted.Updates[len(converted.Updates)-1].Status = &status
+		}
+		if updateTime(update).After(converted.LastUpdate) {
+			converted.LastUpdate = updateTime(update)
+		}
+	}
+	if len(components) > 0 {
+		converted.Components = components
+	}
+
+	return converted, nil
+}
+
+func updateTime(update IncidentUpdate) time.Time {
+	if update.DisplayAt != nil && !update.DisplayAt.IsZero() {
+		return *update.DisplayAt
+	}
+	return update.CreatedAt
+}
+
+func truncate(text string, length int) string {
+	runes := []rune(text)
+	if len(runes) <= length {
+		return text
+	}
+	return string(runes[:length-1]) + "…"
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"pluralkit/status/statuspage"
+	"pluralkit/status/util"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const statuspageExport = `{
+	"incidents": [
+		{
+			"id": "p31zjtct2jer",
+			"name": "Bot not responding",
+			"status": "postmortem",
+			"impact": "critical",
+			"created_at": "2024-05-14T14:22:39.441-06:00",
+			"updated_at": "2024-05-14T16:00:00.000-06:00",
+			"resolved_at": "2024-05-14T15:30:00.000-06:00",
+			"components": [{"id": "1", "name": "Discord Bot", "status": "operational"}],
+			"incident_updates": [
+				{"id": "u3", "status": "resolved", "body": "this has been fixed", "created_at": "2024-05-14T15:30:00.000-06:00"},
+				{"id": "u2", "status": "identified", "body": "found the problem", "created_at": "2024-05-14T14:50:00.000-06:00",
+					"affected_components": [{"code": "2", "name": "API", "old_status": "operational", "new_status": "major_outage"}]},
+				{"id": "u1", "status": "investigating", "body": "looking into it", "created_at": "2024-05-14T14:22:39.441-06:00"}
+			]
+		},
+		{
+			"id": "k7dkc9hd3k2p",
+			"name": "Database maintenance",
+			"status": "completed",
+			"impact": "maintenance",
+			"created_at": "2024-06-01T10:00:00.000Z",
+			"incident_updates": []
+		}
+	]
+}`
+
+func TestImportStatuspage(t *testing.T) {
+	_, dbInstance, teardown := setupTestAPI(t)
+	defer teardown()
+
+	export, err := statuspage.Parse([]byte(statuspageExport))
+	require.NoError(t, err)
+	require.Len(t, export.Incidents, 2)
+
+	original := slices.Clone(export.Incidents[0].IncidentUpdates)
+	converted, err := statuspage.Convert(export.Incidents[0])
+	require.NoError(t, err)
+	assert.Equal(t, original, export.Incidents[0].IncidentUpdates) // sorting updates doesn't touch the export
+	assert.Equal(t, util.StatusResolved, converted.Status)
+	assert.Equal(t, util.ImpactMajor, converted.Impact)
+	assert.Equal(t, []string{"Discord Bot", "API"}, converted.Components)
+	require.Len(t, converted.Updates, 3)
+	assert.Equal(t, "looking into it", converted.Updates[0].Text)
+	assert.Equal(t, util.StatusInvestigating, *converted.Updates[0].Status)
+
+	maintenance, err := statuspage.Convert(export.Incidents[1])
+	require.NoError(t, err)
+	assert.Equal(t, util.StatusResolved, maintenance.Status)
+	assert.Equal(t, util.ImpactNone, maintenance.Impact)
+
+	ctx := context.Background()
+	id, created, err := dbInstance.ImportIncident(ctx, statuspage.Source, export.Incidents[0].ID, converted, false)
+	require.NoError(t, err)
+	assert.True(t, created)
+
+	incident, err := dbInstance.GetIncident(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, "Bot not responding", incident.Name)
+	assert.True(t, export.Incidents[0].CreatedAt.Equal(incident.Timestamp))
+	assert.Equal(t, time.Date(2024, 5, 14, 21, 30, 0, 0, time.UTC), incident.ResolutionTimestamp.UTC())
+	assert.Equal(t, []string{"Discord Bot", "API"}, incident.Components)
+	assert.Len(t, incident.Updates, 3)
+
+	t.Run("already imported", func(t *testing.T) {
+		existingID, created, err := dbInstance.ImportIncident(ctx, statuspage.Source, export.Incidents[0].ID, converted, false)
+		require.NoError(t, err)
+		assert.False(t, created)
+		assert.Equal(t, id, existingID)
+	})
+
+	t.Run("unresolved incidents change the status", func(t *testing.T) {
+		unresolved, err := statuspage.Convert(statuspage.Incident{
+			ID:        "x8f2lq0bmn4c",
+			Name:      "Slow commands",
+			Status:    "monitoring",
+			Impact:    "minor",
+			CreatedAt: time.Now().Add(-time.Hour),
+		})
+		require.NoError(t, err)
+
+		// dry runs don't touch it
+		_, _, err = dbInstance.ImportIncident(ctx, statuspage.Source, "x8f2lq0bmn4c", unresolved, true)
+		require.NoError(t, err)
+		status, err := dbInstance.GetStatus(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, util.StatusOperational, status.OverallStatus)
+
+		unresolvedID, created, err := dbInstance.ImportIncident(ctx, statuspage.Source, "x8f2lq0bmn4c", unresolved, false)
+		require.NoError(t, err)
+		require.True(t, created)
+		status, err = dbInstance.GetStatus(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, util.StatusDegraded, status.OverallStatus)
+		assert.Equal(t, []string{unresolvedID}, status.ActiveIncidents)
+	})
+
+	t.Run("new incidents", func(t *testing.T) {
+		newID, err := dbInstance.CreateIncident(ctx, util.Incident{Name: "new", Status: util.StatusInvestigating, Impact: util.ImpactMinor})
+		require.NoError(t, err)
+		assert.NotEqual(t, id, newID)
+	})
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"net/textproto"
+	"pluralkit/status/api"
+	"pluralkit/status/email"
+	"pluralkit/status/util"
+	"pluralkit/status/webhook"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type smtpMessage struct {
+	From string
+	To   []string
+	Data []byte
+}
+
+// a stand-in SMTP server that accepts everything and keeps the messages it receives
+type fakeSMTPServer struct {
+	listener    net.Listener
+	mutex       sync.Mutex
+	messages    []smtpMessage
+	connections int
+}
+
+func newFakeSMTPServer(t *testing.T) *fakeSMTPServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := &fakeSMTPServer{listener: listener}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			server.mutex.Lock()
+			server.connections++
+			server.mutex.Unlock()
+			go server.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { _ = listener.Close() })
+	return server
+}
+
+func (s *fakeSMTPServer) serve(conn net.Conn) {
+	tp := textproto.NewConn(conn)
+	defer tp.Close() //nolint:all
+
+	msg := smtpMessage{}
+	_ = tp.PrintfLine("220 localhost ESMTP")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			_ = tp.PrintfLine("250 localhost")
+		case strings.HasPrefix(command, "MAIL FROM:"):
+			msg = smtpMessage{From: strings.Trim(line[len("MAIL FROM:"):], "<>")}
+			_ = tp.PrintfLine("250 OK")
+		case strings.HasPrefix(command, "RCPT TO:"):
+			msg.To = append(msg.To, strings.Trim(line[len("RCPT TO:"):], "<>"))
+			_ = tp.PrintfLine("250 OK")
+		case command == "DATA":
+			_ = tp.PrintfLine("354 go ahead")
+			msg.Data, err = tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			s.mutex.Lock()
+			s.messages = append(s.messages, msg)
+			s.mutex.Unlock()
+			_ = tp.PrintfLine("250 OK")
+		case command == "QUIT":
+			_ = tp.PrintfLine("221 bye")
+			return
+		default:
+			_ = tp.PrintfLine("250 OK")
+		}
+	}
+}
+
+// returns and clears the received messages
+func (s *fakeSMTPServer) take() []smtpMessage {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	messages := s.messages
+	s.messages = nil
+	return messages
+}
+
+// parses a received message, returning its headers and decoded plain text body
+func parseEmail(t *testing.T, msg smtpMessage) (mail.Header, string) {
+	parsed, err := mail.ReadMessage(bytes.NewReader(msg.Data))
+	require.NoError(t, err)
+	mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	require.NoError(t, err)
+	require.Equal(t, "multipart/alternative", mediaType)
+
+	reader := multipart.NewReader(parsed.Body, params["boundary"])
+	var text string
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		content, err := io.ReadAll(part)
+		require.NoError(t, err)
+		if strings.HasPrefix(part.Header.Get("Content-Type"), "text/plain") {
+			text = string(content)
+		}
+	}
+	return parsed.Header, text
+}
+
+var confirmURLRegex = regexp.MustCompile(`https://status\.example\.com(/api/v1/subscriptions/confirm\?token=[0-9a-f]{64})`)
+
+func TestEmailSubscriptions(t *testing.T) {
+	database, _ := setupTestFileDB(t)
+	smtpServer := newFakeSMTPServer(t)
+
+	cfg := util.Config{
+		SMTPAddr:    smtpServer.listener.Addr().String(),
+		SMTPFrom:    "PluralKit Status <status@example.com>",
+		SMTPWorkers: 1, // keeps emails in order
+		PublicURL:   "https://status.example.com/",
+	}
+	mailer := email.NewMailer(cfg, slog.Default())
+	go mailer.Run(t.Context())
+	apiInstance := api.NewAPI(cfg, slog.Default(), database)
+	apiInstance.Mailer = mailer
+	router := chi.NewRouter()
+	router.Use(render.SetContentType(render.ContentTypeJSON))
+	apiInstance.SetupRoutes(router)
+
+	request := func(method string, path string, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// subscribes an address and follows the confirmation link
+	subscribe := func(t *testing.T, body string) {
+		rr := request(http.MethodPost, "/api/v1/subscriptions", body)
+		require.Equal(t, http.StatusAccepted, rr.Code)
+		mailer.Wait()
+		messages := smtpServer.take()
+		require.Len(t, messages, 1)
+		assert.Equal(t, "status@example.com", messages[0].From)
+
+		_, text := parseEmail(t, messages[0])
+		match := confirmURLRegex.FindStringSubmatch(text)
+		require.NotNil(t, match, text)
+		subs, err := database.GetConfirmedSubscribers(t.Context())
+		require.NoError(t, err)
+
+		// following the link only shows a form, in case something other than the subscriber opened it
+		rr = request(http.MethodGet, match[1], "")
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Contains(t, rr.Body.String(), `<form method="post">`)
+		confirmed, err := database.GetConfirmedSubscribers(t.Context())
+		require.NoError(t, err)
+		assert.Len(t, confirmed, len(subs))
+
+		rr = request(http.MethodPost, match[1], "")
+		assert.Equal(t, http.StatusOK, rr.Code)
+		confirmed, err = database.GetConfirmedSubscribers(t.Context())
+		require.NoError(t, err)
+		assert.Len(t, confirmed, len(subs)+1)
+		rr = request(http.MethodGet, match[1], "")
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+		rr = request(http.MethodPost, match[1], "")
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+	}
+
+	t.Run("invalid requests", func(t *testing.T) {
+		rr := request(http.MethodPost, "/api/v1/subscriptions", `{"email": "not an email"}`)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		rr = request(http.MethodPost, "/api/v1/subscriptions", `{"email": "a@example.com", "impacts": ["catastrophic"]}`)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		rr = request(http.MethodGet, "/api/v1/subscriptions/confirm?token=abc", "")
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		rr = request(http.MethodPost, "/api/v1/subscriptions/confirm?token=abc", "")
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		mailer.Wait()
+		assert.Empty(t, smtpServer.take())
+	})
+
+	subscribe(t, `{"email": "Major@Example.com", "impacts": ["major"]}`)
+	subscribe(t, `{"email": "everything@example.com"}`)
+
+	// confirmed addresses don't get another confirmation, and don't get their filters changed
+	rr := request(http.MethodPost, "/api/v1/subscriptions", `{"email": "major@example.com"}`)
+	assert.Equal(t, http.StatusAccepted, rr.Code)
+	mailer.Wait()
+	assert.Empty(t, smtpServer.take())
+
+	// unconfirmed addresses don't get emails, and repeated requests are rate limited
+	rr = request(http.MethodPost, "/api/v1/subscriptions", `{"email": "pending@example.com"}`)
+	assert.Equal(t, http.StatusAccepted, rr.Code)
+	rr = request(http.MethodPost, "/api/v1/subscriptions", `{"email": "pending@example.com"}`)
+	assert.Equal(t, http.StatusAccepted, rr.Code)
+	mailer.Wait()
+	assert.Len(t, smtpServer.take(), 1)
+
+	dispatcher := webhook.NewDispatcher(slog.Default(), database, webhook.NewEmailNotifier(database, mailer))
+	ctx := t.Context()
+
+	t.Run("filters", func(t *testing.T) {
+		smtpServer.mutex.Lock()
+		connections := smtpServer.connections
+		smtpServer.mutex.Unlock()
+
+		incident, _ := createTestIncident(t, database, util.ImpactMinor)
+		dispatcher.Handle(ctx, util.Event{Type: util.EventCreateIncident, Modified: incident})
+		mailer.Wait()
+		messages := smtpServer.take()
+		require.Len(t, messages, 1)
+		assert.Equal(t, []string{"everything@example.com"}, messages[0].To)
+
+		incident, update := createTestIncident(t, database, util.ImpactMajor)
+		dispatcher.Handle(ctx, util.Event{Type: util.EventCreateIncident, Modified: incident})
+		dispatcher.Handle(ctx, util.Event{Type: util.EventCreateUpdate, Modified: update})
+		dispatcher.Handle(ctx, util.Event{Type: util.EventEditIncident, Modified: incident})
+		mailer.Wait()
+		messages = smtpServer.take()
+		require.Len(t, messages, 4)
+
+		header, text := parseEmail(t, messages[0])
+		subject, err := new(mime.WordDecoder).DecodeHeader(header.Get("Subject"))
+		require.NoError(t, err)
+		assert.Equal(t, "[PluralKit Status] new incident: bot <down>", subject)
+		assert.Contains(t, text, "it's down & out")
+		assert.Contains(t, text, "https://status.example.com/i/"+incident.ID)
+
+		header, text = parseEmail(t, messages[2])
+		subject, err = new(mime.WordDecoder).DecodeHeader(header.Get("Subject"))
+		require.NoError(t, err)
+		assert.Equal(t, "[PluralKit Status] update: bot <down>", subject)
+		assert.Contains(t, text, "found it")
+
+		// the worker sent all of them over the connection it still had open from the confirmation emails
+		smtpServer.mutex.Lock()
+		assert.Equal(t, connections, smtpServer.connections)
+		smtpServer.mutex.Unlock()
+	})
+
+	t.Run("status changes", func(t *testing.T) {
+		incident, _ := createTestIncident(t, database, util.ImpactMajor)
+		resolved := util.StatusResolved
+		require.NoError(t, database.EditIncident(ctx, incident.ID, util.IncidentPatch{Status: &resolved}))
+		edited, err := database.GetIncident(ctx, incident.ID)
+		require.NoError(t, err)
+		edited.PreviousStatus = incident.Status
+		dispatcher.Handle(ctx, util.Event{Type: util.EventEditIncident, Modified: edited})
+		mailer.Wait()
+		messages := smtpServer.take()
+		require.Len(t, messages, 2)
+		header, text := parseEmail(t, messages[0])
+		subject, err := new(mime.WordDecoder).DecodeHeader(header.Get("Subject"))
+		require.NoError(t, err)
+		assert.Equal(t, "[PluralKit Status] resolved: bot <down>", subject)
+		assert.Contains(t, text, "changed to resolved")
+
+		// edits that leave the status alone aren't sent
+		edited.PreviousStatus = edited.Status
+		dispatcher.Handle(ctx, util.Event{Type: util.EventEditIncident, Modified: edited})
+		mailer.Wait()
+		assert.Empty(t, smtpServer.take())
+	})
+
+	t.Run("unsubscribe", func(t *testing.T) {
+		incident, _ := createTestIncident(t, database, util.ImpactMinor)
+		dispatcher.Handle(ctx, util.Event{Type: util.EventCreateIncident, Modified: incident})
+		mailer.Wait()
+		messages := smtpServer.take()
+		require.Len(t, messages, 1)
+
+		header, _ := parseEmail(t, messages[0])
+		assert.Equal(t, "List-Unsubscribe=One-Click", header.Get("List-Unsubscribe-Post"))
+		unsubscribeURL := strings.Trim(header.Get("List-Unsubscribe"), "<>")
+		require.True(t, strings.HasPrefix(unsubscribeURL, "https://status.example.com/api/v1/subscriptions/unsubscribe?token="))
+		path := strings.TrimPrefix(unsubscribeURL, "https://status.example.com")
+
+		// following the link only shows a form, in case something other than the subscriber opened it
+		rr := request(http.MethodGet, path, "")
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Contains(t, rr.Body.String(), `<form method="post">`)
+		subs, err := database.GetConfirmedSubscribers(ctx)
+		require.NoError(t, err)
+		assert.Len(t, subs, 2)
+
+		rr = request(http.MethodPost, path, "List-Unsubscribe=One-Click")
+		assert.Equal(t, http.StatusOK, rr.Code)
+		rr = request(http.MethodGet, path, "")
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+		rr = request(http.MethodPost, path, "")
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+		subs, err = database.GetConfirmedSubscribers(ctx)
+		require.NoError(t, err)
+		assert.Len(t, subs, 1)
+
+		dispatcher.Handle(ctx, util.Event{Type: util.EventCreateIncident, Modified: incident})
+		mailer.Wait()
+		assert.Empty(t, smtpServer.take())
+	})
+}
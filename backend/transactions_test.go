@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"pluralkit/status/db"
+	"pluralkit/status/util"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateUpdateTransaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "status.db")
+	dbLoc := fmt.Sprintf("file:%s?_foreign_keys=on", path)
+	eventChannel := make(chan util.Event, 10)
+	database := db.NewDB(util.Config{DBLoc: dbLoc, LogLevel: util.SlogLevel(slog.LevelError)}, slog.Default(), eventChannel)
+	require.NotNil(t, database)
+	defer database.CloseDB() //nolint:all
+
+	ctx := context.Background()
+	incidentID, err := database.CreateIncident(ctx, util.Incident{Name: "incident", Status: util.StatusInvestigating, Impact: util.ImpactMinor})
+	require.NoError(t, err)
+	event := <-eventChannel
+	assert.Equal(t, util.EventCreateIncident, event.Type)
+
+	status := util.StatusResolved
+	t.Run("invalid update", func(t *testing.T) {
+		_, err := database.CreateUpdate(ctx, util.IncidentUpdate{IncidentID: incidentID, Text: strings.Repeat("a", 1801), Status: &status})
+		assert.ErrorIs(t, err, util.ErrInvalid)
+		assert.Len(t, eventChannel, 0)
+	})
+
+	t.Run("missing incident", func(t *testing.T) {
+		_, err := database.CreateUpdate(ctx, util.IncidentUpdate{IncidentID: "asdfasdf", Text: "update", Status: &status})
+		assert.ErrorIs(t, err, util.ErrNotFound)
+		assert.Len(t, eventChannel, 0)
+	})
+
+	t.Run("incident update fails", func(t *testing.T) {
+		// make the second statement in the transaction fail
+		raw, err := sql.Open("sqlite3", dbLoc)
+		require.NoError(t, err)
+		defer raw.Close() //nolint:all
+		_, err = raw.Exec("CREATE TRIGGER fail_incident_update BEFORE UPDATE ON incidents BEGIN SELECT RAISE(ABORT, 'nope'); END")
+		require.NoError(t, err)
+
+		_, err = database.CreateUpdate(ctx, util.IncidentUpdate{IncidentID: incidentID, Text: "update", Status: &status})
+		assert.Error(t, err)
+		assert.Len(t, eventChannel, 0)
+
+		incident, err := database.GetIncident(ctx, incidentID)
+		require.NoError(t, err)
+		assert.Empty(t, incident.Updates)
+		assert.Equal(t, util.StatusInvestigating, incident.Status)
+
+		_, err = raw.Exec("DROP TRIGGER fail_incident_update")
+		require.NoError(t, err)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		id, err := database.CreateUpdate(ctx, util.IncidentUpdate{IncidentID: incidentID, Text: "update", Status: &status})
+		require.NoError(t, err)
+		require.Len(t, eventChannel, 1)
+		event := <-eventChannel
+		assert.Equal(t, util.EventCreateUpdate, event.Type)
+		assert.Equal(t, id, event.Modified.(util.IncidentUpdate).ID)
+
+		incident, err := database.GetIncident(ctx, incidentID)
+		require.NoError(t, err)
+		assert.Len(t, incident.Updates, 1)
+		assert.Equal(t, util.StatusResolved, incident.Status)
+		assert.False(t, incident.ResolutionTimestamp.IsZero())
+	})
+}
@@ -0,0 +1,134 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// a webhook notification from alertmanager, see https://prometheus.io/docs/alerting/latest/configuration/#webhook_config
+type AlertmanagerPayload struct {
+	Version           string              `json:"version"`
+	GroupKey          string              `json:"groupKey" validate:"required"`
+	Status            string              `json:"status" validate:"required,oneof=firing resolved"`
+	Receiver          string              `json:"receiver"`
+	GroupLabels       map[string]string   `json:"groupLabels"`
+	CommonLabels      map[string]string   `json:"commonLabels"`
+	CommonAnnotations map[string]string   `json:"commonAnnotations"`
+	ExternalURL       string              `json:"externalURL"`
+	Alerts            []AlertmanagerAlert `json:"alerts" validate:"dive"`
+}
+
+type AlertmanagerAlert struct {
+	Status      string            `json:"status" validate:"required,oneof=firing resolved"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	Fingerprint string            `json:"fingerprint"`
+}
+
+// identifies the alert within its group, labels are used if alertmanager didn't send a fingerprint
+func (a *AlertmanagerAlert) Key() string {
+	if a.Fingerprint != "" {
+		return a.Fingerprint
+	}
+	labels, _ := json.Marshal(a.Labels) // map keys are sorted, so this is stable
+	return string(labels)
+}
+
+// a short readable name for the alert, e.g. "HighLatency (api-1)"
+func (a *AlertmanagerAlert) Name() string {
+	name := a.Labels["alertname"]
+	if name == "" {
+		name = "Unnamed alert"
+	}
+	if instance := a.Labels["instance"]; instance != "" {
+		name += " (" + instance + ")"
+	}
+	return name
+}
+
+// maps alert groups to incidents by their labels
+type AlertRule struct {
+	Matchers   map[string]string `json:"matchers"`                            // label values the group's common labels must fully match, as regular expressions
+	Components []string          `json:"components" validate:"dive,required"` // components the incident affects
+	Impact     Impact            `json:"impact" validate:"omitempty,impact"`  // overrides the impact from severity labels
+
+	Drop bool `json:"drop"` // ignore matching groups
+
+	matchers map[string]*regexp.Regexp
+}
+
+func (r *AlertRule) matches(labels map[string]string) bool {
+	for label, matcher := range r.matchers {
+		// like alertmanager, a missing label matches an empty value
+		if !matcher.MatchString(labels[label]) {
+			return false
+		}
+	}
+	return true
+}
+
+// rules for alert groups, parsed from a JSON array. rules are checked in order and the first matching one decides
+type AlertRules []AlertRule
+
+func (r *AlertRules) UnmarshalText(text []byte) error {
+	var rules []AlertRule
+	err := json.Unmarshal(text, &rules)
+	if err != nil {
+		return err
+	}
+	err = Validate.Var(rules, "dive")
+	if err != nil {
+		return err
+	}
+	for i := range rules {
+		rules[i].matchers = make(map[string]*regexp.Regexp)
+		for label, value := range rules[i].Matchers {
+			matcher, err := regexp.Compile("^(?:" + value + ")$")
+			if err != nil {
+				return fmt.Errorf("invalid matcher for label %q: %w", label, err)
+			}
+			rules[i].matchers[label] = matcher
+		}
+	}
+	*r = rules
+	return nil
+}
+
+// finds the rule for a group's labels. without any rules every group is accepted, otherwise groups no rule matches are ignored
+func (r AlertRules) Match(labels map[string]string) (AlertRule, bool) {
+	if len(r) == 0 {
+		return AlertRule{}, true
+	}
+	for _, rule := range r {
+		if rule.matches(labels) {
+			return rule, !rule.Drop
+		}
+	}
+	return AlertRule{}, false
+}
+
+// incident impacts for alert severity labels, parsed from e.g. "critical:major,warning:minor"
+type AlertSeverities map[string]Impact
+
+func (s *AlertSeverities) UnmarshalText(text []byte) error {
+	severities := make(AlertSeverities)
+	for _, pair := range strings.Split(string(text), ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		severity, impact, ok := strings.Cut(pair, ":")
+		if !ok {
+			return fmt.Errorf("invalid alert severity %q", pair)
+		}
+		severities[strings.TrimSpace(severity)] = Impact(strings.TrimSpace(impact))
+	}
+	for severity, impact := range severities {
+		if !impact.IsValid() {
+			return fmt.Errorf("invalid impact for alert severity %q", severity)
+		}
+	}
+	*s = severities
+	return nil
+}
@@ -0,0 +1,108 @@
+package util
+
+import (
+	"encoding/json"
+	"errors"
+	"slices"
+)
+
+// a rule deciding whether a notifier target gets an event, and whether it should mention anyone.
+// matchers that are left empty match everything
+type NotificationRule struct {
+	Targets    []string         `json:"targets" validate:"dive,required"` // notifier platforms, e.g. "slack" or "discord:maintenance"
+	Events     []EventType      `json:"events" validate:"dive,eventtype"`
+	Impacts    []Impact         `json:"impacts" validate:"dive,impact"`
+	Components []string         `json:"components" validate:"dive,required"`     // matches incidents affecting any of these
+	Statuses   []IncidentStatus `json:"statuses" validate:"dive,incidentstatus"` // the incident's status after the event
+
+	Drop    bool  `json:"drop"`    // don't send anything to the target
+	Mention *bool `json:"mention"` // overrides whether the target mentions its role, if it has one
+}
+
+func (r *NotificationRule) matches(target string, event EventType, incident Incident) bool {
+	if len(r.Targets) > 0 && !slices.Contains(r.Targets, target) {
+		return false
+	}
+	if len(r.Events) > 0 && !slices.Contains(r.Events, event) {
+		return false
+	}
+	if len(r.Impacts) > 0 && !slices.Contains(r.Impacts, incident.Impact) {
+		return false
+	}
+	if len(r.Statuses) > 0 && !slices.Contains(r.Statuses, incident.Status) {
+		return false
+	}
+	if len(r.Components) > 0 && !slices.ContainsFunc(incident.Components, func(c string) bool {
+		return slices.Contains(r.Components, c)
+	}) {
+		return false
+	}
+	return true
+}
+
+// routing rules for notifications, parsed from a JSON array. rules are checked in order and the first matching one decides
+type NotificationRules []NotificationRule
+
+func (r *NotificationRules) UnmarshalText(text []byte) error {
+	var rules []NotificationRule
+	err := json.Unmarshal(text, &rules)
+	if err != nil {
+		return err
+	}
+	err = Validate.Var(rules, "dive")
+	if err != nil {
+		return err
+	}
+	*r = rules
+	return nil
+}
+
+// what should happen with an event for a notifier target
+type Route struct {
+	Drop    bool
+	Mention bool
+}
+
+// routes an event about an incident to a target, mention is what the target does if no rule says otherwise
+func (r NotificationRules) Route(target string, event EventType, incident Incident, mention bool) Route {
+	for _, rule := range r {
+		if !rule.matches(target, event, incident) {
+			continue
+		}
+		route := Route{Drop: rule.Drop, Mention: mention && !rule.Drop}
+		if rule.Mention != nil {
+			route.Mention = *rule.Mention && !rule.Drop
+		}
+		return route
+	}
+	return Route{Mention: mention}
+}
+
+// an additional discord webhook notifications can be routed to
+type DiscordTarget struct {
+	Webhook    string            `json:"webhook" validate:"required,url"`
+	Role       string            `json:"role"`
+	ThreadMode DiscordThreadMode `json:"thread_mode"`
+}
+
+// additional discord webhooks by name, parsed from a JSON object
+type DiscordTargets map[string]DiscordTarget
+
+func (t *DiscordTargets) UnmarshalText(text []byte) error {
+	var targets map[string]DiscordTarget
+	err := json.Unmarshal(text, &targets)
+	if err != nil {
+		return err
+	}
+	for name, target := range targets {
+		if name == "" {
+			return errors.New("discord target names can't be empty")
+		}
+		err = Validate.Struct(target)
+		if err != nil {
+			return err
+		}
+	}
+	*t = targets
+	return nil
+}
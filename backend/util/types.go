@@ -4,6 +4,7 @@ import (
 	"log/slog"
 	"net/http"
 	"os"
+	"slices"
 	"strings"
 	"time"
 
@@ -32,6 +33,11 @@ func init() {
 		slog.Error("error in init", slog.Any("error", err))
 		os.Exit(1)
 	}
+	err = Validate.RegisterValidation("eventtype", validateEventType)
+	if err != nil {
+		slog.Error("error in init", slog.Any("error", err))
+		os.Exit(1)
+	}
 }
 
 func validateSqid(fl validator.FieldLevel) bool {
@@ -73,6 +79,13 @@ func validateIncidentStatus(fl validator.FieldLevel) bool {
 	return false
 }
 
+func validateEventType(fl validator.FieldLevel) bool {
+	if eventType, ok := fl.Field().Interface().(EventType); ok {
+		return eventType.IsValid()
+	}
+	return false
+}
+
 /* Incidents + Status =-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=- */
 
 // a type representing the impact of an incident or event
@@ -145,6 +158,8 @@ type IncidentUpdate struct {
 	Timestamp time.Time       `json:"timestamp" bun:"timestamp,notnull,default:current_timestamp"`
 
 	IncidentID string `json:"-" bun:"incident_id,notnull"`
+	// the incident's status before this update, only set on updates in create_update events
+	PreviousStatus IncidentStatus `json:"-" bun:"-"`
 }
 
 // helper struct for update patching
@@ -168,16 +183,20 @@ type Incident struct {
 	Impact              Impact         `json:"impact" bun:"impact" validate:"required,impact"`
 	Name                string         `json:"name" bun:"name,notnull" validate:"required,max=100"`
 	Description         string         `json:"description" bun:"description" validate:"max=1800"`
+	Components          []string       `json:"components,omitempty" bun:"components" validate:"max=25,dive,required,max=100"`
 
 	Updates []*IncidentUpdate `json:"updates" bun:"rel:has-many,join:id=incident_id"  validate:"dive"`
+	// the incident's status before it was edited, only set on incidents in edit_incident events
+	PreviousStatus IncidentStatus `json:"-" bun:"-"`
 }
 
 // helper struct for incident patching
 type IncidentPatch struct {
-	Name        *string         `json:"name" validate:"max=100"`
-	Description *string         `json:"description" validate:"max=1800"`
-	Status      *IncidentStatus `json:"status" validate:"incidentstatus"`
-	Impact      *Impact         `json:"impact" validate:"impact"`
+	Name        *string         `json:"name" validate:"omitnil,max=100"`
+	Description *string         `json:"description" validate:"omitnil,max=1800"`
+	Status      *IncidentStatus `json:"status" validate:"omitnil,incidentstatus"`
+	Impact      *Impact         `json:"impact" validate:"omitnil,impact"`
+	Components  *[]string       `json:"components" validate:"omitempty,max=25,dive,required,max=100"`
 }
 
 // render helper function for Incident
@@ -201,12 +220,323 @@ type Status struct {
 // render helper function for Status
 func (s *Status) Render(w http.ResponseWriter, r *http.Request) error { return nil }
 
+// works out the overall status from the incidents that aren't resolved, the highest impact decides
+func StatusFor(active []Incident) Status {
+	status := Status{
+		OverallStatus:   StatusOperational,
+		ActiveIncidents: make([]string, 0, len(active)),
+	}
+	highestImpact := ImpactNone
+	for _, incident := range active {
+		status.ActiveIncidents = append(status.ActiveIncidents, incident.ID)
+		if incident.Impact.IsGreater(highestImpact) {
+			highestImpact = incident.Impact
+		}
+	}
+	slices.Sort(status.ActiveIncidents)
+
+	switch highestImpact {
+	case ImpactMajor:
+		status.OverallStatus = StatusMajorOutage
+	case ImpactMinor:
+		status.OverallStatus = StatusDegraded
+	}
+	return status
+}
+
 type StatusWrapper struct {
 	bun.BaseModel `bun:"table:status"`
 	ID            int    `bun:",pk"`
 	Status        Status `json:"status"`
 }
 
+/* Subscriptions =-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=- */
+
+// an email address subscribed to incident notifications
+type Subscriber struct {
+	bun.BaseModel `bun:"table:subscribers,alias:sub"`
+
+	ID                 int64     `json:"-" bun:"id,pk,autoincrement"`
+	Email              string    `json:"email" bun:"email,notnull,unique"`
+	Impacts            []Impact  `json:"impacts,omitempty" bun:"impacts"`       // only notify for these impacts, all if empty
+	Components         []string  `json:"components,omitempty" bun:"components"` // only notify for incidents affecting these components, all if empty
+	Confirmed          bool      `json:"confirmed" bun:"confirmed,notnull,default:false"`
+	ConfirmToken       string    `json:"-" bun:"confirm_token,nullzero,unique"`
+	UnsubscribeToken   string    `json:"-" bun:"unsubscribe_token,notnull,unique"`
+	CreatedAt          time.Time `json:"created_at" bun:"created_at,nullzero,notnull,default:current_timestamp"`
+	ConfirmationSentAt time.Time `json:"-" bun:"confirmation_sent_at,nullzero"`
+}
+
+// returns true if the subscriber wants to be notified about this incident.
+// incidents without any components count as affecting everything
+func (s *Subscriber) Wants(incident Incident) bool {
+	if len(s.Impacts) > 0 && !slices.Contains(s.Impacts, incident.Impact) {
+		return false
+	}
+	if len(s.Components) > 0 && len(incident.Components) > 0 {
+		for _, component := range incident.Components {
+			if slices.Contains(s.Components, component) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// helper struct for new subscriptions
+type SubscribeRequest struct {
+	Email      string   `json:"email" validate:"required,email,max=254"`
+	Impacts    []Impact `json:"impacts" validate:"max=3,dive,impact"`
+	Components []string `json:"components" validate:"max=25,dive,required,max=100"`
+}
+
+/* Webhook Endpoints =-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=- */
+
+// an external endpoint that gets a signed JSON payload for every event
+type WebhookEndpoint struct {
+	bun.BaseModel `bun:"table:webhook_endpoints,alias:we"`
+
+	ID                  int64       `json:"id" bun:"id,pk,autoincrement"`
+	URL                 string      `json:"url" bun:"url,notnull" validate:"required,url,startswith=https://,max=2000"`
+	Secret              string      `json:"secret,omitempty" bun:"secret,notnull"`                   // only included in responses when the endpoint is created
+	Events              []EventType `json:"events,omitempty" bun:"events" validate:"dive,eventtype"` // only send these events, all if empty
+	Enabled             bool        `json:"enabled" bun:"enabled,notnull,default:true"`
+	ConsecutiveFailures int         `json:"consecutive_failures" bun:"consecutive_failures,notnull,default:0"`
+	CreatedAt           time.Time   `json:"created_at" bun:"created_at,nullzero,notnull,default:current_timestamp"`
+}
+
+// returns true if this endpoint should get the given event
+func (e *WebhookEndpoint) Wants(eventType EventType) bool {
+	return e.Enabled && (len(e.Events) == 0 || slices.Contains(e.Events, eventType))
+}
+
+// helper struct for patching webhook endpoints, re-enabling an endpoint resets its failure count
+type WebhookEndpointPatch struct {
+	URL     *string      `json:"url" validate:"omitempty,url,startswith=https://,max=2000"`
+	Events  *[]EventType `json:"events" validate:"omitempty,dive,eventtype"`
+	Enabled *bool        `json:"enabled"`
+}
+
+// a single attempt at delivering an event to a webhook endpoint
+type WebhookDelivery struct {
+	bun.BaseModel `bun:"table:webhook_deliveries,alias:wd"`
+
+	ID         int64     `json:"id" bun:"id,pk,autoincrement"`
+	EndpointID int64     `json:"endpoint_id" bun:"endpoint_id,notnull"`
+	Event      EventType `json:"event" bun:"event,notnull"`
+	Timestamp  time.Time `json:"timestamp" bun:"timestamp,notnull"`
+	StatusCode int       `json:"status_code,omitempty" bun:"status_code"`
+	Duration   int64     `json:"duration_ms" bun:"duration_ms"`
+	Error      string    `json:"error,omitempty" bun:"error"`
+	Success    bool      `json:"success" bun:"success,notnull"`
+	Attempt    int       `json:"attempt" bun:"attempt,notnull,default:1"`
+	Retried    bool      `json:"retried,omitempty" bun:"retried,notnull,default:false"` // failed, but was queued to be tried again
+}
+
+// the body sent to webhook endpoints
+type WebhookPayload struct {
+	Event     EventType `json:"event"`
+	Timestamp time.Time `json:"timestamp"`
+	Data      any       `json:"data"`
+}
+
+// the data sent for update events, updates don't include their incident's ID on their own
+type WebhookUpdate struct {
+	IncidentUpdate
+	IncidentID string `json:"incident_id"`
+}
+
+/* Shards =-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=- */
+
+// a change in how PluralKit's shards are split into clusters, recorded by the shard poller.
+// the first change recorded has no previous topology, it's the baseline later polls are compared to
+type TopologyChange struct {
+	bun.BaseModel `bun:"table:shard_topology_changes,alias:stc"`
+
+	ID                   int64     `json:"id" bun:"id,pk,autoincrement"`
+	Timestamp            time.Time `json:"timestamp" bun:"timestamp,notnull"`
+	NumShards            int       `json:"num_shards" bun:"num_shards,notnull"`
+	ClusterSizes         []int     `json:"cluster_sizes" bun:"cluster_sizes"`
+	PreviousShards       int       `json:"previous_shards" bun:"previous_shards"`
+	PreviousClusterSizes []int     `json:"previous_cluster_sizes" bun:"previous_cluster_sizes"`
+}
+
+/* Monitors =-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=- */
+
+type MonitorState string
+
+const (
+	MonitorPending MonitorState = "pending" // created but not pinged yet
+	MonitorUp      MonitorState = "up"
+	MonitorDown    MonitorState = "down"
+)
+
+// a push monitor for a service outside of discord, which is down if it isn't pinged every interval (plus grace)
+type Monitor struct {
+	bun.BaseModel `bun:"table:monitors,alias:mon"`
+
+	ID           int64        `json:"id" bun:"id,pk,autoincrement"`
+	Name         string       `json:"name" bun:"name,notnull" validate:"required,max=90"`        // leaves room for " is down" in incident names
+	Component    string       `json:"component,omitempty" bun:"component" validate:"max=100"`    // added to incidents opened for this monitor
+	Interval     int          `json:"interval" bun:"interval,notnull" validate:"required,min=1"` // seconds between pings
+	Grace        int          `json:"grace" bun:"grace,notnull" validate:"min=0"`                // extra seconds allowed for late pings
+	AutoIncident bool         `json:"auto_incident" bun:"auto_incident,notnull"`                 // open an incident while it's down, and resolve it once it's back up
+	Impact       Impact       `json:"impact" bun:"impact" validate:"omitempty,impact"`           // of those incidents, minor by default
+	Token        string       `json:"token,omitempty" bun:"token,notnull,unique"`                // only included in responses when the monitor is created
+	PingURL      string       `json:"ping_url,omitempty" bun:"-"`
+	State        MonitorState `json:"state" bun:"state,notnull"`
+	LastPing     time.Time    `json:"last_ping" bun:"last_ping,nullzero"`
+	IncidentID   string       `json:"incident_id,omitempty" bun:"incident_id"` // the incident opened for the current outage
+	CreatedAt    time.Time    `json:"created_at" bun:"created_at,nullzero,notnull,default:current_timestamp"`
+}
+
+// when the monitor goes down if it isn't pinged again
+func (m *Monitor) Deadline() time.Time {
+	last := m.LastPing
+	if last.IsZero() {
+		last = m.CreatedAt
+	}
+	return last.Add(time.Duration(m.Interval+m.Grace) * time.Second)
+}
+
+// helper struct for patching monitors
+type MonitorPatch struct {
+	Name         *string `json:"name" validate:"omitnil,min=1,max=90"`
+	Component    *string `json:"component" validate:"omitnil,max=100"`
+	Interval     *int    `json:"interval" validate:"omitnil,min=1"`
+	Grace        *int    `json:"grace" validate:"omitnil,min=0"`
+	AutoIncident *bool   `json:"auto_incident"`
+	Impact       *Impact `json:"impact" validate:"omitnil,impact"`
+}
+
+type ProbeType string
+
+const (
+	ProbeHTTP ProbeType = "http" // the target is a url
+	ProbeTCP  ProbeType = "tcp"  // the target is a host:port to connect to
+	ProbeDNS  ProbeType = "dns"  // the target is a hostname to resolve
+)
+
+// a synthetic check the backend runs itself every interval, which is down after enough failed checks in a row
+type Probe struct {
+	bun.BaseModel `bun:"table:probes,alias:prb"`
+
+	ID                  int64        `json:"id" bun:"id,pk,autoincrement"`
+	Name                string       `json:"name" bun:"name,notnull" validate:"required,max=90"`
+	Component           string       `json:"component,omitempty" bun:"component" validate:"max=100"`
+	Type                ProbeType    `json:"type" bun:"type,notnull" validate:"required,oneof=http tcp dns"`
+	Target              string       `json:"target" bun:"target,notnull" validate:"required,max=2000"`
+	Method              string       `json:"method,omitempty" bun:"method" validate:"omitempty,oneof=GET HEAD POST"`               // http only, GET by default
+	ExpectedStatus      int          `json:"expected_status,omitempty" bun:"expected_status" validate:"omitempty,min=100,max=599"` // http only, 200 by default
+	Expect              string       `json:"expect,omitempty" bun:"expect" validate:"max=1000"`                                    // text the http body has to contain, or an address the dns name has to resolve to
+	MaxLatency          int          `json:"max_latency,omitempty" bun:"max_latency" validate:"min=0"`                             // milliseconds, slower checks fail
+	Interval            int          `json:"interval" bun:"interval,notnull" validate:"required,min=1"`                            // seconds between checks
+	Timeout             int          `json:"timeout" bun:"timeout,notnull" validate:"min=0,max=60"`                                // seconds per attempt, 10 by default
+	Retries             int          `json:"retries" bun:"retries,notnull" validate:"min=0,max=5"`                                 // extra attempts before a check fails
+	FailureThreshold    int          `json:"failure_threshold" bun:"failure_threshold,notnull" validate:"min=0,max=100"`           // failed checks in a row before it's down, 3 by default
+	AutoIncident        bool         `json:"auto_incident" bun:"auto_incident,notnull"`
+	Impact              Impact       `json:"impact" bun:"impact" validate:"omitempty,impact"`
+	State               MonitorState `json:"state" bun:"state,notnull"`
+	ConsecutiveFailures int          `json:"consecutive_failures" bun:"consecutive_failures,notnull,default:0"`
+	LastCheck           time.Time    `json:"last_check" bun:"last_check,nullzero"`
+	LastError           string       `json:"last_error,omitempty" bun:"last_error"`
+	IncidentID          string       `json:"incident_id,omitempty" bun:"incident_id"`
+	CreatedAt           time.Time    `json:"created_at" bun:"created_at,nullzero,notnull,default:current_timestamp"`
+}
+
+// checks the target fits the probe's type
+func (p *Probe) ValidTarget() bool {
+	switch p.Type {
+	case ProbeHTTP:
+		return Validate.Var(p.Target, "url,startswith=http") == nil
+	case ProbeTCP:
+		return Validate.Var(p.Target, "hostname_port") == nil
+	case ProbeDNS:
+		return Validate.Var(p.Target, "hostname_rfc1123") == nil
+	}
+	return false
+}
+
+// helper struct for patching probes, the type can't be changed
+type ProbePatch struct {
+	Name             *string `json:"name" validate:"omitnil,min=1,max=90"`
+	Component        *string `json:"component" validate:"omitnil,max=100"`
+	Target           *string `json:"target" validate:"omitnil,min=1,max=2000"`
+	Method           *string `json:"method" validate:"omitnil,oneof=GET HEAD POST"`
+	ExpectedStatus   *int    `json:"expected_status" validate:"omitnil,min=100,max=599"`
+	Expect           *string `json:"expect" validate:"omitnil,max=1000"`
+	MaxLatency       *int    `json:"max_latency" validate:"omitnil,min=0"`
+	Interval         *int    `json:"interval" validate:"omitnil,min=1"`
+	Timeout          *int    `json:"timeout" validate:"omitnil,min=1,max=60"`
+	Retries          *int    `json:"retries" validate:"omitnil,min=0,max=5"`
+	FailureThreshold *int    `json:"failure_threshold" validate:"omitnil,min=1,max=100"`
+	AutoIncident     *bool   `json:"auto_incident"`
+	Impact           *Impact `json:"impact" validate:"omitnil,impact"`
+}
+
+// the outcome of one check of a probe, including its retries
+type ProbeResult struct {
+	bun.BaseModel `bun:"table:probe_results,alias:pr"`
+
+	ID         int64     `json:"id" bun:"id,pk,autoincrement"`
+	ProbeID    int64     `json:"probe_id" bun:"probe_id,notnull"`
+	Timestamp  time.Time `json:"timestamp" bun:"timestamp,notnull"`
+	Success    bool      `json:"success" bun:"success,notnull"`
+	Attempts   int       `json:"attempts" bun:"attempts,notnull"`
+	Latency    int64     `json:"latency_ms" bun:"latency_ms"` // of the last attempt
+	StatusCode int       `json:"status_code,omitempty" bun:"status_code"`
+	Error      string    `json:"error,omitempty" bun:"error"`
+}
+
+// what's shown publicly about a probe, targets and errors can include internal hostnames and addresses
+type PublicProbe struct {
+	ID        int64        `json:"id"`
+	Name      string       `json:"name"`
+	Component string       `json:"component,omitempty"`
+	Type      ProbeType    `json:"type"`
+	State     MonitorState `json:"state"`
+	LastCheck time.Time    `json:"last_check"`
+}
+
+func (p *Probe) Public() PublicProbe {
+	return PublicProbe{
+		ID:        p.ID,
+		Name:      p.Name,
+		Component: p.Component,
+		Type:      p.Type,
+		State:     p.State,
+		LastCheck: p.LastCheck,
+	}
+}
+
+// the monitors and probes for one component, for showing on the status page
+type ComponentChecks struct {
+	Component string        `json:"component"`
+	State     MonitorState  `json:"state"` // the worst state of its monitors and probes
+	Monitors  []Monitor     `json:"monitors"`
+	Probes    []PublicProbe `json:"probes"`
+}
+
+/* Alertmanager =-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=- */
+
+// an alertmanager alert group and the incident opened for it, so repeated notifications for a group don't open new incidents
+type AlertGroup struct {
+	bun.BaseModel `bun:"table:alert_groups,alias:ag"`
+
+	GroupKey   string            `json:"group_key" bun:"group_key,pk"`
+	IncidentID string            `json:"incident_id" bun:"incident_id,notnull"`
+	Alerts     map[string]string `json:"alerts" bun:"alerts"` // names of the alerts that were firing in the last notification, by key
+	Impact     Impact            `json:"impact" bun:"impact"`
+	UpdatedAt  time.Time         `json:"updated_at" bun:"updated_at,nullzero,notnull,default:current_timestamp"`
+}
+
+// what the alertmanager receiver did with a notification
+type AlertmanagerResult struct {
+	Action     string `json:"action"` // "created", "updated", "resolved" or "ignored"
+	IncidentID string `json:"incident_id,omitempty"`
+}
+
 /* Misc =-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=- */
 
 // a type representing possible internal events
@@ -219,17 +549,134 @@ const (
 	EventEditUpdate     EventType = "edit_update"
 	EventDeleteIncident EventType = "delete_incident"
 	EventDeleteUpdate   EventType = "delete_update"
+	EventStatusChange   EventType = "status_change" // the overall status or list of active incidents changed
 )
 
+func (e EventType) IsValid() bool {
+	switch e {
+	case EventCreateIncident, EventCreateUpdate, EventEditIncident, EventEditUpdate,
+		EventDeleteIncident, EventDeleteUpdate, EventStatusChange:
+		return true
+	}
+	return false
+}
+
 // helper struct for internal events
 type Event struct {
 	Type     EventType
 	Modified any
 }
+
+// a message sent by a notifier for an incident or update, so it can be edited later
 type WebhookMessage struct {
 	bun.BaseModel `bun:"table:webhook_messages,alias:msg"`
 
-	ID        string `bun:"id,pk"`
-	Type      string `bun:"type"`
-	MessageID int64  `bun:"message_id"`
+	ID        string `json:"id" bun:"id,pk"`
+	Platform  string `json:"platform" bun:"platform,pk"`
+	Type      string `json:"type" bun:"type"`
+	MessageID int64  `json:"message_id,omitempty" bun:"message_id"` // for platforms with numeric message IDs
+	Ref       string `json:"ref,omitempty" bun:"ref"`               // for everything else, e.g. slack message timestamps
+	ChannelID string `json:"channel_id,omitempty" bun:"channel_id"` // channel the message was sent in, if the platform needs it for edits
+}
+
+// monotonic counter used to allocate IDs, so IDs are never reused even after the newest row is deleted
+type IDSequence struct {
+	bun.BaseModel `bun:"table:id_sequences"`
+
+	Name  string `bun:"name,pk"`
+	Value int64  `bun:"value,notnull"` // the next value to hand out
+}
+
+// tracks incidents imported from other status pages, so re-running an import doesn't duplicate them
+type ImportedIncident struct {
+	bun.BaseModel `bun:"table:imported_incidents,alias:imp"`
+
+	Source     string `json:"source" bun:"source,pk"`
+	SourceID   string `json:"source_id" bun:"source_id,pk"`
+	IncidentID string `json:"incident_id" bun:"incident_id,notnull"`
+}
+
+/* Archives =-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=- */
+
+// current version of the archive format, bump this if the format changes in a non-compatible way
+const ArchiveVersion = 1
+
+// portable dump of all stored status data, used for backups and moving between storage backends.
+// webhook delivery logs, probe results and shard topology changes are only history and aren't included.
+// this includes webhook secrets, monitor tokens and subscriber emails, so archives should be kept private
+type Archive struct {
+	Version           int                  `json:"version"`
+	ExportedAt        time.Time            `json:"exported_at"`
+	Status            Status               `json:"status"`
+	Incidents         []Incident           `json:"incidents"`
+	WebhookMessages   []WebhookMessage     `json:"webhook_messages"`
+	Subscribers       []ArchivedSubscriber `json:"subscribers"`
+	WebhookEndpoints  []WebhookEndpoint    `json:"webhook_endpoints"`
+	Monitors          []Monitor            `json:"monitors"`
+	Probes            []Probe              `json:"probes"`
+	AlertGroups       []AlertGroup         `json:"alert_groups"`
+	ImportedIncidents []ImportedIncident   `json:"imported_incidents"`
+}
+
+// a subscriber as stored in archives, including the tokens already sent out in their links
+type ArchivedSubscriber struct {
+	Subscriber
+	ID                 int64     `json:"id"`
+	ConfirmToken       string    `json:"confirm_token,omitempty"`
+	UnsubscribeToken   string    `json:"unsubscribe_token"`
+	ConfirmationSentAt time.Time `json:"confirmation_sent_at,omitzero"`
+}
+
+// the subscriber to put into an archive
+func ArchiveSubscriber(sub Subscriber) ArchivedSubscriber {
+	return ArchivedSubscriber{
+		Subscriber:         sub,
+		ID:                 sub.ID,
+		ConfirmToken:       sub.ConfirmToken,
+		UnsubscribeToken:   sub.UnsubscribeToken,
+		ConfirmationSentAt: sub.ConfirmationSentAt,
+	}
+}
+
+// the subscriber to store when importing an archive
+func (a ArchivedSubscriber) Unarchive() Subscriber {
+	sub := a.Subscriber
+	sub.ID = a.ID
+	sub.ConfirmToken = a.ConfirmToken
+	sub.UnsubscribeToken = a.UnsubscribeToken
+	sub.ConfirmationSentAt = a.ConfirmationSentAt
+	return sub
+}
+
+// render helper function for Archive
+func (a *Archive) Render(w http.ResponseWriter, r *http.Request) error { return nil }
+
+// options for importing an archive
+type ImportOptions struct {
+	DryRun        bool // validate and check for conflicts, but don't write anything
+	SkipConflicts bool // skip records that already exist instead of aborting the import
+}
+
+// a single record in an archive that conflicts with already stored data
+type ImportConflict struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
 }
+
+// summary of an archive import
+type ImportResult struct {
+	DryRun            bool             `json:"dry_run"`
+	Incidents         int              `json:"incidents"`
+	Updates           int              `json:"updates"`
+	WebhookMessages   int              `json:"webhook_messages"`
+	Subscribers       int              `json:"subscribers"`
+	WebhookEndpoints  int              `json:"webhook_endpoints"`
+	Monitors          int              `json:"monitors"`
+	Probes            int              `json:"probes"`
+	AlertGroups       int              `json:"alert_groups"`
+	ImportedIncidents int              `json:"imported_incidents"`
+	Conflicts         []ImportConflict `json:"conflicts"`
+}
+
+// render helper function for ImportResult
+func (i *ImportResult) Render(w http.ResponseWriter, r *http.Request) error { return nil }
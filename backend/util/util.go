@@ -1,12 +1,16 @@
 package util
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
 	"log/slog"
+	"time"
 )
 
 var ErrNotFound = errors.New("resource not found")
 var ErrInvalid = errors.New("invalid struct/type data")
+var ErrConflict = errors.New("resource already exists")
 
 type SlogLevel slog.Level
 
@@ -26,14 +30,125 @@ func (l *SlogLevel) UnmarshalText(text []byte) error {
 	return nil
 }
 
+// how discord notifiers group updates into threads
+type DiscordThreadMode string
+
+const (
+	DiscordThreadsOff     DiscordThreadMode = ""
+	DiscordThreadsForum   DiscordThreadMode = "forum"   // the webhook posts to a forum channel, each incident is a new post
+	DiscordThreadsMessage DiscordThreadMode = "message" // a thread is started on each incident message, this needs a bot token
+)
+
+func (m *DiscordThreadMode) UnmarshalText(text []byte) error {
+	mode := DiscordThreadMode(text)
+	switch mode {
+	case DiscordThreadsOff, DiscordThreadsForum, DiscordThreadsMessage:
+		*m = mode
+		return nil
+	}
+	return errors.New("invalid discord thread mode")
+}
+
+// thresholds for classifying shard and cluster health, so every consumer shares one definition
+type HealthThresholds struct {
+	HeartbeatTimeout time.Duration `env:"heartbeat_timeout" envDefault:"10m"` // shards without a heartbeat for this long are down
+	ReconnectGrace   time.Duration `env:"reconnect_grace" envDefault:"10s"`   // shards that reconnected this recently count as up
+	DegradedLatency  int           `env:"degraded_latency" envDefault:"200"`  // milliseconds
+	SevereLatency    int           `env:"severe_latency" envDefault:"400"`    // milliseconds
+	ClusterUpRatio   float64       `env:"cluster_up_ratio" envDefault:"0.5"`  // clusters are down unless more than this share of their shards are up
+}
+
+// thresholds for flagging flapping shards and clusters, and reconnect storms
+type AnomalyThresholds struct {
+	Window           time.Duration `env:"window" envDefault:"10m"`              // how far back reconnects are counted
+	FlapReconnects   int           `env:"flap_reconnects" envDefault:"3"`       // shards reconnecting this often within the window are flapping
+	FlapStateChanges int           `env:"flap_state_changes" envDefault:"4"`    // as are shards going up or down this often
+	ClusterFlapRatio float64       `env:"cluster_flap_ratio" envDefault:"0.25"` // clusters are flapping once this share of their shards are
+	StormRatio       float64       `env:"storm_ratio" envDefault:"0.2"`         // it's a reconnect storm once this share of all shards reconnected within the window
+	StormIncident    bool          `env:"storm_incident" envDefault:"false"`    // open a minor incident when a reconnect storm starts
+}
+
+// an upstream shard state endpoint, e.g. for one bot instance or region
+type ShardSource struct {
+	Name     string `json:"name" validate:"required"`
+	Endpoint string `json:"endpoint" validate:"required,url"`
+}
+
+// shard state endpoints in display order, parsed from a JSON array
+type ShardSources []ShardSource
+
+func (s *ShardSources) UnmarshalText(text []byte) error {
+	var sources []ShardSource
+	err := json.Unmarshal(text, &sources)
+	if err != nil {
+		return err
+	}
+	names := make(map[string]bool)
+	for _, source := range sources {
+		err = Validate.Struct(source)
+		if err != nil {
+			return err
+		}
+		if names[source.Name] {
+			return fmt.Errorf("duplicate shard source %q", source.Name)
+		}
+		names[source.Name] = true
+	}
+	*s = sources
+	return nil
+}
+
 type Config struct {
-	BindAddr            string    `env:"pluralkit__status__addr" envDefault:"0.0.0.0:8080"`
-	ShardsEndpoint      string    `env:"pluralkit__status__shards_endpoint" envDefault:"https://api.pluralkit.me/private/discord/shard_state"`
-	MaxConcurrency      int       `env:"pluralkit__status__max_concurrency" envDefault:"16"`
-	AuthToken           string    `env:"pluralkit__status__auth_token"`
-	NotificationWebhook string    `env:"pluralkit__status__notification_webhook"`
-	NotificationRole    string    `env:"pluralkit__status__notification_role"`
-	RunDev              bool      `env:"pluralkit__status__run_dev" envDefault:"false"`
-	DBLoc               string    `env:"pluralkit__status__db_location" envDefault:"file:status.db?_foreign_keys=on"`
-	LogLevel            SlogLevel `env:"pluralkit__consoleloglevel" envDefault:"info"`
+	BindAddr            string            `env:"pluralkit__status__addr" envDefault:"0.0.0.0:8080"`
+	ShardsEndpoint      string            `env:"pluralkit__status__shards_endpoint" envDefault:"https://api.pluralkit.me/private/discord/shard_state"`
+	ShardsSources       ShardSources      `env:"pluralkit__status__shards_sources"`
+	MaxConcurrency      int               `env:"pluralkit__status__max_concurrency" envDefault:"16"`
+	ShardsPollInterval  time.Duration     `env:"pluralkit__status__shards_poll_interval" envDefault:"10s"`
+	ShardsRateWindow    time.Duration     `env:"pluralkit__status__shards_rate_window" envDefault:"10m"`
+	Health              HealthThresholds  `envPrefix:"pluralkit__status__health_"`
+	Anomalies           AnomalyThresholds `envPrefix:"pluralkit__status__anomalies_"`
+	MonitorInterval     time.Duration     `env:"pluralkit__status__monitor_interval" envDefault:"10s"`
+	AlertRules          AlertRules        `env:"pluralkit__status__alertmanager_rules"`
+	AlertSeverities     AlertSeverities   `env:"pluralkit__status__alertmanager_severities" envDefault:"critical:major,warning:minor,info:none"`
+	AuthToken           string            `env:"pluralkit__status__auth_token"`
+	NotificationWebhook string            `env:"pluralkit__status__notification_webhook"`
+	NotificationRole    string            `env:"pluralkit__status__notification_role"`
+	NotificationRules   NotificationRules `env:"pluralkit__status__notification_rules"`
+	DiscordTargets      DiscordTargets    `env:"pluralkit__status__discord_targets"`
+	DiscordThreadMode   DiscordThreadMode `env:"pluralkit__status__discord_thread_mode"`
+	DiscordBotToken     string            `env:"pluralkit__status__discord_bot_token"`
+	DiscordAPIURL       string            `env:"pluralkit__status__discord_api_url" envDefault:"https://discord.com/api/v10"`
+	DiscordTimeout      time.Duration     `env:"pluralkit__status__discord_timeout" envDefault:"10s"`
+	DiscordPublicKey    string            `env:"pluralkit__status__discord_public_key"`
+	DiscordAppID        string            `env:"pluralkit__status__discord_app_id"`
+	DiscordStaffRoles   []string          `env:"pluralkit__status__discord_staff_roles" envSeparator:","`
+	DiscordButtons      bool              `env:"pluralkit__status__discord_buttons" envDefault:"false"`
+	SlackWebhook        string            `env:"pluralkit__status__slack_webhook"`
+	SlackToken          string            `env:"pluralkit__status__slack_token"`
+	SlackChannel        string            `env:"pluralkit__status__slack_channel"`
+	SlackAPIURL         string            `env:"pluralkit__status__slack_api_url" envDefault:"https://slack.com/api"`
+	MatrixHomeserver    string            `env:"pluralkit__status__matrix_homeserver"`
+	MatrixToken         string            `env:"pluralkit__status__matrix_token"`
+	MatrixRoom          string            `env:"pluralkit__status__matrix_room"`
+	TelegramToken       string            `env:"pluralkit__status__telegram_token"`
+	TelegramChat        string            `env:"pluralkit__status__telegram_chat"`
+	TelegramAPIURL      string            `env:"pluralkit__status__telegram_api_url" envDefault:"https://api.telegram.org"`
+	MastodonInstance    string            `env:"pluralkit__status__mastodon_instance"`
+	MastodonToken       string            `env:"pluralkit__status__mastodon_token"`
+	MastodonVisibility  string            `env:"pluralkit__status__mastodon_visibility" envDefault:"public"`
+	MastodonMaxChars    int               `env:"pluralkit__status__mastodon_max_chars" envDefault:"500"`
+	SMTPAddr            string            `env:"pluralkit__status__smtp_addr"`
+	SMTPUsername        string            `env:"pluralkit__status__smtp_username"`
+	SMTPPassword        string            `env:"pluralkit__status__smtp_password"`
+	SMTPFrom            string            `env:"pluralkit__status__smtp_from"`
+	SMTPWorkers         int               `env:"pluralkit__status__smtp_workers" envDefault:"4"`
+	PublicURL           string            `env:"pluralkit__status__public_url" envDefault:"https://status.pluralkit.me"`
+	WebhookMaxFailures  int               `env:"pluralkit__status__webhook_max_failures" envDefault:"10"`
+	WebhookWorkers      int               `env:"pluralkit__status__webhook_workers" envDefault:"4"`
+	RunDev              bool              `env:"pluralkit__status__run_dev" envDefault:"false"`
+	DBLoc               string            `env:"pluralkit__status__db_location" envDefault:"file:status.db?_foreign_keys=on"`
+	BackupDir           string            `env:"pluralkit__status__backup_dir"`
+	BackupInterval      time.Duration     `env:"pluralkit__status__backup_interval" envDefault:"6h"`
+	BackupRetention     int               `env:"pluralkit__status__backup_retention" envDefault:"7"`
+	LogLevel            SlogLevel         `env:"pluralkit__consoleloglevel" envDefault:"info"`
 }
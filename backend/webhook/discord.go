@@ -1,92 +1,147 @@
 package webhook
 
 import (
-	"encoding/json"
-	"errors"
+	"context"
 	"fmt"
 	"net/http"
 	"pluralkit/status/util"
 	"strconv"
 	"strings"
+	"time"
 )
 
+// notifications are sent one after another from the event loop, so a request that's still waiting
+// on rate limits after this long gives up instead of holding up every other notifier
+const discordNotifyTimeout = 15 * time.Second
+
 type DiscordWebhook struct {
+	platform   string
 	url        string
 	notifRole  string
-	httpClient *http.Client
+	rules      util.NotificationRules
+	threadMode util.DiscordThreadMode
+	apiURL     string
+	botToken   string
+	buttons    bool
+	client     *DiscordClient
 }
 
-func NewDiscordWebhook(config util.Config) *DiscordWebhook {
+// client is shared with everything else talking to discord, so they all respect the same rate limits
+func NewDiscordWebhook(config util.Config, client *DiscordClient) *DiscordWebhook {
 	return &DiscordWebhook{
+		platform:   "discord",
 		url:        config.NotificationWebhook,
 		notifRole:  config.NotificationRole,
-		httpClient: &http.Client{},
+		rules:      config.NotificationRules,
+		threadMode: config.DiscordThreadMode,
+		apiURL:     strings.TrimSuffix(config.DiscordAPIURL, "/"),
+		botToken:   config.DiscordBotToken,
+		buttons:    config.DiscordButtons,
+		client:     client,
+	}
+}
+
+// creates a notifier for one of the additional discord webhooks, which routing rules refer to as "discord:name"
+func NewDiscordTarget(config util.Config, name string, target util.DiscordTarget, client *DiscordClient) *DiscordWebhook {
+	return &DiscordWebhook{
+		platform:   "discord:" + name,
+		url:        target.Webhook,
+		notifRole:  target.Role,
+		rules:      config.NotificationRules,
+		threadMode: target.ThreadMode,
+		apiURL:     strings.TrimSuffix(config.DiscordAPIURL, "/"),
+		botToken:   config.DiscordBotToken,
+		buttons:    config.DiscordButtons,
+		client:     client,
 	}
 }
 
+// whether to ping the role, edits check the same rules as the original message so the text stays the same
+func (dw *DiscordWebhook) mention(event util.EventType, incident util.Incident) bool {
+	return dw.notifRole != "" && dw.rules.Route(dw.platform, event, incident, true).Mention
+}
+
 type DiscordResponse struct {
-	ID string `json:"id"`
+	ID        string `json:"id"`
+	ChannelID string `json:"channel_id"`
 }
 
-func (dw *DiscordWebhook) send(content string) (int64, error) {
-	url := fmt.Sprintf("%s?with_components=true&wait=true", dw.url)
-	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(content))
-	if err != nil {
-		return 0, err
-	}
-	req.Header.Set("Content-Type", "application/json")
-	resp, err := dw.httpClient.Do(req)
-	if err != nil {
-		return 0, err
-	} else if resp.StatusCode != 200 {
-		return 0, errors.New("error while sending webhook")
+// builds the url for a webhook request, threadID is only needed for messages inside threads
+func (dw *DiscordWebhook) webhookURL(path string, threadID string) string {
+	url := fmt.Sprintf("%s%s?with_components=true&wait=true", dw.url, path)
+	if threadID != "" {
+		url += "&thread_id=" + threadID
 	}
+	return url
+}
 
+func (dw *DiscordWebhook) send(msg Message, threadID string) (DiscordResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), discordNotifyTimeout)
+	defer cancel()
 	data := DiscordResponse{}
-	_ = json.NewDecoder(resp.Body).Decode(&data)
-	num, _ := strconv.ParseInt(data.ID, 10, 64)
+	err := dw.client.Do(ctx, http.MethodPost, dw.webhookURL("", threadID), nil, msg, &data)
+	return data, err
+}
 
-	err = resp.Body.Close()
-	return num, err
+func (dw *DiscordWebhook) edit(msgID int64, threadID string, msg Message) error {
+	ctx, cancel := context.WithTimeout(context.Background(), discordNotifyTimeout)
+	defer cancel()
+	return dw.client.Do(ctx, http.MethodPatch, dw.webhookURL(fmt.Sprintf("/messages/%d", msgID), threadID), nil, msg, nil)
 }
 
-func (dw *DiscordWebhook) edit(msgID int64, content string) error {
-	url := fmt.Sprintf("%s/messages/%d?with_components=true&wait=true", dw.url, msgID)
-	req, err := http.NewRequest(http.MethodPatch, url, strings.NewReader(content))
-	if err != nil {
-		return err
+// starts a thread on a message, webhooks can't do this themselves so it goes through the bot
+func (dw *DiscordWebhook) startThread(channelID string, messageID string, name string) (string, error) {
+	header := http.Header{}
+	header.Set("Authorization", "Bot "+dw.botToken)
+	body := map[string]any{
+		"name":                  name,
+		"auto_archive_duration": 10080,
 	}
-	req.Header.Set("Content-Type", "application/json")
-	resp, err := dw.httpClient.Do(req)
-	if err != nil {
-		return err
-	} else if resp.StatusCode != 200 {
-		return errors.New("error while editing webhook")
+
+	ctx, cancel := context.WithTimeout(context.Background(), discordNotifyTimeout)
+	defer cancel()
+	data := DiscordResponse{}
+	url := fmt.Sprintf("%s/channels/%s/messages/%s/threads", dw.apiURL, channelID, messageID)
+	err := dw.client.Do(ctx, http.MethodPost, url, header, body, &data)
+	return data.ID, err
+}
+
+// the thread an incident message lives in, forum posts are inside their own thread
+func (dw *DiscordWebhook) incidentThread(msg util.WebhookMessage) string {
+	if dw.threadMode == util.DiscordThreadsForum {
+		return msg.ChannelID
 	}
+	return ""
+}
 
-	err = resp.Body.Close()
-	return err
+// a summary of the incident's updates, shown on the incident message in thread mode
+func threadSummary(incident util.Incident) string {
+	if len(incident.Updates) == 0 {
+		return ""
+	}
+	count := "1 update"
+	if len(incident.Updates) > 1 {
+		count = fmt.Sprintf("%d updates", len(incident.Updates))
+	}
+	latest := incident.Updates[0]
+	for _, update := range incident.Updates {
+		if update.Timestamp.After(latest.Timestamp) {
+			latest = update
+		}
+	}
+	return fmt.Sprintf("-# %s in the thread · last update <t:%d:R>", count, latest.Timestamp.Unix())
 }
 
 func (dw *DiscordWebhook) genIncidentMessage(incident util.Incident) Message {
 	var mentions *AllowedMentions = nil
 	notifText := "new incident:"
-	if dw.notifRole != "" {
+	if dw.mention(util.EventCreateIncident, incident) {
 		notifText = fmt.Sprintf("<@&%s> new incident:", dw.notifRole)
 		mentions = &AllowedMentions{
 			Roles: []string{dw.notifRole},
 		}
 	}
-	color := 0
-	switch incident.Impact {
-	case util.ImpactMinor:
-		color = 0xfcb700
-	case util.ImpactMajor:
-		color = 0xff637d
-	default:
-		color = 0x99c1f1
-	}
-	return Message{
+	msg := Message{
 		Components: []ComponentBase{
 			{
 				Type:    int(TextDisplay),
@@ -94,7 +149,7 @@ func (dw *DiscordWebhook) genIncidentMessage(incident util.Incident) Message {
 			},
 			{
 				Type:        int(Container),
-				AccentColor: color,
+				AccentColor: impactColor(incident.Impact),
 				Components: []ComponentBase{
 					{
 						Type:    int(TextDisplay),
@@ -129,12 +184,41 @@ func (dw *DiscordWebhook) genIncidentMessage(incident util.Incident) Message {
 		Flags:           int(ComponentsV2),
 		AllowedMentions: mentions,
 	}
+	if dw.threadMode != util.DiscordThreadsOff {
+		if summary := threadSummary(incident); summary != "" {
+			msg.Components = append(msg.Components, ComponentBase{
+				Type:    int(TextDisplay),
+				Content: summary,
+			})
+		}
+	}
+	if dw.buttons && incident.Status != util.StatusResolved {
+		// handled by the interactions endpoint, discord only shows these if the webhook belongs to our application
+		msg.Components = append(msg.Components, ComponentBase{
+			Type: int(ActionRow),
+			Components: []ComponentBase{
+				{
+					Type:     int(Button),
+					Style:    int(ButtonSecondary),
+					Label:    "Post update",
+					CustomID: IncidentButtonID("update", incident.ID),
+				},
+				{
+					Type:     int(Button),
+					Style:    int(ButtonSuccess),
+					Label:    "Resolve",
+					CustomID: IncidentButtonID("resolve", incident.ID),
+				},
+			},
+		})
+	}
+	return msg
 }
 
 func (dw *DiscordWebhook) genUpdateMessage(incident util.Incident, update util.IncidentUpdate) Message {
 	var mentions *AllowedMentions = nil
 	notifText := "new status update:"
-	if dw.notifRole != "" {
+	if dw.mention(util.EventCreateUpdate, incident) {
 		notifText = fmt.Sprintf("<@&%s> new status update:", dw.notifRole)
 		mentions = &AllowedMentions{
 			Roles: []string{dw.notifRole},
@@ -144,18 +228,7 @@ func (dw *DiscordWebhook) genUpdateMessage(incident util.Incident, update util.I
 	if update.Status != nil {
 		nameText = fmt.Sprintf("## update: %s\n-# **status:** *%s*", incident.Name, incident.Status)
 	}
-	color := 0
-	switch incident.Impact {
-	case util.ImpactMinor:
-		color = 0xfcb700
-	case util.ImpactMajor:
-		color = 0xff637d
-	default:
-		color = 0x99c1f1
-	}
-	if update.Status != nil && *update.Status == util.StatusResolved {
-		color = 0x00d390
-	}
+	color := updateColor(incident, update)
 	msg := Message{
 		Components: []ComponentBase{
 			{
@@ -202,40 +275,63 @@ func (dw *DiscordWebhook) genUpdateMessage(incident util.Incident, update util.I
 	return msg
 }
 
-func (dw *DiscordWebhook) SendIncident(incident util.Incident) (int64, error) {
-	content, err := json.Marshal(dw.genIncidentMessage(incident))
-	if err != nil {
-		return 0, err
-	}
-	id, err := dw.send(string(content))
-	return id, err
+func (dw *DiscordWebhook) Platform() string {
+	return dw.platform
 }
 
-func (dw *DiscordWebhook) SendUpdate(incident util.Incident, update util.IncidentUpdate) (int64, error) {
-	content, err := json.Marshal(dw.genUpdateMessage(incident, update))
+func (dw *DiscordWebhook) SendIncident(incident util.Incident) (util.WebhookMessage, error) {
+	msg := dw.genIncidentMessage(incident)
+	if dw.threadMode == util.DiscordThreadsForum {
+		msg.ThreadName = incident.Name
+	}
+	resp, err := dw.send(msg, "")
 	if err != nil {
-		return 0, err
+		return util.WebhookMessage{}, err
 	}
-	id, err := dw.send(string(content))
-	return id, err
-}
+	id, _ := strconv.ParseInt(resp.ID, 10, 64)
+	sent := util.WebhookMessage{MessageID: id}
 
-func (dw *DiscordWebhook) EditIncident(msgID int64, incident util.Incident) error {
-	content, err := json.Marshal(dw.genIncidentMessage(incident))
-	if err != nil {
-		return err
+	switch dw.threadMode {
+	case util.DiscordThreadsForum:
+		// forum posts are threads of their own, so the message is in a new channel
+		sent.ChannelID = resp.ChannelID
+	case util.DiscordThreadsMessage:
+		threadID, err := dw.startThread(resp.ChannelID, resp.ID, incident.Name)
+		if err != nil {
+			// the message was still sent, updates just won't be threaded
+			return sent, err
+		}
+		sent.ChannelID = threadID
 	}
-	err = dw.edit(msgID, string(content))
-	return err
+	return sent, nil
 }
 
-func (dw *DiscordWebhook) EditUpdate(msgID int64, incident util.Incident, update util.IncidentUpdate) error {
-	content, err := json.Marshal(dw.genUpdateMessage(incident, update))
+func (dw *DiscordWebhook) SendUpdate(parent util.WebhookMessage, incident util.Incident, update util.IncidentUpdate) (util.WebhookMessage, error) {
+	threadID := ""
+	if dw.threadMode != util.DiscordThreadsOff {
+		threadID = parent.ChannelID
+	}
+	resp, err := dw.send(dw.genUpdateMessage(incident, update), threadID)
 	if err != nil {
-		return err
+		return util.WebhookMessage{}, err
+	}
+	id, _ := strconv.ParseInt(resp.ID, 10, 64)
+	sent := util.WebhookMessage{MessageID: id, ChannelID: threadID}
+
+	if threadID != "" && parent.MessageID != 0 {
+		// updates are tucked away in the thread, so keep the incident message up to date
+		err = dw.EditIncident(parent, incident)
 	}
-	err = dw.edit(msgID, string(content))
-	return err
+	return sent, err
+}
+
+func (dw *DiscordWebhook) EditIncident(msg util.WebhookMessage, incident util.Incident) error {
+	return dw.edit(msg.MessageID, dw.incidentThread(msg), dw.genIncidentMessage(incident))
+}
+
+func (dw *DiscordWebhook) EditUpdate(msg util.WebhookMessage, incident util.Incident, update util.IncidentUpdate) error {
+	// updates are sent into the thread in ChannelID, if they were threaded
+	return dw.edit(msg.MessageID, msg.ChannelID, dw.genUpdateMessage(incident, update))
 }
 
 // component/helper types below
@@ -248,6 +344,7 @@ type AllowedMentions struct {
 }
 
 type Message struct {
+	ThreadName      string           `json:"thread_name,omitempty"`
 	Flags           int              `json:"flags,omitempty"`
 	Components      []ComponentBase  `json:"components,omitempty"`
 	AllowedMentions *AllowedMentions `json:"allowed_mentions,omitempty"`
@@ -268,6 +365,17 @@ type ComponentBase struct {
 	AccentColor int             `json:"accent_color,omitempty"`
 	Spoiler     *bool           `json:"spoiler,omitempty"`
 	Components  []ComponentBase `json:"components,omitempty"`
+
+	//Button / Text Input
+	Style    int    `json:"style,omitempty"`
+	Label    string `json:"label,omitempty"`
+	CustomID string `json:"custom_id,omitempty"`
+
+	//Text Input
+	Placeholder string `json:"placeholder,omitempty"`
+	Value       string `json:"value,omitempty"`
+	Required    *bool  `json:"required,omitempty"`
+	MaxLength   int    `json:"max_length,omitempty"`
 }
 
 // *sigh* thanks golang
@@ -278,6 +386,9 @@ func boolPtr(b bool) *bool {
 type ComponentType int
 
 const (
+	ActionRow   ComponentType = 1
+	Button      ComponentType = 2
+	TextInput   ComponentType = 4
 	TextDisplay ComponentType = 10
 	Seperator   ComponentType = 14
 	Container   ComponentType = 17
@@ -286,5 +397,20 @@ const (
 type MessageFlags int
 
 const (
+	Ephemeral    MessageFlags = (1 << 6)
 	ComponentsV2 MessageFlags = (1 << 15)
 )
+
+type ButtonStyle int
+
+const (
+	ButtonSecondary ButtonStyle = 2
+	ButtonSuccess   ButtonStyle = 3
+)
+
+type TextInputStyle int
+
+const (
+	TextInputShort     TextInputStyle = 1
+	TextInputParagraph TextInputStyle = 2
+)
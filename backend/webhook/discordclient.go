@@ -0,0 +1,267 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"pluralkit/status/util"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// give up instead of waiting longer than this for a rate limit, so one bucket can't hold up notifications forever
+const maxRateLimitWait = time.Minute
+
+// how many times a request is retried after hitting a rate limit
+const maxRateLimitRetries = 3
+
+// DiscordError is an error response from the discord api, see https://discord.com/developers/docs/topics/opcodes-and-status-codes#json
+type DiscordError struct {
+	Status  int             `json:"-"`
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Errors  json.RawMessage `json:"errors,omitempty"`
+}
+
+func (e *DiscordError) Error() string {
+	text := fmt.Sprintf("discord api error %d (status %d): %s", e.Code, e.Status, e.Message)
+	if len(e.Errors) > 0 {
+		text += " " + string(e.Errors)
+	}
+	return text
+}
+
+// a rate limit bucket, requests in the same bucket are sent one at a time
+type discordBucket struct {
+	queue     sync.Mutex
+	remaining int
+	reset     time.Time
+}
+
+// DiscordClient sends requests to the discord api (including webhooks) while respecting rate limits
+type DiscordClient struct {
+	httpClient *http.Client
+
+	mutex       sync.Mutex
+	routes      map[string]string         // route -> bucket hash discord told us about
+	buckets     map[string]*discordBucket // bucket hash (or route, until we know the hash) -> bucket
+	globalReset time.Time
+}
+
+func NewDiscordClient(config util.Config) *DiscordClient {
+	timeout := config.DiscordTimeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	return &DiscordClient{
+		httpClient: &http.Client{Timeout: timeout},
+		routes:     make(map[string]string),
+		buckets:    make(map[string]*discordBucket),
+	}
+}
+
+// rate limits are per route, where IDs other than channels/guilds/webhooks don't make a difference
+func discordRoute(method string, rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return method + " " + rawURL
+	}
+	segments := strings.Split(parsed.Path, "/")
+	for i, segment := range segments {
+		if i == 0 || segment == "" || strings.Trim(segment, "0123456789") != "" {
+			continue
+		}
+		switch segments[i-1] {
+		case "channels", "guilds", "webhooks":
+		default:
+			segments[i] = ":id"
+		}
+	}
+	return method + " " + strings.Join(segments, "/")
+}
+
+func (c *DiscordClient) bucket(route string) *discordBucket {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	key := route
+	if hash, ok := c.routes[route]; ok {
+		key = hash
+	}
+	bucket, ok := c.buckets[key]
+	if !ok {
+		bucket = &discordBucket{remaining: 1}
+		c.buckets[key] = bucket
+	}
+	return bucket
+}
+
+// remembers which bucket a route belongs to, so routes sharing a bucket also share a queue from now on
+func (c *DiscordClient) learnBucket(route string, hash string, bucket *discordBucket) {
+	if hash == "" {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.routes[route] == hash {
+		return
+	}
+	c.routes[route] = hash
+	if _, ok := c.buckets[hash]; !ok {
+		c.buckets[hash] = bucket
+	}
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	if d > maxRateLimitWait {
+		return fmt.Errorf("rate limited for %s, not waiting that long", d)
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Now().Add(d).After(deadline) {
+		// fail right away instead of sleeping until the deadline only to give up then
+		return fmt.Errorf("rate limited for %s, past the request's deadline", d)
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// parses a number of seconds like "1.5" from a rate limit header
+func parseSeconds(value string) time.Duration {
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// sends a request, waiting for rate limits and retrying when discord says we hit one.
+// body is sent as JSON if it isn't nil, and the response is decoded into out if it isn't nil
+func (c *DiscordClient) Do(ctx context.Context, method string, reqURL string, header http.Header, body any, out any) error {
+	var content []byte
+	if body != nil {
+		var err error
+		content, err = json.Marshal(body)
+		if err != nil {
+			return err
+		}
+	}
+
+	route := discordRoute(method, reqURL)
+	bucket := c.bucket(route)
+	bucket.queue.Lock()
+	defer bucket.queue.Unlock()
+
+	for attempt := 0; ; attempt++ {
+		c.mutex.Lock()
+		globalWait := time.Until(c.globalReset)
+		c.mutex.Unlock()
+		err := sleepCtx(ctx, globalWait)
+		if err != nil {
+			return err
+		}
+		if bucket.remaining <= 0 {
+			err = sleepCtx(ctx, time.Until(bucket.reset))
+			if err != nil {
+				return err
+			}
+		}
+
+		resp, err := c.send(ctx, method, reqURL, header, content)
+		if err != nil {
+			return err
+		}
+		data, err := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		if remaining := resp.Header.Get("X-RateLimit-Remaining"); remaining != "" {
+			bucket.remaining, _ = strconv.Atoi(remaining)
+			bucket.reset = time.Now().Add(parseSeconds(resp.Header.Get("X-RateLimit-Reset-After")))
+		} else {
+			bucket.remaining = 1
+		}
+		c.learnBucket(route, resp.Header.Get("X-RateLimit-Bucket"), bucket)
+
+		if resp.StatusCode == http.StatusTooManyRequests {
+			limit := struct {
+				RetryAfter float64 `json:"retry_after"`
+				Global     bool    `json:"global"`
+			}{}
+			_ = json.Unmarshal(data, &limit)
+			retryAfter := time.Duration(limit.RetryAfter * float64(time.Second))
+			if retryAfter <= 0 {
+				retryAfter = parseSeconds(resp.Header.Get("Retry-After"))
+			}
+			if limit.Global || resp.Header.Get("X-RateLimit-Global") == "true" {
+				c.mutex.Lock()
+				c.globalReset = time.Now().Add(retryAfter)
+				c.mutex.Unlock()
+			} else {
+				bucket.remaining = 0
+				bucket.reset = time.Now().Add(retryAfter)
+			}
+			if attempt >= maxRateLimitRetries {
+				return fmt.Errorf("still rate limited after %d retries", attempt)
+			}
+			continue
+		}
+
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			discordErr := &DiscordError{Status: resp.StatusCode}
+			if json.Unmarshal(data, discordErr) != nil || discordErr.Message == "" {
+				discordErr.Message = http.StatusText(resp.StatusCode)
+			}
+			return discordErr
+		}
+
+		if out != nil && len(data) > 0 {
+			return json.Unmarshal(data, out)
+		}
+		return nil
+	}
+}
+
+func (c *DiscordClient) send(ctx context.Context, method string, reqURL string, header http.Header, content []byte) (*http.Response, error) {
+	var reader io.Reader
+	if content != nil {
+		reader = bytes.NewReader(content)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, reqURL, reader)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if content != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("User-Agent", "DiscordBot (https://github.com/PluralKit/statuspage, 1.0)")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		// webhook urls contain their token, don't pass it on in logs
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return nil, fmt.Errorf("error while sending discord request: %w", urlErr.Err)
+		}
+		return nil, err
+	}
+	return resp, nil
+}
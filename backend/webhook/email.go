@@ -0,0 +1,77 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"pluralkit/status/db"
+	"pluralkit/status/email"
+	"pluralkit/status/util"
+)
+
+// EmailNotifier emails confirmed subscribers about new incidents, updates and status changes.
+// emails are only queued here and sent in the background by the mailer, so lots of subscribers don't hold up events.
+// emails can't be edited once sent, so other edits are ignored
+type EmailNotifier struct {
+	database *db.DB
+	mailer   *email.Mailer
+}
+
+func NewEmailNotifier(database *db.DB, mailer *email.Mailer) *EmailNotifier {
+	return &EmailNotifier{
+		database: database,
+		mailer:   mailer,
+	}
+}
+
+func (en *EmailNotifier) Platform() string {
+	return "email"
+}
+
+// calls queue for every subscriber that wants to hear about this incident
+func (en *EmailNotifier) broadcast(incident util.Incident, queue func(sub util.Subscriber) error) error {
+	subs, err := en.database.GetConfirmedSubscribers(context.Background())
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, sub := range subs {
+		if !sub.Wants(incident) {
+			continue
+		}
+		err := queue(sub)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error while queueing email for subscriber %d: %w", sub.ID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (en *EmailNotifier) SendIncident(incident util.Incident) (util.WebhookMessage, error) {
+	return util.WebhookMessage{}, en.broadcast(incident, func(sub util.Subscriber) error {
+		return en.mailer.QueueIncident(sub, incident)
+	})
+}
+
+func (en *EmailNotifier) SendUpdate(parent util.WebhookMessage, incident util.Incident, update util.IncidentUpdate) (util.WebhookMessage, error) {
+	return util.WebhookMessage{}, en.broadcast(incident, func(sub util.Subscriber) error {
+		return en.mailer.QueueUpdate(sub, incident, update)
+	})
+}
+
+// sends a status change made by editing the incident like an update, e.g. when it's resolved without one
+func (en *EmailNotifier) SendStatusChange(parent util.WebhookMessage, incident util.Incident) error {
+	update := statusChangeUpdate(incident)
+	return en.broadcast(incident, func(sub util.Subscriber) error {
+		return en.mailer.QueueUpdate(sub, incident, update)
+	})
+}
+
+func (en *EmailNotifier) EditIncident(msg util.WebhookMessage, incident util.Incident) error {
+	return nil
+}
+
+func (en *EmailNotifier) EditUpdate(msg util.WebhookMessage, incident util.Incident, update util.IncidentUpdate) error {
+	return nil
+}
@@ -0,0 +1,273 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"pluralkit/status/db"
+	"pluralkit/status/util"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Deliverer posts every event as a signed JSON payload to the webhook endpoints registered by admins.
+// deliveries are queued and sent in the background, so slow endpoints don't hold up events
+type Deliverer struct {
+	logger      *slog.Logger
+	database    *db.DB
+	maxFailures int
+	workers     int
+
+	// exported so tests can swap in a client that trusts their TLS certificates
+	HTTPClient *http.Client
+	// how long to wait before each retry of a failed delivery, exported so tests can shorten them
+	RetryDelays []time.Duration
+
+	mutex   sync.Mutex
+	pending []queuedDelivery
+	sending int           // deliveries taken from the queue that haven't been sent yet
+	empty   *sync.Cond    // broadcast when nothing is pending or being sent
+	wake    chan struct{} // signalled when deliveries are queued
+}
+
+// a payload waiting to be sent to an endpoint
+type queuedDelivery struct {
+	endpoint util.WebhookEndpoint
+	event    util.EventType
+	payload  []byte
+	attempt  int       // starting at 1
+	due      time.Time // retries wait until then
+}
+
+func NewDeliverer(config util.Config, logger *slog.Logger, database *db.DB) *Deliverer {
+	moduleLogger := logger.With(slog.String("module", "webhook endpoints"))
+	workers := config.WebhookWorkers
+	if workers <= 0 {
+		workers = 4
+	}
+	dl := &Deliverer{
+		logger:      moduleLogger,
+		database:    database,
+		maxFailures: config.WebhookMaxFailures,
+		workers:     workers,
+		HTTPClient: &http.Client{
+			Timeout: 10 * time.Second,
+			// a redirect counts as a failed delivery, we only ever send to the registered URL
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		RetryDelays: []time.Duration{time.Second, 5 * time.Second},
+		wake:        make(chan struct{}, 1),
+	}
+	dl.empty = sync.NewCond(&dl.mutex)
+	return dl
+}
+
+// Sign returns the signature header value for a payload. receivers should compute the same value
+// from the X-Status-Timestamp header and the raw body, and reject requests with old timestamps
+func Sign(secret string, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = fmt.Fprintf(mac, "%d.", timestamp)
+	_, _ = mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// queues the event for every endpoint that wants it, they're sent once Run is going
+func (dl *Deliverer) HandleEvent(ctx context.Context, event util.Event) {
+	endpoints, err := dl.database.GetEndpoints(ctx)
+	if err != nil {
+		dl.logger.Error("error while getting webhook endpoints", slog.Any("error", err))
+		return
+	}
+
+	var payload []byte
+	for _, endpoint := range endpoints {
+		if !endpoint.Wants(event.Type) {
+			continue
+		}
+		if payload == nil {
+			payload, err = json.Marshal(util.WebhookPayload{
+				Event:     event.Type,
+				Timestamp: time.Now().UTC(),
+				Data:      payloadData(event.Modified),
+			})
+			if err != nil {
+				dl.logger.Error("error while marshalling webhook payload", slog.Any("error", err))
+				return
+			}
+		}
+		dl.enqueue(queuedDelivery{endpoint: endpoint, event: event.Type, payload: payload, attempt: 1})
+	}
+}
+
+func (dl *Deliverer) enqueue(queued queuedDelivery) {
+	dl.mutex.Lock()
+	dl.pending = append(dl.pending, queued)
+	dl.mutex.Unlock()
+	dl.signal()
+}
+
+// wakes up a worker, if none are waiting the signal is kept for the next one to check
+func (dl *Deliverer) signal() {
+	select {
+	case dl.wake <- struct{}{}:
+	default:
+	}
+}
+
+// takes the next delivery that's due from the queue, or returns how long until one is (-1 if the queue is empty)
+func (dl *Deliverer) next() (queuedDelivery, time.Duration, bool) {
+	dl.mutex.Lock()
+	defer dl.mutex.Unlock()
+	now := time.Now()
+	wait := time.Duration(-1)
+	for i, queued := range dl.pending {
+		if queued.due.After(now) {
+			if until := queued.due.Sub(now); wait < 0 || until < wait {
+				wait = until
+			}
+			continue
+		}
+		dl.pending = slices.Delete(dl.pending, i, i+1)
+		dl.sending++
+		if len(dl.pending) > 0 {
+			// let another worker pick up the rest
+			dl.signal()
+		}
+		return queued, 0, true
+	}
+	return queuedDelivery{}, wait, false
+}
+
+func (dl *Deliverer) finish() {
+	dl.mutex.Lock()
+	defer dl.mutex.Unlock()
+	dl.sending--
+	if len(dl.pending) == 0 && dl.sending == 0 {
+		dl.empty.Broadcast()
+	}
+}
+
+// blocks until every queued delivery has been sent, including retries
+func (dl *Deliverer) Wait() {
+	dl.mutex.Lock()
+	defer dl.mutex.Unlock()
+	for len(dl.pending) > 0 || dl.sending > 0 {
+		dl.empty.Wait()
+	}
+}
+
+// sends queued deliveries with the configured number of workers until ctx is cancelled
+func (dl *Deliverer) Run(ctx context.Context) {
+	done := make(chan struct{})
+	for range dl.workers {
+		go func() {
+			dl.work(ctx)
+			done <- struct{}{}
+		}()
+	}
+	for range dl.workers {
+		<-done
+	}
+}
+
+func (dl *Deliverer) work(ctx context.Context) {
+	for {
+		queued, wait, ok := dl.next()
+		if !ok {
+			var due <-chan time.Time
+			if wait >= 0 {
+				due = time.After(wait)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-dl.wake:
+			case <-due:
+			}
+			continue
+		}
+		dl.deliver(ctx, queued)
+		dl.finish()
+	}
+}
+
+// adds what receivers need but the event's data leaves out, like the incident an update belongs to
+func payloadData(modified any) any {
+	switch data := modified.(type) {
+	case util.IncidentUpdate:
+		return util.WebhookUpdate{IncidentUpdate: data, IncidentID: data.IncidentID}
+	case *util.IncidentUpdate:
+		return util.WebhookUpdate{IncidentUpdate: *data, IncidentID: data.IncidentID}
+	}
+	return modified
+}
+
+// sends one attempt of a delivery and records it. timeouts and server errors are queued to be retried a few times
+// before they count as a failure, client errors won't go away on their own
+func (dl *Deliverer) deliver(ctx context.Context, queued queuedDelivery) {
+	start := time.Now()
+	statusCode, err := dl.send(ctx, queued.endpoint, queued.event, start, queued.payload)
+	retry := err != nil && ctx.Err() == nil && queued.attempt <= len(dl.RetryDelays) &&
+		(statusCode < 400 || statusCode >= 500 || statusCode == http.StatusTooManyRequests)
+
+	delivery := util.WebhookDelivery{
+		EndpointID: queued.endpoint.ID,
+		Event:      queued.event,
+		Timestamp:  start.UTC(),
+		StatusCode: statusCode,
+		Duration:   time.Since(start).Milliseconds(),
+		Success:    err == nil,
+		Attempt:    queued.attempt,
+		Retried:    retry,
+	}
+	if err != nil {
+		delivery.Error = err.Error()
+		dl.logger.Warn("webhook delivery failed", slog.Int64("endpoint", queued.endpoint.ID), slog.String("event", string(queued.event)), slog.Int("attempt", queued.attempt), slog.Any("error", err))
+	}
+
+	disabled, err := dl.database.RecordDelivery(ctx, delivery, dl.maxFailures)
+	if err != nil {
+		dl.logger.Error("error while recording webhook delivery", slog.Int64("endpoint", queued.endpoint.ID), slog.Any("error", err))
+	}
+	if disabled {
+		dl.logger.Warn("disabled webhook endpoint after repeated failures", slog.Int64("endpoint", queued.endpoint.ID), slog.Int("failures", dl.maxFailures))
+	}
+	if retry {
+		queued.due = time.Now().Add(dl.RetryDelays[queued.attempt-1])
+		queued.attempt++
+		dl.enqueue(queued)
+	}
+}
+
+func (dl *Deliverer) send(ctx context.Context, endpoint util.WebhookEndpoint, eventType util.EventType, now time.Time, payload []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.URL, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := now.Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "PluralKit-Status-Webhook")
+	req.Header.Set("X-Status-Event", string(eventType))
+	req.Header.Set("X-Status-Timestamp", strconv.FormatInt(timestamp, 10))
+	req.Header.Set("X-Status-Signature", Sign(endpoint.Secret, timestamp, payload))
+
+	resp, err := dl.HTTPClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	err = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("endpoint responded with %s", resp.Status)
+	}
+	return resp.StatusCode, err
+}
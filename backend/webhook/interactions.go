@@ -0,0 +1,217 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"pluralkit/status/util"
+	"strings"
+)
+
+// IncidentCommands are the slash commands handled by the interactions endpoint, registered with `status register-commands`
+var IncidentCommands = []ApplicationCommand{
+	{
+		Name:        "incident",
+		Description: "Manage status page incidents",
+		Contexts:    []int{0}, // guilds only, role checks need a member
+		Options: []CommandOptionSpec{
+			{
+				Type:        int(SubCommandOption),
+				Name:        "create",
+				Description: "Open a new incident",
+				Options: []CommandOptionSpec{
+					{Type: int(StringOption), Name: "name", Description: "Incident title", Required: true, MaxLength: 100},
+					{Type: int(StringOption), Name: "impact", Description: "How much this affects users", Required: true, Choices: impactChoices()},
+					{Type: int(StringOption), Name: "description", Description: "What's going on", MaxLength: 1800},
+					{Type: int(StringOption), Name: "status", Description: "Defaults to investigating", Choices: statusChoices()},
+				},
+			},
+			{
+				Type:        int(SubCommandOption),
+				Name:        "update",
+				Description: "Post an update to an incident",
+				Options: []CommandOptionSpec{
+					{Type: int(StringOption), Name: "id", Description: "Incident ID", Required: true},
+					{Type: int(StringOption), Name: "text", Description: "Update text", Required: true, MaxLength: 1800},
+					{Type: int(StringOption), Name: "status", Description: "New incident status", Choices: statusChoices()},
+				},
+			},
+			{
+				Type:        int(SubCommandOption),
+				Name:        "resolve",
+				Description: "Resolve an incident",
+				Options: []CommandOptionSpec{
+					{Type: int(StringOption), Name: "id", Description: "Incident ID", Required: true},
+					{Type: int(StringOption), Name: "text", Description: "Resolution message", MaxLength: 1800},
+				},
+			},
+		},
+	},
+}
+
+func impactChoices() []CommandChoice {
+	return []CommandChoice{
+		{Name: "none", Value: string(util.ImpactNone)},
+		{Name: "minor", Value: string(util.ImpactMinor)},
+		{Name: "major", Value: string(util.ImpactMajor)},
+	}
+}
+
+func statusChoices() []CommandChoice {
+	statuses := []util.IncidentStatus{util.StatusInvestigating, util.StatusIdentified, util.StatusMonitoring, util.StatusResolved, util.StatusMaintenance}
+	choices := make([]CommandChoice, 0, len(statuses))
+	for _, status := range statuses {
+		choices = append(choices, CommandChoice{Name: string(status), Value: string(status)})
+	}
+	return choices
+}
+
+// overwrites the application's global commands with IncidentCommands
+func RegisterCommands(ctx context.Context, config util.Config) error {
+	if config.DiscordAppID == "" || config.DiscordBotToken == "" {
+		return fmt.Errorf("registering commands needs pluralkit__status__discord_app_id and pluralkit__status__discord_bot_token")
+	}
+	header := http.Header{}
+	header.Set("Authorization", "Bot "+config.DiscordBotToken)
+	url := fmt.Sprintf("%s/applications/%s/commands", strings.TrimSuffix(config.DiscordAPIURL, "/"), config.DiscordAppID)
+	return NewDiscordClient(config).Do(ctx, http.MethodPut, url, header, IncidentCommands, nil)
+}
+
+// custom IDs of the buttons on incident messages (and the modals they open) look like "incident:<action>:<incident id>"
+func IncidentButtonID(action string, incidentID string) string {
+	return fmt.Sprintf("incident:%s:%s", action, incidentID)
+}
+
+func ParseIncidentButtonID(customID string) (action string, incidentID string, ok bool) {
+	rest, ok := strings.CutPrefix(customID, "incident:")
+	if !ok {
+		return "", "", false
+	}
+	action, incidentID, ok = strings.Cut(rest, ":")
+	return action, incidentID, ok && incidentID != ""
+}
+
+// interaction types below, see https://discord.com/developers/docs/interactions/receiving-and-responding
+
+type InteractionType int
+
+const (
+	InteractionPing        InteractionType = 1
+	InteractionCommand     InteractionType = 2
+	InteractionComponent   InteractionType = 3
+	InteractionModalSubmit InteractionType = 5
+)
+
+type InteractionCallbackType int
+
+const (
+	CallbackPong            InteractionCallbackType = 1
+	CallbackMessage         InteractionCallbackType = 4
+	CallbackDeferredMessage InteractionCallbackType = 5
+	CallbackModal           InteractionCallbackType = 9
+)
+
+type Interaction struct {
+	ID            string             `json:"id"`
+	ApplicationID string             `json:"application_id"`
+	Type          InteractionType    `json:"type"`
+	Token         string             `json:"token"`
+	Member        *InteractionMember `json:"member,omitempty"`
+	Data          InteractionData    `json:"data"`
+}
+
+type InteractionMember struct {
+	Roles []string    `json:"roles"`
+	User  DiscordUser `json:"user"`
+}
+
+type DiscordUser struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+}
+
+type InteractionData struct {
+	// slash commands
+	Name    string          `json:"name,omitempty"`
+	Options []CommandOption `json:"options,omitempty"`
+
+	// buttons and modals
+	CustomID   string          `json:"custom_id,omitempty"`
+	Components []ComponentBase `json:"components,omitempty"`
+}
+
+// a value for the named text input in a submitted modal
+func (d InteractionData) InputValue(customID string) string {
+	for _, row := range d.Components {
+		for _, input := range row.Components {
+			if input.CustomID == customID {
+				return input.Value
+			}
+		}
+	}
+	return ""
+}
+
+type CommandOption struct {
+	Name    string          `json:"name"`
+	Type    int             `json:"type"`
+	Value   any             `json:"value,omitempty"`
+	Options []CommandOption `json:"options,omitempty"`
+}
+
+// the string value of the named option, or "" if it wasn't given
+func OptionValue(options []CommandOption, name string) string {
+	for _, option := range options {
+		if option.Name == name {
+			value, _ := option.Value.(string)
+			return value
+		}
+	}
+	return ""
+}
+
+type InteractionResponse struct {
+	Type InteractionCallbackType  `json:"type"`
+	Data *InteractionResponseData `json:"data,omitempty"`
+}
+
+type InteractionResponseData struct {
+	// messages
+	Content         string           `json:"content,omitempty"`
+	Flags           int              `json:"flags,omitempty"`
+	AllowedMentions *AllowedMentions `json:"allowed_mentions,omitempty"`
+
+	// modals
+	CustomID   string          `json:"custom_id,omitempty"`
+	Title      string          `json:"title,omitempty"`
+	Components []ComponentBase `json:"components,omitempty"`
+}
+
+type ApplicationCommand struct {
+	Name        string              `json:"name"`
+	Description string              `json:"description"`
+	Contexts    []int               `json:"contexts,omitempty"`
+	Options     []CommandOptionSpec `json:"options,omitempty"`
+}
+
+type CommandOptionSpec struct {
+	Type        int                 `json:"type"`
+	Name        string              `json:"name"`
+	Description string              `json:"description"`
+	Required    bool                `json:"required,omitempty"`
+	MaxLength   int                 `json:"max_length,omitempty"`
+	Choices     []CommandChoice     `json:"choices,omitempty"`
+	Options     []CommandOptionSpec `json:"options,omitempty"`
+}
+
+type CommandChoice struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type CommandOptionType int
+
+const (
+	SubCommandOption CommandOptionType = 1
+	StringOption     CommandOptionType = 3
+)
@@ -0,0 +1,161 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"pluralkit/status/util"
+	"strings"
+	"time"
+)
+
+// mastodon counts every link as this many characters, no matter how long it actually is
+const mastodonURLLength = 23
+
+// MastodonNotifier posts statuses to a mastodon-compatible account. only new incidents and status changes (from
+// updates or from editing the incident) are posted, as replies to the incident's post, to keep followers' timelines quiet
+type MastodonNotifier struct {
+	instance   string
+	token      string
+	visibility string
+	maxChars   int
+	publicURL  string
+	httpClient *http.Client
+}
+
+func NewMastodonNotifier(config util.Config) *MastodonNotifier {
+	maxChars := config.MastodonMaxChars
+	if maxChars < 100 {
+		maxChars = 500
+	}
+	return &MastodonNotifier{
+		instance:   strings.TrimSuffix(config.MastodonInstance, "/"),
+		token:      config.MastodonToken,
+		visibility: config.MastodonVisibility,
+		maxChars:   maxChars,
+		publicURL:  strings.TrimSuffix(config.PublicURL, "/"),
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (mn *MastodonNotifier) Platform() string {
+	return "mastodon"
+}
+
+type mastodonResponse struct {
+	ID    string `json:"id"`
+	Error string `json:"error"`
+}
+
+// idempotencyKey lets the instance drop duplicate posts if a request is retried
+func (mn *MastodonNotifier) call(method string, path string, status MastodonStatus, idempotencyKey string) (string, error) {
+	content, err := json.Marshal(status)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest(method, mn.instance+path, bytes.NewReader(content))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+mn.token)
+	if idempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+	resp, err := mn.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close() //nolint:all
+
+	data := mastodonResponse{}
+	_ = json.NewDecoder(resp.Body).Decode(&data)
+	if resp.StatusCode != 200 {
+		return "", fmt.Errorf("error while posting to mastodon: %s %s", resp.Status, data.Error)
+	}
+	return data.ID, nil
+}
+
+func (mn *MastodonNotifier) post(status MastodonStatus, idempotencyKey string) (util.WebhookMessage, error) {
+	status.Visibility = mn.visibility
+	id, err := mn.call(http.MethodPost, "/api/v1/statuses", status, idempotencyKey)
+	if err != nil {
+		return util.WebhookMessage{}, err
+	}
+	return util.WebhookMessage{Ref: id}, nil
+}
+
+func (mn *MastodonNotifier) edit(msg util.WebhookMessage, status MastodonStatus) error {
+	if msg.Ref == "" {
+		return nil
+	}
+	_, err := mn.call(http.MethodPut, "/api/v1/statuses/"+msg.Ref, status, "")
+	return err
+}
+
+// fits text and a link to the incident page into the character limit, cutting the text short if it's too long
+func (mn *MastodonNotifier) compose(incident util.Incident, text string) string {
+	link := fmt.Sprintf("%s/i/%s", mn.publicURL, incident.ID)
+	available := mn.maxChars - mastodonURLLength - len("\n\n")
+
+	runes := []rune(strings.TrimSpace(text))
+	if len(runes) > available {
+		runes = append([]rune(strings.TrimSpace(string(runes[:available-1]))), '…')
+	}
+	return string(runes) + "\n\n" + link
+}
+
+func (mn *MastodonNotifier) genIncidentStatus(incident util.Incident) MastodonStatus {
+	text := fmt.Sprintf("%s new incident: %s\nstatus: %s · impact: %s\n\n%s",
+		colorEmoji(impactColor(incident.Impact)), incident.Name, incident.Status, incident.Impact, incident.Description)
+	return MastodonStatus{Status: mn.compose(incident, text)}
+}
+
+func (mn *MastodonNotifier) genUpdateStatus(incident util.Incident, update util.IncidentUpdate) MastodonStatus {
+	title := "update"
+	if update.Status != nil && *update.Status == util.StatusResolved {
+		title = "resolved"
+	}
+	text := fmt.Sprintf("%s %s: %s\nstatus: %s\n\n%s",
+		colorEmoji(updateColor(incident, update)), title, incident.Name, incident.Status, update.Text)
+	return MastodonStatus{Status: mn.compose(incident, text)}
+}
+
+func (mn *MastodonNotifier) SendIncident(incident util.Incident) (util.WebhookMessage, error) {
+	return mn.post(mn.genIncidentStatus(incident), "incident-"+incident.ID)
+}
+
+func (mn *MastodonNotifier) SendUpdate(parent util.WebhookMessage, incident util.Incident, update util.IncidentUpdate) (util.WebhookMessage, error) {
+	// only status changes are posted, to keep the timeline short
+	if update.Status == nil || *update.Status == update.PreviousStatus {
+		return util.WebhookMessage{}, nil
+	}
+	status := mn.genUpdateStatus(incident, update)
+	status.InReplyToID = parent.Ref
+	return mn.post(status, "update-"+update.ID)
+}
+
+// posts a status change made by editing the incident as a reply, the same way as updates that change it
+func (mn *MastodonNotifier) SendStatusChange(parent util.WebhookMessage, incident util.Incident) error {
+	status := mn.genUpdateStatus(incident, statusChangeUpdate(incident))
+	status.InReplyToID = parent.Ref
+	_, err := mn.post(status, fmt.Sprintf("status-%s-%d", incident.ID, incident.LastUpdate.UnixMilli()))
+	return err
+}
+
+func (mn *MastodonNotifier) EditIncident(msg util.WebhookMessage, incident util.Incident) error {
+	return mn.edit(msg, mn.genIncidentStatus(incident))
+}
+
+func (mn *MastodonNotifier) EditUpdate(msg util.WebhookMessage, incident util.Incident, update util.IncidentUpdate) error {
+	return mn.edit(msg, mn.genUpdateStatus(incident, update))
+}
+
+// mastodon api types below, only what we use
+
+type MastodonStatus struct {
+	Status      string `json:"status"`
+	InReplyToID string `json:"in_reply_to_id,omitempty"`
+	Visibility  string `json:"visibility,omitempty"`
+}
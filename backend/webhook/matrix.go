@@ -0,0 +1,201 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"pluralkit/status/util"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// MatrixNotifier posts to a matrix room through the client-server API, with updates threaded under their incident
+type MatrixNotifier struct {
+	homeserver string
+	token      string
+	room       string
+	httpClient *http.Client
+	txnCounter atomic.Uint64
+}
+
+func NewMatrixNotifier(config util.Config) *MatrixNotifier {
+	return &MatrixNotifier{
+		homeserver: strings.TrimSuffix(config.MatrixHomeserver, "/"),
+		token:      config.MatrixToken,
+		room:       config.MatrixRoom,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (mn *MatrixNotifier) Platform() string {
+	return "matrix"
+}
+
+type matrixResponse struct {
+	EventID string `json:"event_id"`
+	ErrCode string `json:"errcode"`
+	Error   string `json:"error"`
+}
+
+func (mn *MatrixNotifier) send(room string, content MatrixContent) (string, error) {
+	body, err := json.Marshal(content)
+	if err != nil {
+		return "", err
+	}
+
+	// transaction IDs only need to be unique per access token, they let the homeserver dedupe retried requests
+	txnID := fmt.Sprintf("status-%d-%d", time.Now().UnixNano(), mn.txnCounter.Add(1))
+	reqURL := fmt.Sprintf("%s/_matrix/client/v3/rooms/%s/send/m.room.message/%s", mn.homeserver, url.PathEscape(room), txnID)
+	req, err := http.NewRequest(http.MethodPut, reqURL, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+mn.token)
+	resp, err := mn.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close() //nolint:all
+
+	data := matrixResponse{}
+	_ = json.NewDecoder(resp.Body).Decode(&data)
+	if resp.StatusCode != 200 {
+		return "", fmt.Errorf("error while sending matrix event: %s %s", data.ErrCode, data.Error)
+	}
+	return data.EventID, nil
+}
+
+// edits replace the whole message, matrix clients show the new content in place of the original event
+func (mn *MatrixNotifier) edit(msg util.WebhookMessage, content MatrixContent) error {
+	if msg.Ref == "" {
+		return nil
+	}
+	room := msg.ChannelID
+	if room == "" {
+		room = mn.room
+	}
+
+	newContent := content
+	newContent.RelatesTo = nil
+	content.Body = "* " + content.Body
+	content.FormattedBody = "* " + content.FormattedBody
+	content.NewContent = &newContent
+	content.RelatesTo = &MatrixRelation{
+		RelType: "m.replace",
+		EventID: msg.Ref,
+	}
+
+	_, err := mn.send(room, content)
+	return err
+}
+
+func matrixText(text string) string {
+	return strings.ReplaceAll(html.EscapeString(text), "\n", "<br>")
+}
+
+func matrixColor(color int) string {
+	return fmt.Sprintf(`<font data-mx-color="#%06x">●</font>`, color)
+}
+
+func (mn *MatrixNotifier) genIncidentMessage(incident util.Incident) MatrixContent {
+	body := fmt.Sprintf("new incident: %s\nstatus: %s · impact: %s\n\n%s\n\nincident id: %s",
+		incident.Name, incident.Status, incident.Impact, incident.Description, incident.ID)
+	formatted := fmt.Sprintf(`<p><a href="https://status.pluralkit.me"><b>PluralKit Status</b></a></p>`+
+		`<h3>%s %s</h3><p><b>status:</b> <i>%s</i> · <b>impact:</b> <i>%s</i></p><p>%s</p>`+
+		`<p><sub>incident id: <code>%s</code> · %s</sub></p>`,
+		matrixColor(impactColor(incident.Impact)), html.EscapeString(incident.Name), incident.Status, incident.Impact,
+		matrixText(incident.Description), incident.ID, incident.Timestamp.UTC().Format(time.RFC1123))
+
+	return MatrixContent{
+		MsgType:       "m.text",
+		Body:          body,
+		Format:        "org.matrix.custom.html",
+		FormattedBody: formatted,
+	}
+}
+
+func (mn *MatrixNotifier) genUpdateMessage(incident util.Incident, update util.IncidentUpdate) MatrixContent {
+	statusText, statusHTML := "", ""
+	if update.Status != nil {
+		statusText = fmt.Sprintf("\nstatus: %s", incident.Status)
+		statusHTML = fmt.Sprintf("<p><b>status:</b> <i>%s</i></p>", incident.Status)
+	}
+	body := fmt.Sprintf("update: %s%s\n\n%s\n\nupdate id: %s · incident id: %s",
+		incident.Name, statusText, update.Text, update.ID, incident.ID)
+	formatted := fmt.Sprintf(`<h3>%s update: %s</h3>%s<p>%s</p><p><sub>update id: <code>%s</code> · incident id: <code>%s</code> · %s</sub></p>`,
+		matrixColor(updateColor(incident, update)), html.EscapeString(incident.Name), statusHTML,
+		matrixText(update.Text), update.ID, incident.ID, update.Timestamp.UTC().Format(time.RFC1123))
+
+	return MatrixContent{
+		MsgType:       "m.text",
+		Body:          body,
+		Format:        "org.matrix.custom.html",
+		FormattedBody: formatted,
+	}
+}
+
+func (mn *MatrixNotifier) SendIncident(incident util.Incident) (util.WebhookMessage, error) {
+	eventID, err := mn.send(mn.room, mn.genIncidentMessage(incident))
+	if err != nil {
+		return util.WebhookMessage{}, err
+	}
+	return util.WebhookMessage{Ref: eventID, ChannelID: mn.room}, nil
+}
+
+func (mn *MatrixNotifier) SendUpdate(parent util.WebhookMessage, incident util.Incident, update util.IncidentUpdate) (util.WebhookMessage, error) {
+	content := mn.genUpdateMessage(incident, update)
+	room := mn.room
+	if parent.Ref != "" {
+		// thread under the incident, with a reply fallback for clients that don't support threads
+		content.RelatesTo = &MatrixRelation{
+			RelType:       "m.thread",
+			EventID:       parent.Ref,
+			IsFallingBack: true,
+			InReplyTo:     &MatrixInReplyTo{EventID: parent.Ref},
+		}
+		if parent.ChannelID != "" {
+			room = parent.ChannelID
+		}
+	}
+
+	eventID, err := mn.send(room, content)
+	if err != nil {
+		return util.WebhookMessage{}, err
+	}
+	return util.WebhookMessage{Ref: eventID, ChannelID: room}, nil
+}
+
+func (mn *MatrixNotifier) EditIncident(msg util.WebhookMessage, incident util.Incident) error {
+	return mn.edit(msg, mn.genIncidentMessage(incident))
+}
+
+func (mn *MatrixNotifier) EditUpdate(msg util.WebhookMessage, incident util.Incident, update util.IncidentUpdate) error {
+	return mn.edit(msg, mn.genUpdateMessage(incident, update))
+}
+
+// event content types below
+
+type MatrixContent struct {
+	MsgType       string          `json:"msgtype"`
+	Body          string          `json:"body"`
+	Format        string          `json:"format,omitempty"`
+	FormattedBody string          `json:"formatted_body,omitempty"`
+	NewContent    *MatrixContent  `json:"m.new_content,omitempty"`
+	RelatesTo     *MatrixRelation `json:"m.relates_to,omitempty"`
+}
+
+type MatrixRelation struct {
+	RelType       string           `json:"rel_type,omitempty"`
+	EventID       string           `json:"event_id,omitempty"`
+	IsFallingBack bool             `json:"is_falling_back,omitempty"`
+	InReplyTo     *MatrixInReplyTo `json:"m.in_reply_to,omitempty"`
+}
+
+type MatrixInReplyTo struct {
+	EventID string `json:"event_id"`
+}
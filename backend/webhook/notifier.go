@@ -0,0 +1,226 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"pluralkit/status/db"
+	"pluralkit/status/util"
+)
+
+// Notifier is something incidents and updates get announced to, e.g. a discord webhook or a slack channel
+type Notifier interface {
+	// name of the platform, used to keep track of messages sent by this notifier
+	Platform() string
+
+	// the returned message only needs MessageID/Ref/ChannelID set, leave them empty if the message can't be edited later.
+	// a message returned alongside an error is still saved, for when something went wrong after the message was sent
+	SendIncident(incident util.Incident) (util.WebhookMessage, error)
+	// parent is the message this notifier sent for the incident, it's empty if there wasn't one
+	SendUpdate(parent util.WebhookMessage, incident util.Incident, update util.IncidentUpdate) (util.WebhookMessage, error)
+
+	EditIncident(msg util.WebhookMessage, incident util.Incident) error
+	EditUpdate(msg util.WebhookMessage, incident util.Incident, update util.IncidentUpdate) error
+}
+
+// StatusNotifier is implemented by notifiers that announce status changes made by editing an incident, which don't
+// come with an update. parent is the message this notifier sent for the incident, it's empty if there wasn't one
+type StatusNotifier interface {
+	SendStatusChange(parent util.WebhookMessage, incident util.Incident) error
+}
+
+// an update standing in for a status change made by editing the incident, for notifiers that announce it like one
+func statusChangeUpdate(incident util.Incident) util.IncidentUpdate {
+	status := incident.Status
+	return util.IncidentUpdate{
+		IncidentID:     incident.ID,
+		Text:           fmt.Sprintf("The incident's status was changed to %s.", status),
+		Status:         &status,
+		Timestamp:      incident.LastUpdate,
+		PreviousStatus: incident.PreviousStatus,
+	}
+}
+
+// colors used for incidents/updates across notifiers
+func impactColor(impact util.Impact) int {
+	switch impact {
+	case util.ImpactMinor:
+		return 0xfcb700
+	case util.ImpactMajor:
+		return 0xff637d
+	default:
+		return 0x99c1f1
+	}
+}
+
+func updateColor(incident util.Incident, update util.IncidentUpdate) int {
+	if update.Status != nil && *update.Status == util.StatusResolved {
+		return 0x00d390
+	}
+	return impactColor(incident.Impact)
+}
+
+// for platforms without colored messages, an emoji matching the color
+func colorEmoji(color int) string {
+	switch color {
+	case 0x00d390:
+		return "✅"
+	case 0xff637d:
+		return "🔴"
+	case 0xfcb700:
+		return "🟡"
+	default:
+		return "🔵"
+	}
+}
+
+// EventHandler gets every event as-is, for things that don't post messages the way notifiers do
+type EventHandler interface {
+	HandleEvent(ctx context.Context, event util.Event)
+}
+
+// Dispatcher sends out events to all configured notifiers and keeps track of the messages they send
+type Dispatcher struct {
+	logger    *slog.Logger
+	database  *db.DB
+	notifiers []Notifier
+	handlers  []EventHandler
+	rules     util.NotificationRules
+}
+
+func NewDispatcher(logger *slog.Logger, database *db.DB, notifiers ...Notifier) *Dispatcher {
+	moduleLogger := logger.With(slog.String("module", "notifications"))
+	return &Dispatcher{
+		logger:    moduleLogger,
+		database:  database,
+		notifiers: notifiers,
+	}
+}
+
+// sets the routing rules deciding which notifiers get which events
+func (d *Dispatcher) SetRules(rules util.NotificationRules) {
+	d.rules = rules
+}
+
+// true if the routing rules say this notifier shouldn't get the event at all.
+// only new messages are dropped, edits always go through to messages that were sent
+func (d *Dispatcher) dropped(notifier Notifier, event util.EventType, incident util.Incident) bool {
+	return d.rules.Route(notifier.Platform(), event, incident, false).Drop
+}
+
+// adds a handler that gets every event after the notifiers have been called
+func (d *Dispatcher) AddHandler(handler EventHandler) {
+	d.handlers = append(d.handlers, handler)
+}
+
+func (d *Dispatcher) Handle(ctx context.Context, event util.Event) {
+	for _, notifier := range d.notifiers {
+		err := d.notify(ctx, notifier, event)
+		if err != nil {
+			d.logger.Error("error while sending notification",
+				slog.String("platform", notifier.Platform()),
+				slog.String("event", string(event.Type)),
+				slog.Any("error", err),
+			)
+		}
+	}
+	for _, handler := range d.handlers {
+		handler.HandleEvent(ctx, event)
+	}
+}
+
+func (d *Dispatcher) notify(ctx context.Context, notifier Notifier, event util.Event) error {
+	switch event.Type {
+	case util.EventCreateIncident:
+		incident, ok := (event.Modified).(util.Incident)
+		if !ok {
+			return nil
+		}
+		if d.dropped(notifier, event.Type, incident) {
+			return nil
+		}
+
+		msg, err := notifier.SendIncident(incident)
+		return errors.Join(err, d.saveMessage(ctx, notifier, msg, incident.ID, "incident"))
+	case util.EventCreateUpdate:
+		update, ok := (event.Modified).(util.IncidentUpdate)
+		if !ok {
+			return nil
+		}
+		incident, err := d.database.GetIncident(ctx, update.IncidentID)
+		if err != nil {
+			return err
+		}
+		if d.dropped(notifier, event.Type, incident) {
+			return nil
+		}
+
+		parent, err := d.database.GetMessage(ctx, incident.ID, "incident", notifier.Platform())
+		if err != nil && !errors.Is(err, util.ErrNotFound) {
+			return err
+		}
+
+		msg, err := notifier.SendUpdate(parent, incident, update)
+		return errors.Join(err, d.saveMessage(ctx, notifier, msg, update.ID, "update"))
+	case util.EventEditIncident:
+		incident, ok := (event.Modified).(util.Incident)
+		if !ok {
+			return nil
+		}
+
+		// edit events don't include updates, which some notifiers show on the incident message
+		full, err := d.database.GetIncident(ctx, incident.ID)
+		if err == nil {
+			full.PreviousStatus = incident.PreviousStatus
+			incident = full
+		}
+
+		msg, err := d.database.GetMessage(ctx, incident.ID, "incident", notifier.Platform())
+		if err != nil && !errors.Is(err, util.ErrNotFound) {
+			return err
+		}
+		if err == nil {
+			err = notifier.EditIncident(msg, incident)
+			if err != nil {
+				return err
+			}
+		}
+
+		// status changes are announced like updates, so they're routed like them too
+		statusNotifier, ok := notifier.(StatusNotifier)
+		changed := incident.PreviousStatus != "" && incident.Status != incident.PreviousStatus
+		if !ok || !changed || d.dropped(notifier, util.EventCreateUpdate, incident) {
+			return nil
+		}
+		return statusNotifier.SendStatusChange(msg, incident)
+	case util.EventEditUpdate:
+		update, ok := (event.Modified).(util.IncidentUpdate)
+		if !ok {
+			return nil
+		}
+		incident, err := d.database.GetIncident(ctx, update.IncidentID)
+		if err != nil {
+			return err
+		}
+
+		msg, err := d.database.GetMessage(ctx, update.ID, "update", notifier.Platform())
+		if errors.Is(err, util.ErrNotFound) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		return notifier.EditUpdate(msg, incident, update)
+	}
+	return nil
+}
+
+func (d *Dispatcher) saveMessage(ctx context.Context, notifier Notifier, msg util.WebhookMessage, id string, msgType string) error {
+	if msg.MessageID == 0 && msg.Ref == "" {
+		return nil
+	}
+	msg.ID = id
+	msg.Type = msgType
+	msg.Platform = notifier.Platform()
+	return d.database.SaveMessageID(ctx, msg)
+}
@@ -0,0 +1,254 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"pluralkit/status/util"
+	"strings"
+	"time"
+)
+
+// SlackNotifier posts Block Kit messages to slack, either through the Web API (which allows editing messages later)
+// or through an incoming webhook, which is simpler to set up but can only post new messages
+type SlackNotifier struct {
+	webhookURL string
+	apiURL     string
+	token      string
+	channel    string
+	httpClient *http.Client
+}
+
+func NewSlackNotifier(config util.Config) *SlackNotifier {
+	apiURL := config.SlackAPIURL
+	if apiURL == "" {
+		apiURL = "https://slack.com/api"
+	}
+	return &SlackNotifier{
+		webhookURL: config.SlackWebhook,
+		apiURL:     strings.TrimSuffix(apiURL, "/"),
+		token:      config.SlackToken,
+		channel:    config.SlackChannel,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (sn *SlackNotifier) Platform() string {
+	return "slack"
+}
+
+// true if we post through the Web API instead of an incoming webhook
+func (sn *SlackNotifier) useAPI() bool {
+	return sn.token != "" && sn.channel != ""
+}
+
+type slackResponse struct {
+	OK      bool   `json:"ok"`
+	Error   string `json:"error"`
+	Channel string `json:"channel"`
+	TS      string `json:"ts"`
+}
+
+func (sn *SlackNotifier) call(method string, msg SlackMessage) (slackResponse, error) {
+	data := slackResponse{}
+	content, err := json.Marshal(msg)
+	if err != nil {
+		return data, err
+	}
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/%s", sn.apiURL, method), bytes.NewReader(content))
+	if err != nil {
+		return data, err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("Authorization", "Bearer "+sn.token)
+	resp, err := sn.httpClient.Do(req)
+	if err != nil {
+		return data, err
+	}
+	defer resp.Body.Close() //nolint:all
+	if resp.StatusCode != 200 {
+		return data, fmt.Errorf("error while calling slack %s: %s", method, resp.Status)
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&data)
+	if err != nil {
+		return data, err
+	}
+	if !data.OK {
+		return data, fmt.Errorf("error while calling slack %s: %s", method, data.Error)
+	}
+	return data, nil
+}
+
+func (sn *SlackNotifier) send(msg SlackMessage) (util.WebhookMessage, error) {
+	if sn.useAPI() {
+		msg.Channel = sn.channel
+		data, err := sn.call("chat.postMessage", msg)
+		if err != nil {
+			return util.WebhookMessage{}, err
+		}
+		return util.WebhookMessage{Ref: data.TS, ChannelID: data.Channel}, nil
+	}
+
+	content, err := json.Marshal(msg)
+	if err != nil {
+		return util.WebhookMessage{}, err
+	}
+	resp, err := sn.httpClient.Post(sn.webhookURL, "application/json", bytes.NewReader(content))
+	if err != nil {
+		return util.WebhookMessage{}, err
+	}
+	err = resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return util.WebhookMessage{}, errors.New("error while sending slack webhook")
+	}
+	// incoming webhooks don't tell us anything about the message, so it can't be edited
+	return util.WebhookMessage{}, err
+}
+
+func (sn *SlackNotifier) edit(ref util.WebhookMessage, msg SlackMessage) error {
+	if !sn.useAPI() || ref.Ref == "" {
+		return nil
+	}
+	msg.Channel = ref.ChannelID
+	msg.TS = ref.Ref
+	_, err := sn.call("chat.update", msg)
+	return err
+}
+
+// escapes the characters slack uses for links/mentions in mrkdwn
+func slackEscape(text string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(text)
+}
+
+func slackDate(t time.Time) string {
+	return fmt.Sprintf("<!date^%d^{date_short_pretty} at {time}|%s>", t.Unix(), t.UTC().Format(time.RFC1123))
+}
+
+func slackHeader(text string) string {
+	runes := []rune(text)
+	if len(runes) > 150 {
+		return string(runes[:149]) + "…"
+	}
+	return text
+}
+
+func (sn *SlackNotifier) genIncidentMessage(incident util.Incident) SlackMessage {
+	blocks := []SlackBlock{
+		{
+			Type:     "context",
+			Elements: []SlackText{{Type: "mrkdwn", Text: "*<https://status.pluralkit.me|PluralKit Status>*"}},
+		},
+		{
+			Type: "header",
+			Text: &SlackText{Type: "plain_text", Text: slackHeader(incident.Name)},
+		},
+		{
+			Type:     "context",
+			Elements: []SlackText{{Type: "mrkdwn", Text: fmt.Sprintf("*status:* _%s_   *impact:* _%s_", incident.Status, incident.Impact)}},
+		},
+	}
+	if incident.Description != "" {
+		blocks = append(blocks, SlackBlock{
+			Type: "section",
+			Text: &SlackText{Type: "mrkdwn", Text: slackEscape(incident.Description)},
+		})
+	}
+	blocks = append(blocks, SlackBlock{
+		Type:     "context",
+		Elements: []SlackText{{Type: "mrkdwn", Text: fmt.Sprintf("incident id: `%s` · %s", incident.ID, slackDate(incident.Timestamp))}},
+	})
+
+	return SlackMessage{
+		Text: fmt.Sprintf("new incident: %s", slackEscape(incident.Name)),
+		Attachments: []SlackAttachment{
+			{
+				Color:  fmt.Sprintf("#%06x", impactColor(incident.Impact)),
+				Blocks: blocks,
+			},
+		},
+	}
+}
+
+func (sn *SlackNotifier) genUpdateMessage(incident util.Incident, update util.IncidentUpdate) SlackMessage {
+	blocks := []SlackBlock{
+		{
+			Type:     "context",
+			Elements: []SlackText{{Type: "mrkdwn", Text: "*<https://status.pluralkit.me|PluralKit Status>*"}},
+		},
+		{
+			Type: "header",
+			Text: &SlackText{Type: "plain_text", Text: slackHeader(fmt.Sprintf("update: %s", incident.Name))},
+		},
+	}
+	if update.Status != nil {
+		blocks = append(blocks, SlackBlock{
+			Type:     "context",
+			Elements: []SlackText{{Type: "mrkdwn", Text: fmt.Sprintf("*status:* _%s_", incident.Status)}},
+		})
+	}
+	blocks = append(blocks,
+		SlackBlock{
+			Type: "section",
+			Text: &SlackText{Type: "mrkdwn", Text: slackEscape(update.Text)},
+		},
+		SlackBlock{
+			Type:     "context",
+			Elements: []SlackText{{Type: "mrkdwn", Text: fmt.Sprintf("update id: `%s` · incident id: `%s` · %s", update.ID, incident.ID, slackDate(update.Timestamp))}},
+		},
+	)
+
+	return SlackMessage{
+		Text: fmt.Sprintf("new status update: %s", slackEscape(incident.Name)),
+		Attachments: []SlackAttachment{
+			{
+				Color:  fmt.Sprintf("#%06x", updateColor(incident, update)),
+				Blocks: blocks,
+			},
+		},
+	}
+}
+
+func (sn *SlackNotifier) SendIncident(incident util.Incident) (util.WebhookMessage, error) {
+	return sn.send(sn.genIncidentMessage(incident))
+}
+
+func (sn *SlackNotifier) SendUpdate(parent util.WebhookMessage, incident util.Incident, update util.IncidentUpdate) (util.WebhookMessage, error) {
+	return sn.send(sn.genUpdateMessage(incident, update))
+}
+
+func (sn *SlackNotifier) EditIncident(msg util.WebhookMessage, incident util.Incident) error {
+	return sn.edit(msg, sn.genIncidentMessage(incident))
+}
+
+func (sn *SlackNotifier) EditUpdate(msg util.WebhookMessage, incident util.Incident, update util.IncidentUpdate) error {
+	return sn.edit(msg, sn.genUpdateMessage(incident, update))
+}
+
+// block kit types below, only what we use
+
+type SlackMessage struct {
+	Channel     string            `json:"channel,omitempty"`
+	TS          string            `json:"ts,omitempty"`
+	Text        string            `json:"text"`
+	Attachments []SlackAttachment `json:"attachments,omitempty"`
+}
+
+// attachments are the only way to get a colored bar next to blocks
+type SlackAttachment struct {
+	Color  string       `json:"color,omitempty"`
+	Blocks []SlackBlock `json:"blocks"`
+}
+
+type SlackBlock struct {
+	Type     string      `json:"type"`
+	Text     *SlackText  `json:"text,omitempty"`
+	Elements []SlackText `json:"elements,omitempty"`
+}
+
+type SlackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
@@ -0,0 +1,200 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"pluralkit/status/util"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TelegramNotifier posts to a telegram chat or channel through the Bot API, with updates sent as replies to their incident
+type TelegramNotifier struct {
+	apiURL     string
+	token      string
+	chat       string
+	publicURL  string
+	httpClient *http.Client
+}
+
+func NewTelegramNotifier(config util.Config) *TelegramNotifier {
+	apiURL := config.TelegramAPIURL
+	if apiURL == "" {
+		apiURL = "https://api.telegram.org"
+	}
+	return &TelegramNotifier{
+		apiURL:     strings.TrimSuffix(apiURL, "/"),
+		token:      config.TelegramToken,
+		chat:       config.TelegramChat,
+		publicURL:  strings.TrimSuffix(config.PublicURL, "/"),
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (tn *TelegramNotifier) Platform() string {
+	return "telegram"
+}
+
+type telegramResponse struct {
+	OK          bool   `json:"ok"`
+	ErrorCode   int    `json:"error_code"`
+	Description string `json:"description"`
+	Result      struct {
+		MessageID int64 `json:"message_id"`
+		Chat      struct {
+			ID int64 `json:"id"`
+		} `json:"chat"`
+	} `json:"result"`
+}
+
+func (tn *TelegramNotifier) call(method string, msg TelegramMessage) (telegramResponse, error) {
+	data := telegramResponse{}
+	content, err := json.Marshal(msg)
+	if err != nil {
+		return data, err
+	}
+	resp, err := tn.httpClient.Post(fmt.Sprintf("%s/bot%s/%s", tn.apiURL, tn.token, method), "application/json", bytes.NewReader(content))
+	if err != nil {
+		// the url contains the bot token, so don't pass it on in logs
+		return data, fmt.Errorf("error while calling telegram %s: request failed", method)
+	}
+	defer resp.Body.Close() //nolint:all
+
+	_ = json.NewDecoder(resp.Body).Decode(&data)
+	if !data.OK {
+		return data, fmt.Errorf("error while calling telegram %s: %d %s", method, data.ErrorCode, data.Description)
+	}
+	return data, nil
+}
+
+func (tn *TelegramNotifier) send(msg TelegramMessage) (util.WebhookMessage, error) {
+	if msg.ChatID == "" {
+		msg.ChatID = tn.chat
+	}
+	data, err := tn.call("sendMessage", msg)
+	if err != nil {
+		return util.WebhookMessage{}, err
+	}
+	return util.WebhookMessage{
+		MessageID: data.Result.MessageID,
+		ChannelID: strconv.FormatInt(data.Result.Chat.ID, 10),
+	}, nil
+}
+
+func (tn *TelegramNotifier) edit(ref util.WebhookMessage, msg TelegramMessage) error {
+	if ref.MessageID == 0 {
+		return nil
+	}
+	msg.ChatID = ref.ChannelID
+	if msg.ChatID == "" {
+		msg.ChatID = tn.chat
+	}
+	msg.MessageID = ref.MessageID
+	msg.ReplyParameters = nil
+	data, err := tn.call("editMessageText", msg)
+	// telegram errors out on edits that don't change anything, which isn't a problem for us
+	if err != nil && strings.Contains(data.Description, "message is not modified") {
+		return nil
+	}
+	return err
+}
+
+// escapes everything MarkdownV2 treats as formatting
+func telegramEscape(text string) string {
+	return telegramEscaper.Replace(text)
+}
+
+var telegramEscaper = strings.NewReplacer(
+	`\`, `\\`, "_", `\_`, "*", `\*`, "[", `\[`, "]", `\]`, "(", `\(`, ")", `\)`, "~", `\~`, "`", "\\`",
+	">", `\>`, "#", `\#`, "+", `\+`, "-", `\-`, "=", `\=`, "|", `\|`, "{", `\{`, "}", `\}`, ".", `\.`, "!", `\!`,
+)
+
+// inside links only ) and \ need escaping
+func telegramLink(text string, url string) string {
+	return fmt.Sprintf("[%s](%s)", telegramEscape(text), strings.NewReplacer(`\`, `\\`, ")", `\)`).Replace(url))
+}
+
+func (tn *TelegramNotifier) footer(incident util.Incident, ids string, timestamp time.Time) string {
+	return fmt.Sprintf("%s · %s\n%s",
+		ids, telegramEscape(timestamp.UTC().Format(time.RFC1123)),
+		telegramLink("view on PluralKit Status", fmt.Sprintf("%s/i/%s", tn.publicURL, incident.ID)))
+}
+
+func (tn *TelegramNotifier) genIncidentMessage(incident util.Incident) TelegramMessage {
+	var text strings.Builder
+	fmt.Fprintf(&text, "%s *new incident: %s*\n", colorEmoji(impactColor(incident.Impact)), telegramEscape(incident.Name))
+	fmt.Fprintf(&text, "*status:* _%s_ · *impact:* _%s_\n\n", telegramEscape(string(incident.Status)), telegramEscape(string(incident.Impact)))
+	if incident.Description != "" {
+		fmt.Fprintf(&text, "%s\n\n", telegramEscape(incident.Description))
+	}
+	text.WriteString(tn.footer(incident, fmt.Sprintf("incident id: `%s`", incident.ID), incident.Timestamp))
+
+	return TelegramMessage{
+		Text:               text.String(),
+		ParseMode:          "MarkdownV2",
+		LinkPreviewOptions: &TelegramLinkPreviewOptions{IsDisabled: true},
+	}
+}
+
+func (tn *TelegramNotifier) genUpdateMessage(incident util.Incident, update util.IncidentUpdate) TelegramMessage {
+	var text strings.Builder
+	fmt.Fprintf(&text, "%s *update: %s*\n", colorEmoji(updateColor(incident, update)), telegramEscape(incident.Name))
+	if update.Status != nil {
+		fmt.Fprintf(&text, "*status:* _%s_\n", telegramEscape(string(incident.Status)))
+	}
+	fmt.Fprintf(&text, "\n%s\n\n", telegramEscape(update.Text))
+	text.WriteString(tn.footer(incident, fmt.Sprintf("update id: `%s` · incident id: `%s`", update.ID, incident.ID), update.Timestamp))
+
+	return TelegramMessage{
+		Text:               text.String(),
+		ParseMode:          "MarkdownV2",
+		LinkPreviewOptions: &TelegramLinkPreviewOptions{IsDisabled: true},
+	}
+}
+
+func (tn *TelegramNotifier) SendIncident(incident util.Incident) (util.WebhookMessage, error) {
+	return tn.send(tn.genIncidentMessage(incident))
+}
+
+func (tn *TelegramNotifier) SendUpdate(parent util.WebhookMessage, incident util.Incident, update util.IncidentUpdate) (util.WebhookMessage, error) {
+	msg := tn.genUpdateMessage(incident, update)
+	if parent.MessageID != 0 {
+		msg.ChatID = parent.ChannelID
+		msg.ReplyParameters = &TelegramReplyParameters{
+			MessageID:                parent.MessageID,
+			AllowSendingWithoutReply: true,
+		}
+	}
+	return tn.send(msg)
+}
+
+func (tn *TelegramNotifier) EditIncident(msg util.WebhookMessage, incident util.Incident) error {
+	return tn.edit(msg, tn.genIncidentMessage(incident))
+}
+
+func (tn *TelegramNotifier) EditUpdate(msg util.WebhookMessage, incident util.Incident, update util.IncidentUpdate) error {
+	return tn.edit(msg, tn.genUpdateMessage(incident, update))
+}
+
+// bot api types below, only what we use
+
+type TelegramMessage struct {
+	ChatID             string                      `json:"chat_id"`
+	MessageID          int64                       `json:"message_id,omitempty"`
+	Text               string                      `json:"text"`
+	ParseMode          string                      `json:"parse_mode,omitempty"`
+	LinkPreviewOptions *TelegramLinkPreviewOptions `json:"link_preview_options,omitempty"`
+	ReplyParameters    *TelegramReplyParameters    `json:"reply_parameters,omitempty"`
+}
+
+type TelegramLinkPreviewOptions struct {
+	IsDisabled bool `json:"is_disabled"`
+}
+
+type TelegramReplyParameters struct {
+	MessageID                int64 `json:"message_id"`
+	AllowSendingWithoutReply bool  `json:"allow_sending_without_reply,omitempty"`
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"pluralkit/status/api"
+	"pluralkit/status/db"
+	"pluralkit/status/util"
+	"pluralkit/status/webhook"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type receivedWebhook struct {
+	Header http.Header
+	Body   []byte
+}
+
+func TestWebhookEndpoints(t *testing.T) {
+	database, _ := setupTestFileDB(t)
+	ctx := context.Background()
+
+	cfg := util.Config{AuthToken: testAuthToken, WebhookMaxFailures: 3}
+	apiInstance := api.NewAPI(cfg, slog.Default(), database)
+	router := chi.NewRouter()
+	router.Use(render.SetContentType(render.ContentTypeJSON))
+	apiInstance.SetupRoutes(router)
+
+	request := func(method string, path string, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+testAuthToken)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	var mutex sync.Mutex
+	var received []receivedWebhook
+	var failing atomic.Bool
+	var failOnce atomic.Bool
+	var holding atomic.Bool
+	release := make(chan struct{})
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mutex.Lock()
+		received = append(received, receivedWebhook{Header: r.Header, Body: body})
+		mutex.Unlock()
+		if holding.Load() {
+			<-release
+		}
+		if failing.Load() || failOnce.CompareAndSwap(true, false) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+	take := func() []receivedWebhook {
+		mutex.Lock()
+		defer mutex.Unlock()
+		out := received
+		received = nil
+		return out
+	}
+
+	deliverer := webhook.NewDeliverer(cfg, slog.Default(), database)
+	deliverer.HTTPClient = server.Client()
+	deliverer.RetryDelays = []time.Duration{time.Millisecond, time.Millisecond}
+	dispatcher := webhook.NewDispatcher(slog.Default(), database)
+	dispatcher.AddHandler(deliverer)
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go deliverer.Run(runCtx)
+	// handles an event and waits for its deliveries to be sent
+	handle := func(event util.Event) {
+		dispatcher.Handle(ctx, event)
+		deliverer.Wait()
+	}
+
+	t.Run("invalid endpoints", func(t *testing.T) {
+		rr := request(http.MethodPost, "/api/v1/admin/webhooks", `{"url": "http://example.com/hook"}`)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		rr = request(http.MethodPost, "/api/v1/admin/webhooks", `{"url": "https://example.com/hook", "events": ["explode"]}`)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	rr := request(http.MethodPost, "/api/v1/admin/webhooks", `{"url": "`+server.URL+`/hook", "events": ["create_incident", "create_update", "status_change"]}`)
+	require.Equal(t, http.StatusCreated, rr.Code)
+	var endpoint util.WebhookEndpoint
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&endpoint))
+	require.Len(t, endpoint.Secret, 64)
+	assert.True(t, endpoint.Enabled)
+	path := "/api/v1/admin/webhooks/" + strconv.FormatInt(endpoint.ID, 10)
+
+	t.Run("signed delivery", func(t *testing.T) {
+		incident, update := createTestIncident(t, database, util.ImpactMajor)
+		handle(util.Event{Type: util.EventCreateIncident, Modified: incident})
+		handle(util.Event{Type: util.EventDeleteUpdate, Modified: update})
+
+		hooks := take()
+		require.Len(t, hooks, 1)
+		header := hooks[0].Header
+		assert.Equal(t, "create_incident", header.Get("X-Status-Event"))
+		timestamp, err := strconv.ParseInt(header.Get("X-Status-Timestamp"), 10, 64)
+		require.NoError(t, err)
+		assert.Equal(t, webhook.Sign(endpoint.Secret, timestamp, hooks[0].Body), header.Get("X-Status-Signature"))
+		assert.NotEqual(t, webhook.Sign("wrong secret", timestamp, hooks[0].Body), header.Get("X-Status-Signature"))
+
+		var payload struct {
+			Event util.EventType `json:"event"`
+			Data  util.Incident  `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(hooks[0].Body, &payload))
+		assert.Equal(t, util.EventCreateIncident, payload.Event)
+		assert.Equal(t, incident.ID, payload.Data.ID)
+
+		// updates include the incident they belong to
+		handle(util.Event{Type: util.EventCreateUpdate, Modified: update})
+		hooks = take()
+		require.Len(t, hooks, 1)
+		var updatePayload struct {
+			Event util.EventType `json:"event"`
+			Data  struct {
+				ID         string `json:"id"`
+				Text       string `json:"text"`
+				IncidentID string `json:"incident_id"`
+			} `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(hooks[0].Body, &updatePayload))
+		assert.Equal(t, util.EventCreateUpdate, updatePayload.Event)
+		assert.Equal(t, update.ID, updatePayload.Data.ID)
+		assert.Equal(t, "found it", updatePayload.Data.Text)
+		assert.Equal(t, incident.ID, updatePayload.Data.IncidentID)
+
+		// secrets aren't shown again after creation
+		rr := request(http.MethodGet, "/api/v1/admin/webhooks", "")
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.NotContains(t, rr.Body.String(), endpoint.Secret)
+	})
+
+	status := util.Status{OverallStatus: util.StatusDegraded}
+	t.Run("retried before failing", func(t *testing.T) {
+		failOnce.Store(true)
+		handle(util.Event{Type: util.EventStatusChange, Modified: status})
+		assert.Len(t, take(), 2)
+
+		rr := request(http.MethodGet, path+"/deliveries", "")
+		require.Equal(t, http.StatusOK, rr.Code)
+		var deliveries []util.WebhookDelivery
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(&deliveries))
+		require.Len(t, deliveries, 4) // every attempt is logged
+		assert.True(t, deliveries[0].Success)
+		assert.Equal(t, http.StatusOK, deliveries[0].StatusCode)
+		assert.Equal(t, 2, deliveries[0].Attempt)
+		assert.False(t, deliveries[1].Success)
+		assert.True(t, deliveries[1].Retried)
+		assert.Equal(t, 1, deliveries[1].Attempt)
+	})
+
+	t.Run("disabled after repeated failures", func(t *testing.T) {
+		failing.Store(true)
+		for range 4 {
+			handle(util.Event{Type: util.EventStatusChange, Modified: status})
+		}
+		assert.Len(t, take(), 3*3) // every delivery is tried three times
+
+		rr := request(http.MethodGet, path+"/deliveries", "")
+		require.Equal(t, http.StatusOK, rr.Code)
+		var deliveries []util.WebhookDelivery
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(&deliveries))
+		require.Len(t, deliveries, 4+3*3)
+		assert.False(t, deliveries[0].Success)
+		assert.Equal(t, http.StatusInternalServerError, deliveries[0].StatusCode)
+		assert.Equal(t, util.EventStatusChange, deliveries[0].Event)
+		assert.Equal(t, 3, deliveries[0].Attempt)
+		assert.False(t, deliveries[0].Retried)
+		assert.True(t, deliveries[1].Retried)
+		assert.True(t, deliveries[9].Success)
+
+		disabled, err := database.GetEndpoint(ctx, endpoint.ID)
+		require.NoError(t, err)
+		assert.False(t, disabled.Enabled)
+		assert.Equal(t, 3, disabled.ConsecutiveFailures)
+
+		// re-enabling resets the failure count
+		failing.Store(false)
+		rr = request(http.MethodPatch, path, `{"enabled": true}`)
+		require.Equal(t, http.StatusOK, rr.Code)
+		handle(util.Event{Type: util.EventStatusChange, Modified: status})
+		assert.Len(t, take(), 1)
+		enabled, err := database.GetEndpoint(ctx, endpoint.ID)
+		require.NoError(t, err)
+		assert.True(t, enabled.Enabled)
+		assert.Equal(t, 0, enabled.ConsecutiveFailures)
+	})
+
+	t.Run("sent in the background", func(t *testing.T) {
+		holding.Store(true)
+		unblock := sync.OnceFunc(func() {
+			holding.Store(false)
+			close(release)
+		})
+		defer unblock()
+		returned := make(chan struct{})
+		go func() {
+			dispatcher.Handle(ctx, util.Event{Type: util.EventStatusChange, Modified: status})
+			close(returned)
+		}()
+		select {
+		case <-returned:
+		case <-time.After(5 * time.Second):
+			t.Fatal("handling an event waited for its delivery")
+		}
+
+		unblock()
+		deliverer.Wait()
+		assert.Len(t, take(), 1)
+	})
+
+	rr = request(http.MethodDelete, path, "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	rr = request(http.MethodGet, path+"/deliveries", "")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestDeleteAndStatusEvents(t *testing.T) {
+	eventChannel := make(chan util.Event, 10)
+	database := db.NewDB(util.Config{DBLoc: "file:delete_events?mode=memory&cache=shared", LogLevel: util.SlogLevel(slog.LevelError)}, slog.Default(), eventChannel)
+	require.NotNil(t, database)
+	defer database.CloseDB() //nolint:all
+	ctx := context.Background()
+
+	incident, update := createTestIncident(t, database, util.ImpactMinor)
+	<-eventChannel
+	<-eventChannel
+
+	status, changed := resetStatus(database)
+	assert.True(t, changed)
+	assert.Equal(t, util.StatusDegraded, status.OverallStatus)
+	_, changed = resetStatus(database)
+	assert.False(t, changed)
+
+	// edits carry the status from before them, so notifiers can tell if it changed
+	monitoring := util.StatusMonitoring
+	require.NoError(t, database.EditIncident(ctx, incident.ID, util.IncidentPatch{Status: &monitoring}))
+	edited := <-eventChannel
+	assert.Equal(t, util.EventEditIncident, edited.Type)
+	assert.Equal(t, util.StatusMonitoring, edited.Modified.(util.Incident).Status)
+	assert.Equal(t, util.StatusIdentified, edited.Modified.(util.Incident).PreviousStatus)
+
+	require.NoError(t, database.DeleteUpdate(ctx, util.IncidentUpdate{ID: update.ID}))
+	event := <-eventChannel
+	assert.Equal(t, util.EventDeleteUpdate, event.Type)
+	assert.Equal(t, "found it", event.Modified.(util.IncidentUpdate).Text)
+
+	require.NoError(t, database.DeleteIncident(ctx, util.Incident{ID: incident.ID}))
+	event = <-eventChannel
+	assert.Equal(t, util.EventDeleteIncident, event.Type)
+	assert.Equal(t, "bot <down>", event.Modified.(util.Incident).Name)
+
+	status, changed = resetStatus(database)
+	assert.True(t, changed)
+	assert.Equal(t, util.StatusOperational, status.OverallStatus)
+}
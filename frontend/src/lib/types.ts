@@ -6,14 +6,32 @@ export interface Shard {
   last_heartbeat: number;
   last_connection: number;
   last_reconnect: number;
+  disconnections: number;
+}
+
+export interface Distribution {
+  p50: number;
+  p90: number;
+  p99: number;
+  max: number;
+}
+
+export interface ShardStats {
+  latency: Distribution;
+  heartbeat_age: Distribution;
+  disconnections: number;
+  disconnection_rate: number;
 }
 
 export interface Cluster {
   avg_latency: number;
   id: number;
+  source: string;
+  num_shards: number;
   shards_up: number;
   up: boolean;
   status: string;
+  stats: ShardStats;
   shards: Shard[] | undefined;
 }
 
@@ -23,6 +41,25 @@ export interface ClustersWrapper {
   num_shards: number;
   shards_up: number;
   clusters: Cluster[] | undefined;
+  stats: ShardStats;
+  timestamp: string;
+  stale: boolean;
+  sources: ShardSource[];
+}
+
+export interface ShardSource {
+  name: string;
+  num_shards: number;
+  shards_up: number;
+  timestamp: string;
+  unknown: boolean;
+}
+
+export interface ShardLookup {
+  guild_id: string;
+  source: string;
+  shard_id: number;
+  cluster_id: number;
 }
 
 export interface ShardsWrapper {
@@ -45,6 +82,7 @@ export interface Incident {
   updates: IncidentUpdate[];
   name: string;
   description: string;
+  components?: string[];
   last_update: Date;
   resolution_timestamp: Date;
 }
@@ -55,3 +93,27 @@ export interface Status {
   active_incidents: string[];
   timestamp: Date;
 }
+
+export interface Monitor {
+  id: number;
+  name: string;
+  component?: string;
+  state: string;
+  last_ping: string;
+}
+
+export interface Probe {
+  id: number;
+  name: string;
+  component?: string;
+  type: string;
+  state: string;
+  last_check: string;
+}
+
+export interface ComponentChecks {
+  component: string;
+  state: string;
+  monitors: Monitor[];
+  probes: Probe[];
+}
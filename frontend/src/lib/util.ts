@@ -25,11 +25,6 @@ export function dateAgo(date: number) {
   return diff;
 }
 
-export const getShardID = (guild_id: string, shards_total: number) =>
-  guild_id == ""
-    ? -1
-    : Number((BigInt(guild_id) >> BigInt(22)) % BigInt(shards_total));
-
 const timestampRegex = /^<t:(\d+)(?::([tTdDfFR]))?>/;
 export const discordTimestamp = {
   name: 'discordTimestamp',